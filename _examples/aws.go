@@ -30,7 +30,7 @@ func putToURL(url, content string) {
 func main() {
 	ctx, _ := context.WithTimeout(context.Background(), time.Minute)
 
-	bucket, err := aws.OpenBucket(ctx, os.Getenv("BUCKET"))
+	bucket, err := aws.OpenBucket(ctx, os.Getenv("BUCKET"), aws.WithCreate())
 	noErr(err)
 
 	err = bucket.UploadByChunks(ctx, strings.NewReader("abcabcabc"), fileName)
@@ -21,7 +21,7 @@ func main() {
 
 	//open a bucket for working with azure cloud
 	//a bucket also called a container
-	bucket, err := azure.OpenBucket(context.Background(), "testcontainer")
+	bucket, err := azure.OpenBucket(context.Background(), "testcontainer", azure.WithCreate())
 	if err != nil {
 		return
 	}
@@ -36,8 +36,9 @@ func getFromURL(url string) string {
 func main() {
 	ctx, _ := context.WithTimeout(context.Background(), time.Minute)
 
-	bucket, err := gcp.OpenBucket(ctx, os.Getenv("BUCKET"))
+	bucket, err := gcp.OpenBucket(ctx, os.Getenv("BUCKET"), gcp.WithCreate())
 	noErr(err)
+	defer bucket.Close()
 
 	err = bucket.UploadBytes(ctx, []byte("dlfkjdklj"), fileUploadBytes)
 	noErr(err)
@@ -0,0 +1,118 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// defaultRegion is the region S3 creates buckets in when CreateBucket has no location constraint.
+const defaultRegion = "us-east-1"
+
+// Admin manages the buckets of the account the options or the default config authorize.
+type Admin struct {
+	client s3Client
+	// region is the region of the client, buckets are created there unless a location is given.
+	region string
+}
+
+var _ bucket.Admin = (*Admin)(nil)
+
+// NewAdmin uses default config (https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk) changed by opts,
+// WithCreate has no effect.
+func NewAdmin(ctx context.Context, opts ...Option) (*Admin, error) {
+	client, region, err := newClient(ctx, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return &Admin{client: client, region: region}, nil
+}
+
+// BucketExists needs s3:ListBucket on the bucket only, a bucket of another account is reported as an error.
+func (a *Admin) BucketExists(ctx context.Context, name string) (bool, error) {
+	_, err := a.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &name})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateBucket creates the bucket in the location or the region of the client, the default encryption is set
+// once it exists. S3 has no default storage class of a bucket, classes other than hot are not supported. Buckets
+// are private, public read access needs a bucket policy and is not supported.
+func (a *Admin) CreateBucket(ctx context.Context, name string, opts ...bucket.CreateOption) error {
+	o := bucket.NewCreateOptions(opts...)
+	if o.PublicRead {
+		return bucket.ErrUnsupportedPublicRead{Bucket: name}
+	}
+	if o.StorageClass != "" && o.StorageClass != bucket.StorageClassHot {
+		return bucket.ErrUnsupportedStorageClass{Class: o.StorageClass}
+	}
+	var encryption *types.ServerSideEncryptionConfiguration
+	if o.Encryption.Type != "" {
+		var err error
+		if encryption, err = bucketEncryption(o.Encryption); err != nil {
+			return err
+		}
+	}
+	region := o.Location
+	if region == "" {
+		region = a.region
+	}
+	input := &s3.CreateBucketInput{Bucket: &name}
+	if region != "" && region != defaultRegion {
+		input.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraint(region),
+		}
+	}
+	_, err := a.client.CreateBucket(ctx, input)
+	var exists *types.BucketAlreadyExists
+	var owned *types.BucketAlreadyOwnedByYou
+	if errors.As(err, &exists) || errors.As(err, &owned) {
+		return bucket.ErrBucketExists{Bucket: name}
+	}
+	if err != nil || encryption == nil {
+		return err
+	}
+	_, err = a.client.PutBucketEncryption(ctx, &s3.PutBucketEncryptionInput{
+		Bucket:                            &name,
+		ServerSideEncryptionConfiguration: encryption,
+	})
+	return err
+}
+
+func (a *Admin) DeleteBucket(ctx context.Context, name string) error {
+	_, err := a.client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: &name})
+	if isNotFound(err) {
+		return bucket.ErrBucketNotFound{Bucket: name}
+	}
+	return err
+}
+
+func (a *Admin) ListBuckets(ctx context.Context) ([]string, error) {
+	out, err := a.client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(out.Buckets))
+	for _, b := range out.Buckets {
+		names = append(names, deref(b.Name))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isNotFound reports a missing bucket, HEAD responses have no body with an error code.
+func isNotFound(err error) bool {
+	var re interface{ HTTPStatusCode() int }
+	return errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotFound
+}
@@ -3,21 +3,48 @@ package aws
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"io"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 type s3Client interface {
 	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
 	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
 	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	ListObjectVersions(ctx context.Context, params *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+	GetObjectTagging(ctx context.Context, params *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+	PutObjectTagging(ctx context.Context, params *s3.PutObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.PutObjectTaggingOutput, error)
+	GetBucketLifecycleConfiguration(ctx context.Context, params *s3.GetBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfiguration(ctx context.Context, params *s3.PutBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error)
+	DeleteBucketLifecycle(ctx context.Context, params *s3.DeleteBucketLifecycleInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketLifecycleOutput, error)
+	PutBucketEncryption(ctx context.Context, params *s3.PutBucketEncryptionInput, optFns ...func(*s3.Options)) (*s3.PutBucketEncryptionOutput, error)
+	HeadBucket(ctx context.Context, params *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	CreateBucket(ctx context.Context, params *s3.CreateBucketInput, optFns ...func(*s3.Options)) (*s3.CreateBucketOutput, error)
+	DeleteBucket(ctx context.Context, params *s3.DeleteBucketInput, optFns ...func(*s3.Options)) (*s3.DeleteBucketOutput, error)
+	ListBuckets(ctx context.Context, params *s3.ListBucketsInput, optFns ...func(*s3.Options)) (*s3.ListBucketsOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	RestoreObject(ctx context.Context, params *s3.RestoreObjectInput, optFns ...func(*s3.Options)) (*s3.RestoreObjectOutput, error)
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
 }
 
 type s3PresignClient interface {
@@ -25,6 +52,9 @@ type s3PresignClient interface {
 	PresignPutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.PresignOptions)) (*v4.PresignedHTTPRequest, error)
 }
 
+// deleteObjectsMax is the number of keys S3 accepts in one DeleteObjects request.
+const deleteObjectsMax = 1000
+
 type AWSBucket struct {
 	client   s3Client
 	bucket   string
@@ -32,80 +62,124 @@ type AWSBucket struct {
 }
 
 var _ bucket.Bucket = (*AWSBucket)(nil)
+var _ bucket.Lifecycler = (*AWSBucket)(nil)
+var _ bucket.StorageClassSetter = (*AWSBucket)(nil)
 
-// OpenBucket uses default config (https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk)
-func OpenBucket(ctx context.Context, bucket string) (*AWSBucket, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
+// OpenBucket uses default config (https://aws.github.io/aws-sdk-go-v2/docs/configuring-sdk) changed by opts,
+// presigned URLs are generated for the same endpoint. The bucket has to exist unless WithCreate is given,
+// it is checked by HeadBucket.
+func OpenBucket(ctx context.Context, bucketName string, opts ...Option) (*AWSBucket, error) {
+	o := newOptions(opts)
+	s3Client, region, err := newClient(ctx, o)
 	if err != nil {
 		return nil, err
 	}
-	s3Client := s3.NewFromConfig(cfg)
-	listBuckets, err := s3Client.ListBuckets(ctx, &s3.ListBucketsInput{})
-	if err != nil {
-		return nil, err
-	}
-	m := 0
-	for _, b := range listBuckets.Buckets {
-		if *b.Name == bucket {
-			m++
-		}
-	}
-	if m != 0 {
-		return &AWSBucket{
-			client:   s3Client,
-			bucket:   bucket,
-			psClient: s3.NewPresignClient(s3Client),
-		}, nil
-	}
-	_, err = s3Client.CreateBucket(ctx, &s3.CreateBucketInput{
-		Bucket: &bucket,
-		CreateBucketConfiguration: &types.CreateBucketConfiguration{
-			LocationConstraint: types.BucketLocationConstraintEuCentral1,
-		},
-	})
-	if err != nil {
+	admin := &Admin{client: s3Client, region: region}
+	if err := bucket.EnsureBucket(ctx, admin, bucketName, o.create, o.createOpts...); err != nil {
 		return nil, err
 	}
 	return &AWSBucket{
 		client:   s3Client,
-		bucket:   bucket,
+		bucket:   bucketName,
 		psClient: s3.NewPresignClient(s3Client),
 	}, nil
 }
 
-func (c *AWSBucket) UploadByChunks(ctx context.Context, content io.Reader, filename string) error {
-	_, err := c.client.PutObject(ctx, &s3.PutObjectInput{
+func (c *AWSBucket) UploadByChunks(ctx context.Context, content io.Reader, filename string, opts ...bucket.Option) error {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.ErrUnsupportedTTL{TTL: o.TTL}
+	}
+	return c.putObject(ctx, o.ProgressReader(content, -1), filename, o)
+}
+
+// putObject uploads body with a single PutObject request.
+func (c *AWSBucket) putObject(ctx context.Context, body io.Reader, filename string, o bucket.Options) error {
+	input := &s3.PutObjectInput{
 		Bucket: &c.bucket,
 		Key:    &filename,
-		Body:   content,
-	})
+		Body:   body,
+	}
+	if err := putEncryption(input, o.Encryption); err != nil {
+		return err
+	}
+	class, err := s3Class(o.StorageClass)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return err
+	}
+	input.StorageClass = class
+	_, err = c.client.PutObject(ctx, input, putConditions(o)...)
+	if err != nil {
+		return fmt.Errorf("%w", preconditionErr(err))
 	}
 	return nil
 }
 
-func (c *AWSBucket) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string) error {
-	err := c.UploadByChunks(ctx, bytes.NewReader(fileAsBytes), objName)
+// putConditions sets the conditional headers of PutObject, PutObjectInput of this SDK version has no fields for them.
+func putConditions(o bucket.Options) []func(*s3.Options) {
+	if o.IfMatch == "" && o.IfNoneMatch == "" {
+		return nil
+	}
+	return []func(*s3.Options){func(opts *s3.Options) {
+		if o.IfMatch != "" {
+			opts.APIOptions = append(opts.APIOptions, smithyhttp.SetHeaderValue("If-Match", o.IfMatch))
+		}
+		if o.IfNoneMatch != "" {
+			opts.APIOptions = append(opts.APIOptions, smithyhttp.SetHeaderValue("If-None-Match", o.IfNoneMatch))
+		}
+	}}
+}
+
+// preconditionErr turns the responses to failed conditional requests into bucket.ErrPreconditionFailed.
+func preconditionErr(err error) error {
+	var re interface{ HTTPStatusCode() int }
+	if errors.As(err, &re) {
+		switch re.HTTPStatusCode() {
+		case http.StatusPreconditionFailed, http.StatusNotModified:
+			return bucket.ErrPreconditionFailed{}
+		}
+	}
+	return err
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (c *AWSBucket) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string, opts ...bucket.Option) error {
+	err := c.UploadByChunks(ctx, bytes.NewReader(fileAsBytes), objName, opts...)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
 	return nil
 }
 
-func (c *AWSBucket) DownloadByChunks(ctx context.Context, filename string) (io.ReadCloser, error) {
-	res, err := c.client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: &c.bucket,
-		Key:    &filename,
-	})
+func (c *AWSBucket) DownloadByChunks(ctx context.Context, filename string, opts ...bucket.Option) (io.ReadCloser, error) {
+	o := bucket.NewOptions(opts...)
+	input := &s3.GetObjectInput{
+		Bucket:      &c.bucket,
+		Key:         &filename,
+		IfMatch:     optional(o.IfMatch),
+		IfNoneMatch: optional(o.IfNoneMatch),
+	}
+	if !o.IfModifiedSince.IsZero() {
+		input.IfModifiedSince = &o.IfModifiedSince
+	}
+	if err := getEncryption(input, o.Encryption); err != nil {
+		return nil, err
+	}
+	res, err := c.client.GetObject(ctx, input)
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", preconditionErr(err))
 	}
-	return res.Body, nil
+	return bucket.Stream(o.ProgressReadCloser(res.Body, res.ContentLength), res.ContentLength, nil), nil
 }
 
-func (c *AWSBucket) DownloadBytes(ctx context.Context, objName string) ([]byte, error) {
-	rc, err := c.DownloadByChunks(ctx, objName)
+func (c *AWSBucket) DownloadBytes(ctx context.Context, objName string, opts ...bucket.Option) ([]byte, error) {
+	rc, err := c.DownloadByChunks(ctx, objName, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
@@ -128,6 +202,54 @@ func (c *AWSBucket) Delete(ctx context.Context, filename string) error {
 	return nil
 }
 
+// DeleteMany removes the objects with DeleteObjects requests of up to 1000 keys.
+func (c *AWSBucket) DeleteMany(ctx context.Context, filenames []string) error {
+	errs := make(map[string]error)
+	for start := 0; start < len(filenames); start += deleteObjectsMax {
+		end := start + deleteObjectsMax
+		if end > len(filenames) {
+			end = len(filenames)
+		}
+		batch := filenames[start:end]
+		ids := make([]types.ObjectIdentifier, len(batch))
+		for i := range batch {
+			ids[i] = types.ObjectIdentifier{Key: &batch[i]}
+		}
+		res, err := c.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: &c.bucket,
+			Delete: &types.Delete{Objects: ids, Quiet: true},
+		})
+		if err != nil {
+			for _, name := range batch {
+				errs[name] = fmt.Errorf("%w", err)
+			}
+			continue
+		}
+		for _, e := range res.Errors {
+			errs[deref(e.Key)] = fmt.Errorf("%s: %s", deref(e.Code), deref(e.Message))
+		}
+	}
+	if len(errs) > 0 {
+		return bucket.ErrDeleteFailed{Errors: errs}
+	}
+	return nil
+}
+
+func (c *AWSBucket) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := c.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return c.DeleteMany(ctx, bucket.Names(objects))
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
 func (c *AWSBucket) GenerateGetObjectSignedURL(ctx context.Context, filename string, ttl time.Time) (string, error) {
 	presignedHTTPRequest, err := c.psClient.PresignGetObject(ctx,
 		&s3.GetObjectInput{
@@ -155,3 +277,275 @@ func (c *AWSBucket) GeneratePutObjectSignedURL(ctx context.Context, filename str
 	}
 	return presignedHTTPRequest.URL, nil
 }
+
+func (c *AWSBucket) List(ctx context.Context, prefix string) ([]bucket.ObjectAttrs, error) {
+	var objects []bucket.ObjectAttrs
+	input := &s3.ListObjectsV2Input{
+		Bucket: &c.bucket,
+		Prefix: &prefix,
+	}
+	for {
+		res, err := c.client.ListObjectsV2(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		for _, o := range res.Contents {
+			objects = append(objects, objectAttrs(o))
+		}
+		if !res.IsTruncated {
+			return objects, nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// Stat sends the customer key of opts, S3 answers HEAD requests for objects encrypted with one only with the key.
+func (c *AWSBucket) Stat(ctx context.Context, filename string, opts ...bucket.Option) (bucket.ObjectAttrs, error) {
+	o := bucket.NewOptions(opts...)
+	input := &s3.HeadObjectInput{
+		Bucket: &c.bucket,
+		Key:    &filename,
+	}
+	if err := headObjectEncryption(input, o.Encryption); err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	res, err := c.client.HeadObject(ctx, input)
+	if err != nil {
+		return bucket.ObjectAttrs{}, fmt.Errorf("%w", err)
+	}
+	attrs := objectAttrs(types.Object{
+		Key:          &filename,
+		Size:         res.ContentLength,
+		ETag:         res.ETag,
+		LastModified: res.LastModified,
+	})
+	if res.ContentType != nil {
+		attrs.ContentType = *res.ContentType
+	}
+	attrs.Encryption = headEncryption(res)
+	attrs.MD5 = contentMD5(res.ETag, attrs.Encryption)
+	attrs.StorageClass = storageClassOf(string(res.StorageClass))
+	attrs.Rehydration = rehydration(res.Restore)
+	return attrs, nil
+}
+
+// ListVersions lists the versions of the key, S3 returns them newest first. Delete markers are skipped.
+func (c *AWSBucket) ListVersions(ctx context.Context, filename string) ([]bucket.ObjectVersion, error) {
+	var versions []bucket.ObjectVersion
+	input := &s3.ListObjectVersionsInput{
+		Bucket: &c.bucket,
+		Prefix: &filename,
+	}
+	for {
+		res, err := c.client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		for _, v := range res.Versions {
+			if deref(v.Key) != filename {
+				continue
+			}
+			versions = append(versions, bucket.ObjectVersion{
+				ObjectAttrs: objectAttrs(types.Object{Key: v.Key, Size: v.Size, ETag: v.ETag, LastModified: v.LastModified}),
+				VersionID:   deref(v.VersionId),
+				IsLatest:    v.IsLatest,
+			})
+		}
+		if !res.IsTruncated {
+			return versions, nil
+		}
+		input.KeyMarker = res.NextKeyMarker
+		input.VersionIdMarker = res.NextVersionIdMarker
+	}
+}
+
+func (c *AWSBucket) DownloadVersion(ctx context.Context, filename, versionID string, opts ...bucket.Option) (io.ReadCloser, error) {
+	o := bucket.NewOptions(opts...)
+	input := &s3.GetObjectInput{
+		Bucket:    &c.bucket,
+		Key:       &filename,
+		VersionId: &versionID,
+	}
+	if err := getEncryption(input, o.Encryption); err != nil {
+		return nil, err
+	}
+	res, err := c.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return bucket.Stream(o.ProgressReadCloser(res.Body, res.ContentLength), res.ContentLength, nil), nil
+}
+
+// DeleteVersion removes the version permanently, deleting the latest version makes the previous one current.
+func (c *AWSBucket) DeleteVersion(ctx context.Context, filename, versionID string) error {
+	_, err := c.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    &c.bucket,
+		Key:       &filename,
+		VersionId: &versionID,
+	})
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+func (c *AWSBucket) GetTags(ctx context.Context, filename string) (map[string]string, error) {
+	res, err := c.client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: &c.bucket,
+		Key:    &filename,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	tags := make(map[string]string, len(res.TagSet))
+	for _, t := range res.TagSet {
+		tags[deref(t.Key)] = deref(t.Value)
+	}
+	return tags, nil
+}
+
+// SetTags replaces the object tagging, S3 allows up to 10 tags per object.
+func (c *AWSBucket) SetTags(ctx context.Context, filename string, tags map[string]string) error {
+	tagSet := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		k, v := k, v
+		tagSet = append(tagSet, types.Tag{Key: &k, Value: &v})
+	}
+	sort.Slice(tagSet, func(i, j int) bool { return *tagSet[i].Key < *tagSet[j].Key })
+	_, err := c.client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  &c.bucket,
+		Key:     &filename,
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// FindByTags lists the bucket and fetches the tagging of every object, S3 has no query by tags.
+func (c *AWSBucket) FindByTags(ctx context.Context, filter map[string]string) ([]string, error) {
+	objects, err := c.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return bucket.FilterByTags(ctx, bucket.Names(objects), filter, c.GetTags)
+}
+
+// transitionClasses maps the storage classes to the S3 classes lifecycle rules can transition to.
+var transitionClasses = map[bucket.StorageClass]types.TransitionStorageClass{
+	bucket.StorageClassCool:    types.TransitionStorageClassStandardIa,
+	bucket.StorageClassCold:    types.TransitionStorageClassGlacier,
+	bucket.StorageClassArchive: types.TransitionStorageClassDeepArchive,
+}
+
+func (c *AWSBucket) GetLifecycle(ctx context.Context) ([]bucket.LifecycleRule, error) {
+	res, err := c.client.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: &c.bucket,
+	})
+	var ae smithy.APIError
+	if errors.As(err, &ae) && ae.ErrorCode() == "NoSuchLifecycleConfiguration" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	var rules []bucket.LifecycleRule
+	for _, r := range res.Rules {
+		if r.Status != types.ExpirationStatusEnabled {
+			continue
+		}
+		rule := bucket.LifecycleRule{ID: deref(r.ID), Prefix: deref(r.Prefix)}
+		if p, ok := r.Filter.(*types.LifecycleRuleFilterMemberPrefix); ok {
+			rule.Prefix = p.Value
+		}
+		if r.Expiration != nil {
+			rule.ExpireAfterDays = int(r.Expiration.Days)
+		}
+		for _, t := range r.Transitions {
+			for class, s3Class := range transitionClasses {
+				if s3Class == t.StorageClass {
+					rule.TransitionAfterDays = int(t.Days)
+					rule.TransitionStorageClass = class
+				}
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// SetLifecycle replaces the lifecycle configuration of the bucket, hot is not a transition target in S3.
+func (c *AWSBucket) SetLifecycle(ctx context.Context, rules []bucket.LifecycleRule) error {
+	if err := bucket.ValidateLifecycle(rules); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		_, err := c.client.DeleteBucketLifecycle(ctx, &s3.DeleteBucketLifecycleInput{Bucket: &c.bucket})
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		return nil
+	}
+	s3Rules := make([]types.LifecycleRule, 0, len(rules))
+	for _, r := range rules {
+		id := r.ID
+		s3Rule := types.LifecycleRule{
+			ID:     &id,
+			Status: types.ExpirationStatusEnabled,
+			Filter: &types.LifecycleRuleFilterMemberPrefix{Value: r.Prefix},
+		}
+		if r.ExpireAfterDays > 0 {
+			s3Rule.Expiration = &types.LifecycleExpiration{Days: int32(r.ExpireAfterDays)}
+		}
+		if r.TransitionAfterDays > 0 {
+			class, ok := transitionClasses[r.TransitionStorageClass]
+			if !ok {
+				return bucket.ErrUnsupportedStorageClass{Class: r.TransitionStorageClass}
+			}
+			s3Rule.Transitions = []types.Transition{{Days: int32(r.TransitionAfterDays), StorageClass: class}}
+		}
+		s3Rules = append(s3Rules, s3Rule)
+	}
+	_, err := c.client.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 &c.bucket,
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: s3Rules},
+	})
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// objectAttrs converts a listed S3 object. Listings do not report the encryption, a plain ETag of 32 hex digits
+// is taken as the MD5 of the content, Stat corrects it for SSE-KMS and SSE-C objects.
+func objectAttrs(o types.Object) bucket.ObjectAttrs {
+	attrs := bucket.ObjectAttrs{
+		Name: *o.Key,
+		Size: o.Size,
+	}
+	if o.ETag != nil {
+		attrs.ETag = *o.ETag
+	}
+	attrs.MD5 = contentMD5(o.ETag, bucket.Encryption{})
+	if o.LastModified != nil {
+		attrs.Updated = *o.LastModified
+	}
+	if o.StorageClass != "" {
+		attrs.StorageClass = storageClassOf(string(o.StorageClass))
+	}
+	return attrs
+}
+
+// contentMD5 returns the MD5 of the content carried by the ETag of a single-part upload stored unencrypted or
+// with SSE-S3. The ETags of SSE-KMS and SSE-C objects are no content hash, multipart ETags carry a "-N" suffix.
+func contentMD5(etag *string, e bucket.Encryption) []byte {
+	if etag == nil || (e.Type != "" && e.Type != bucket.EncryptionProviderKey) {
+		return nil
+	}
+	sum, err := hex.DecodeString(strings.Trim(*etag, `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return sum
+}
@@ -3,17 +3,27 @@ package aws
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"git.epam.com/epm-gdsp/cloud-uploader-lab/mocks/aws"
 
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 )
@@ -112,6 +122,19 @@ func (s *Suite) TestDownloadBytes() {
 	}
 }
 
+func (s *Suite) TestDownloadByChunksTruncated() {
+	ctx := context.Background()
+	fileName := "fileName"
+	s.s3Client.On("GetObject", ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: &fileName}).Once().
+		Return(&s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("abc")), ContentLength: 6}, nil)
+
+	rc, err := s.awsClient.DownloadByChunks(ctx, fileName)
+	s.Require().NoError(err)
+	_, err = io.ReadAll(rc)
+	s.ErrorIs(err, io.ErrUnexpectedEOF)
+	s.NoError(rc.Close())
+}
+
 func (s *Suite) TestDelete() {
 	ctx := context.Background()
 	fileName := "fileName"
@@ -199,3 +222,710 @@ func (s *Suite) TestGenerateObjectSignURL() {
 		})
 	}
 }
+
+func (s *Suite) TestList() {
+	ctx := context.Background()
+	prefix := "dir/"
+	token := "token"
+	first, second := "dir/a", "dir/b"
+	etag := `"900150983cd24fb0d6963f7d28e17f72"`
+	multipartETag := `"900150983cd24fb0d6963f7d28e17f72-2"`
+	updated := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	s.s3Client.On("ListObjectsV2", ctx, &s3.ListObjectsV2Input{
+		Bucket: &s.bucket,
+		Prefix: &prefix,
+	}).Once().Return(&s3.ListObjectsV2Output{
+		Contents:              []types.Object{{Key: &first, Size: 3, ETag: &etag, LastModified: &updated}},
+		IsTruncated:           true,
+		NextContinuationToken: &token,
+	}, nil)
+	s.s3Client.On("ListObjectsV2", ctx, &s3.ListObjectsV2Input{
+		Bucket:            &s.bucket,
+		Prefix:            &prefix,
+		ContinuationToken: &token,
+	}).Once().Return(&s3.ListObjectsV2Output{
+		Contents: []types.Object{{Key: &second, Size: 4, ETag: &multipartETag}},
+	}, nil)
+
+	list, err := s.awsClient.List(ctx, prefix)
+	s.NoError(err)
+	s.Require().Len(list, 2)
+	s.Equal(bucket.ObjectAttrs{
+		Name:    first,
+		Size:    3,
+		MD5:     []byte{0x90, 0x01, 0x50, 0x98, 0x3c, 0xd2, 0x4f, 0xb0, 0xd6, 0x96, 0x3f, 0x7d, 0x28, 0xe1, 0x7f, 0x72},
+		ETag:    etag,
+		Updated: updated,
+	}, list[0], "a plain ETag is the MD5")
+	s.Equal(second, list[1].Name)
+	s.Empty(list[1].MD5)
+}
+
+func (s *Suite) TestStat() {
+	ctx := context.Background()
+	fileName := "fileName"
+	etag := `"900150983cd24fb0d6963f7d28e17f72"`
+	contentType := "text/plain"
+	updated := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	headObjectInput := s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &fileName,
+	}
+
+	s.s3Client.On("HeadObject", ctx, &headObjectInput).Once().Return(&s3.HeadObjectOutput{
+		ContentLength: 3,
+		ContentType:   &contentType,
+		ETag:          &etag,
+		LastModified:  &updated,
+	}, nil)
+	attrs, err := s.awsClient.Stat(ctx, fileName)
+	s.NoError(err)
+	s.Equal(bucket.ObjectAttrs{
+		Name:         fileName,
+		Size:         3,
+		MD5:          []byte{0x90, 0x01, 0x50, 0x98, 0x3c, 0xd2, 0x4f, 0xb0, 0xd6, 0x96, 0x3f, 0x7d, 0x28, 0xe1, 0x7f, 0x72},
+		ETag:         etag,
+		ContentType:  contentType,
+		Updated:      updated,
+		StorageClass: bucket.StorageClassHot,
+	}, attrs)
+
+	keyID := "alias/uploads"
+	s.s3Client.On("HeadObject", ctx, &headObjectInput).Once().Return(&s3.HeadObjectOutput{
+		ContentLength:        3,
+		ETag:                 &etag,
+		ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          &keyID,
+	}, nil)
+	attrs, err = s.awsClient.Stat(ctx, fileName)
+	s.NoError(err)
+	s.Empty(attrs.MD5, "the ETag of a KMS encrypted object is not the MD5")
+
+	e := errors.New("error")
+	s.s3Client.On("HeadObject", ctx, &headObjectInput).Once().Return(nil, e)
+	_, err = s.awsClient.Stat(ctx, fileName)
+	s.Equal(e, errors.Unwrap(err))
+}
+
+func (s *Suite) TestDeleteMany() {
+	ctx := context.Background()
+	names := make([]string, deleteObjectsMax+1)
+	for i := range names {
+		names[i] = fmt.Sprintf("file%d", i)
+	}
+	e := errors.New("error")
+	code, message := "AccessDenied", "Access Denied"
+
+	s.s3Client.On("DeleteObjects", ctx, mock.MatchedBy(func(in *s3.DeleteObjectsInput) bool {
+		return len(in.Delete.Objects) == deleteObjectsMax && in.Delete.Quiet
+	})).Once().Return(&s3.DeleteObjectsOutput{
+		Errors: []types.Error{{Key: &names[1], Code: &code, Message: &message}},
+	}, nil)
+	s.s3Client.On("DeleteObjects", ctx, mock.MatchedBy(func(in *s3.DeleteObjectsInput) bool {
+		return len(in.Delete.Objects) == 1 && *in.Delete.Objects[0].Key == names[deleteObjectsMax]
+	})).Once().Return(nil, e)
+
+	err := s.awsClient.DeleteMany(ctx, names)
+	var deleteErr bucket.ErrDeleteFailed
+	s.Require().ErrorAs(err, &deleteErr)
+	s.Len(deleteErr.Errors, 2)
+	s.EqualError(deleteErr.Errors[names[1]], "AccessDenied: Access Denied")
+	s.ErrorIs(deleteErr.Errors[names[deleteObjectsMax]], e)
+}
+
+func (s *Suite) TestDeletePrefix() {
+	ctx := context.Background()
+	prefix := "dir/"
+	name := "dir/a"
+
+	s.s3Client.On("ListObjectsV2", ctx, &s3.ListObjectsV2Input{
+		Bucket: &s.bucket,
+		Prefix: &prefix,
+	}).Once().Return(&s3.ListObjectsV2Output{Contents: []types.Object{{Key: &name}}}, nil)
+	s.s3Client.On("DeleteObjects", ctx, &s3.DeleteObjectsInput{
+		Bucket: &s.bucket,
+		Delete: &types.Delete{Objects: []types.ObjectIdentifier{{Key: &name}}, Quiet: true},
+	}).Once().Return(&s3.DeleteObjectsOutput{}, nil)
+
+	s.NoError(s.awsClient.DeletePrefix(ctx, prefix))
+}
+
+func responseError(status int) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+		Err:      errors.New(http.StatusText(status)),
+	}
+}
+
+func (s *Suite) TestUploadIfNoneMatch() {
+	ctx := context.Background()
+	fileName := "fileName"
+	withHeader := mock.MatchedBy(func(fn func(*s3.Options)) bool {
+		var o s3.Options
+		fn(&o)
+		return len(o.APIOptions) == 1
+	})
+
+	s.s3Client.On("PutObject", ctx, mock.Anything, withHeader).Once().Return(nil, responseError(http.StatusPreconditionFailed))
+
+	err := s.awsClient.UploadBytes(ctx, []byte("abc"), fileName, bucket.IfNoneMatch(bucket.ETagAny))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+}
+
+func (s *Suite) TestDownloadIfNoneMatch() {
+	ctx := context.Background()
+	fileName := "fileName"
+	etag := `"900150983cd24fb0d6963f7d28e17f72"`
+	since := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	s.s3Client.On("GetObject", ctx, &s3.GetObjectInput{
+		Bucket:          &s.bucket,
+		Key:             &fileName,
+		IfNoneMatch:     &etag,
+		IfModifiedSince: &since,
+	}).Once().Return(nil, responseError(http.StatusNotModified))
+
+	_, err := s.awsClient.DownloadBytes(ctx, fileName, bucket.IfNoneMatch(etag), bucket.IfModifiedSince(since))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+}
+
+func (s *Suite) TestListVersions() {
+	ctx := context.Background()
+	fileName := "fileName"
+	other := "fileName.bak"
+	v1, v2, v3 := "v1", "v2", "v3"
+	marker := "fileName"
+
+	s.s3Client.On("ListObjectVersions", ctx, &s3.ListObjectVersionsInput{
+		Bucket: &s.bucket,
+		Prefix: &fileName,
+	}).Once().Return(&s3.ListObjectVersionsOutput{
+		Versions: []types.ObjectVersion{
+			{Key: &fileName, VersionId: &v3, IsLatest: true, Size: 3},
+			{Key: &fileName, VersionId: &v2, Size: 2},
+		},
+		IsTruncated:         true,
+		NextKeyMarker:       &marker,
+		NextVersionIdMarker: &v2,
+	}, nil)
+	s.s3Client.On("ListObjectVersions", ctx, &s3.ListObjectVersionsInput{
+		Bucket:          &s.bucket,
+		Prefix:          &fileName,
+		KeyMarker:       &marker,
+		VersionIdMarker: &v2,
+	}).Once().Return(&s3.ListObjectVersionsOutput{
+		Versions: []types.ObjectVersion{
+			{Key: &fileName, VersionId: &v1, Size: 1},
+			{Key: &other, VersionId: &v1, IsLatest: true},
+		},
+	}, nil)
+
+	versions, err := s.awsClient.ListVersions(ctx, fileName)
+	s.NoError(err)
+	s.Require().Len(versions, 3)
+	s.Equal(bucket.ObjectVersion{ObjectAttrs: bucket.ObjectAttrs{Name: fileName, Size: 3}, VersionID: v3, IsLatest: true}, versions[0])
+	s.Equal("v2", versions[1].VersionID)
+	s.Equal(int64(1), versions[2].Size)
+}
+
+func (s *Suite) TestDownloadAndDeleteVersion() {
+	ctx := context.Background()
+	fileName := "fileName"
+	versionID := "v1"
+
+	s.s3Client.On("GetObject", ctx, &s3.GetObjectInput{
+		Bucket:    &s.bucket,
+		Key:       &fileName,
+		VersionId: &versionID,
+	}).Once().Return(&s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("abc"))}, nil)
+	s.s3Client.On("DeleteObject", ctx, &s3.DeleteObjectInput{
+		Bucket:    &s.bucket,
+		Key:       &fileName,
+		VersionId: &versionID,
+	}).Once().Return(&s3.DeleteObjectOutput{}, nil)
+
+	rc, err := s.awsClient.DownloadVersion(ctx, fileName, versionID)
+	s.Require().NoError(err)
+	data, err := io.ReadAll(rc)
+	s.NoError(err)
+	s.Equal("abc", string(data))
+
+	s.NoError(s.awsClient.DeleteVersion(ctx, fileName, versionID))
+}
+
+func (s *Suite) TestSetTags() {
+	ctx := context.Background()
+	fileName := "fileName"
+	class, gold, tenant, acme := "class", "gold", "tenant", "acme"
+
+	s.s3Client.On("PutObjectTagging", ctx, &s3.PutObjectTaggingInput{
+		Bucket: &s.bucket,
+		Key:    &fileName,
+		Tagging: &types.Tagging{TagSet: []types.Tag{
+			{Key: &class, Value: &gold},
+			{Key: &tenant, Value: &acme},
+		}},
+	}).Once().Return(&s3.PutObjectTaggingOutput{}, nil)
+
+	s.NoError(s.awsClient.SetTags(ctx, fileName, map[string]string{"tenant": "acme", "class": "gold"}))
+}
+
+func (s *Suite) TestFindByTags() {
+	ctx := context.Background()
+	prefix := ""
+	a, b := "a", "b"
+	tenant, acme, other := "tenant", "acme", "other"
+
+	s.s3Client.On("ListObjectsV2", ctx, &s3.ListObjectsV2Input{
+		Bucket: &s.bucket,
+		Prefix: &prefix,
+	}).Once().Return(&s3.ListObjectsV2Output{Contents: []types.Object{{Key: &a}, {Key: &b}}}, nil)
+	s.s3Client.On("GetObjectTagging", mock.Anything, &s3.GetObjectTaggingInput{Bucket: &s.bucket, Key: &a}).Once().
+		Return(&s3.GetObjectTaggingOutput{TagSet: []types.Tag{{Key: &tenant, Value: &acme}}}, nil)
+	s.s3Client.On("GetObjectTagging", mock.Anything, &s3.GetObjectTaggingInput{Bucket: &s.bucket, Key: &b}).Once().
+		Return(&s3.GetObjectTaggingOutput{TagSet: []types.Tag{{Key: &tenant, Value: &other}}}, nil)
+
+	names, err := s.awsClient.FindByTags(ctx, map[string]string{"tenant": "acme"})
+	s.NoError(err)
+	s.Equal([]string{"a"}, names)
+}
+
+func (s *Suite) TestSetLifecycle() {
+	ctx := context.Background()
+	tmp, logs := "tmp", "logs"
+
+	s.s3Client.On("PutBucketLifecycleConfiguration", ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: &s.bucket,
+		LifecycleConfiguration: &types.BucketLifecycleConfiguration{Rules: []types.LifecycleRule{
+			{
+				ID:         &tmp,
+				Status:     types.ExpirationStatusEnabled,
+				Filter:     &types.LifecycleRuleFilterMemberPrefix{Value: "tmp/"},
+				Expiration: &types.LifecycleExpiration{Days: 7},
+			},
+			{
+				ID:          &logs,
+				Status:      types.ExpirationStatusEnabled,
+				Filter:      &types.LifecycleRuleFilterMemberPrefix{Value: "logs/"},
+				Transitions: []types.Transition{{Days: 30, StorageClass: types.TransitionStorageClassGlacier}},
+			},
+		}},
+	}).Once().Return(&s3.PutBucketLifecycleConfigurationOutput{}, nil)
+
+	s.NoError(s.awsClient.SetLifecycle(ctx, []bucket.LifecycleRule{
+		{ID: "tmp", Prefix: "tmp/", ExpireAfterDays: 7},
+		{ID: "logs", Prefix: "logs/", TransitionAfterDays: 30, TransitionStorageClass: bucket.StorageClassCold},
+	}))
+
+	err := s.awsClient.SetLifecycle(ctx, []bucket.LifecycleRule{
+		{ID: "hot", TransitionAfterDays: 1, TransitionStorageClass: bucket.StorageClassHot},
+	})
+	s.Equal(bucket.ErrUnsupportedStorageClass{Class: bucket.StorageClassHot}, err)
+}
+
+func (s *Suite) TestGetLifecycle() {
+	ctx := context.Background()
+	tmp := "tmp"
+
+	s.s3Client.On("GetBucketLifecycleConfiguration", ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: &s.bucket,
+	}).Once().Return(&s3.GetBucketLifecycleConfigurationOutput{Rules: []types.LifecycleRule{{
+		ID:          &tmp,
+		Status:      types.ExpirationStatusEnabled,
+		Filter:      &types.LifecycleRuleFilterMemberPrefix{Value: "tmp/"},
+		Expiration:  &types.LifecycleExpiration{Days: 7},
+		Transitions: []types.Transition{{Days: 1, StorageClass: types.TransitionStorageClassStandardIa}},
+	}}}, nil)
+
+	rules, err := s.awsClient.GetLifecycle(ctx)
+	s.NoError(err)
+	s.Equal([]bucket.LifecycleRule{{
+		ID:                     "tmp",
+		Prefix:                 "tmp/",
+		ExpireAfterDays:        7,
+		TransitionAfterDays:    1,
+		TransitionStorageClass: bucket.StorageClassCool,
+	}}, rules)
+}
+
+func (s *Suite) TestGetLifecycleNotConfigured() {
+	ctx := context.Background()
+
+	s.s3Client.On("GetBucketLifecycleConfiguration", ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: &s.bucket,
+	}).Once().Return(nil, &smithy.GenericAPIError{Code: "NoSuchLifecycleConfiguration"})
+
+	rules, err := s.awsClient.GetLifecycle(ctx)
+	s.NoError(err)
+	s.Empty(rules)
+}
+
+func (s *Suite) TestAdmin() {
+	ctx := context.Background()
+	admin := &Admin{client: &s.s3Client, region: "eu-central-1"}
+	existing, missing := "existing", "missing"
+	s.s3Client.On("HeadBucket", ctx, &s3.HeadBucketInput{Bucket: &existing}).Return(&s3.HeadBucketOutput{}, nil)
+	s.s3Client.On("HeadBucket", ctx, &s3.HeadBucketInput{Bucket: &missing}).Return(nil, responseError(http.StatusNotFound))
+
+	exists, err := admin.BucketExists(ctx, existing)
+	s.NoError(err)
+	s.True(exists)
+	exists, err = admin.BucketExists(ctx, missing)
+	s.NoError(err)
+	s.False(exists)
+
+	s.s3Client.On("CreateBucket", ctx, &s3.CreateBucketInput{
+		Bucket:                    &missing,
+		CreateBucketConfiguration: &types.CreateBucketConfiguration{LocationConstraint: "eu-central-1"},
+	}).Once().Return(&s3.CreateBucketOutput{}, nil)
+	s.NoError(admin.CreateBucket(ctx, missing))
+	s.s3Client.On("CreateBucket", ctx, &s3.CreateBucketInput{Bucket: &missing}).Once().
+		Return(&s3.CreateBucketOutput{}, nil)
+	s.NoError(admin.CreateBucket(ctx, missing, bucket.WithLocation("us-east-1")))
+	s.s3Client.On("CreateBucket", ctx, &s3.CreateBucketInput{
+		Bucket:                    &existing,
+		CreateBucketConfiguration: &types.CreateBucketConfiguration{LocationConstraint: "eu-west-1"},
+	}).Once().Return(nil, &types.BucketAlreadyOwnedByYou{})
+	s.ErrorAs(admin.CreateBucket(ctx, existing, bucket.WithLocation("eu-west-1")), &bucket.ErrBucketExists{})
+	s.Equal(bucket.ErrUnsupportedStorageClass{Class: bucket.StorageClassCool},
+		admin.CreateBucket(ctx, missing, bucket.WithDefaultStorageClass(bucket.StorageClassCool)))
+	s.Equal(bucket.ErrUnsupportedPublicRead{Bucket: missing}, admin.CreateBucket(ctx, missing, bucket.WithPublicRead()))
+
+	s.s3Client.On("DeleteBucket", ctx, &s3.DeleteBucketInput{Bucket: &missing}).Once().
+		Return(nil, responseError(http.StatusNotFound))
+	s.Equal(bucket.ErrBucketNotFound{Bucket: missing}, admin.DeleteBucket(ctx, missing))
+
+	b, a := "b", "a"
+	s.s3Client.On("ListBuckets", ctx, &s3.ListBucketsInput{}).Once().
+		Return(&s3.ListBucketsOutput{Buckets: []types.Bucket{{Name: &b}, {Name: &a}}}, nil)
+	names, err := admin.ListBuckets(ctx)
+	s.NoError(err)
+	s.Equal([]string{"a", "b"}, names)
+
+	s.Equal(bucket.ErrBucketNotFound{Bucket: missing}, bucket.EnsureBucket(ctx, admin, missing, false))
+	s.NoError(bucket.EnsureBucket(ctx, admin, existing, false))
+	s.s3Client.AssertExpectations(s.T())
+}
+
+// fakeS3 is a stand-in for an S3-compatible store addressed by path, it keeps the objects of one bucket and
+// the credential scopes of the requests.
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+	scopes  []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	scope := r.URL.Query().Get("X-Amz-Credential")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scope = strings.TrimSuffix(strings.SplitN(strings.SplitN(auth, "Credential=", 2)[1], ",", 2)[0], ",")
+	}
+	f.scopes = append(f.scopes, scope)
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != f.bucket {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != http.MethodHead {
+			_, _ = io.WriteString(w, `<Error><Code>NoSuchBucket</Code><Message>m</Message></Error>`)
+		}
+		return
+	}
+	if len(parts) == 1 || parts[1] == "" {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/xml")
+			_, _ = io.WriteString(w, `<ListBucketResult><Name>`+f.bucket+`</Name><IsTruncated>false</IsTruncated>`)
+			for key, data := range f.objects {
+				_, _ = fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><LastModified>2021-11-01T10:00:00.000Z</LastModified></Contents>`, key, len(data))
+			}
+			_, _ = io.WriteString(w, `</ListBucketResult>`)
+		}
+		return
+	}
+
+	key := parts[1]
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"etag"`)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *Suite) TestS3Compatible() {
+	ctx := context.Background()
+	fake := &fakeS3{bucket: s.bucket, objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	opts := []Option{WithEndpoint(srv.URL), WithPathStyle(), WithRegion("eu-west-1"), WithStaticCredentials("AKID", "SECRET", "")}
+
+	_, err := OpenBucket(ctx, "missing", opts...)
+	s.Equal(bucket.ErrBucketNotFound{Bucket: "missing"}, err)
+	b, err := OpenBucket(ctx, s.bucket, opts...)
+	s.Require().NoError(err)
+
+	s.Require().NoError(b.UploadBytes(ctx, []byte("abc"), "dir/a"))
+	data, err := b.DownloadBytes(ctx, "dir/a")
+	s.NoError(err)
+	s.Equal("abc", string(data))
+	attrs, err := b.Stat(ctx, "dir/a")
+	s.NoError(err)
+	s.Equal(int64(3), attrs.Size)
+	list, err := b.List(ctx, "")
+	s.NoError(err)
+	s.Equal([]string{"dir/a"}, bucket.Names(list))
+
+	signed, err := b.GenerateGetObjectSignedURL(ctx, "dir/a", time.Now().Add(time.Hour))
+	s.Require().NoError(err)
+	s.True(strings.HasPrefix(signed, srv.URL+"/bucket/dir/a?"), signed)
+	resp, err := http.Get(signed)
+	s.Require().NoError(err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.NoError(err)
+	s.Equal("abc", string(body))
+
+	s.NoError(b.Delete(ctx, "dir/a"))
+	s.Empty(fake.objects)
+	for _, scope := range fake.scopes {
+		s.True(strings.HasPrefix(scope, "AKID/"), scope)
+		s.True(strings.HasSuffix(scope, "/eu-west-1/s3/aws4_request"), scope)
+	}
+
+	b, err = OpenBucket(ctx, s.bucket, WithEndpoint(srv.URL), WithPathStyle(), WithStaticCredentials("AKID", "SECRET", ""))
+	s.Require().NoError(err)
+	s.True(strings.HasSuffix(fake.scopes[len(fake.scopes)-1], "/"+defaultRegion+"/s3/aws4_request"))
+}
+
+func (s *Suite) TestEncryption() {
+	ctx := context.Background()
+	fileName := "fileName"
+	key := bytes.Repeat([]byte("k"), 32)
+	keyMD5 := md5.Sum(key)
+	s.s3Client.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return input.ServerSideEncryption == types.ServerSideEncryptionAwsKms && deref(input.SSEKMSKeyId) == "alias/uploads"
+	})).Once().Return(&s3.PutObjectOutput{}, nil)
+	s.NoError(s.awsClient.UploadBytes(ctx, []byte("abc"), fileName, bucket.WithKMSKey("alias/uploads")))
+
+	s.s3Client.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return input.ServerSideEncryption == "" && deref(input.SSECustomerAlgorithm) == "AES256" &&
+			deref(input.SSECustomerKey) == base64.StdEncoding.EncodeToString(key) &&
+			deref(input.SSECustomerKeyMD5) == base64.StdEncoding.EncodeToString(keyMD5[:])
+	})).Once().Return(&s3.PutObjectOutput{}, nil)
+	s.NoError(s.awsClient.UploadBytes(ctx, []byte("abc"), fileName, bucket.WithCustomerKey(key)))
+
+	s.s3Client.On("GetObject", ctx, mock.MatchedBy(func(input *s3.GetObjectInput) bool {
+		return deref(input.SSECustomerKey) == base64.StdEncoding.EncodeToString(key)
+	})).Once().Return(&s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader("abc")), ContentLength: 3}, nil)
+	data, err := s.awsClient.DownloadBytes(ctx, fileName, bucket.WithCustomerKey(key))
+	s.NoError(err)
+	s.Equal("abc", string(data))
+
+	s.ErrorAs(s.awsClient.UploadBytes(ctx, []byte("abc"), fileName, bucket.WithCustomerKey(key[:16])), &bucket.ErrUnsupportedEncryption{})
+
+	keyID := "arn:aws:kms:eu-central-1:111122223333:key/k"
+	s.s3Client.On("HeadObject", ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: &fileName}).Once().
+		Return(&s3.HeadObjectOutput{ServerSideEncryption: types.ServerSideEncryptionAwsKms, SSEKMSKeyId: &keyID}, nil)
+	attrs, err := s.awsClient.Stat(ctx, fileName)
+	s.NoError(err)
+	s.Equal(bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: keyID}, attrs.Encryption)
+
+	// HEAD requests for SSE-C objects are only answered with the key
+	etag := `"f1d2d2f924e986ac86fdf7b36c94bcdf"`
+	s.s3Client.On("HeadObject", ctx, &s3.HeadObjectInput{
+		Bucket:               &s.bucket,
+		Key:                  &fileName,
+		SSECustomerAlgorithm: optional("AES256"),
+		SSECustomerKey:       optional(base64.StdEncoding.EncodeToString(key)),
+		SSECustomerKeyMD5:    optional(base64.StdEncoding.EncodeToString(keyMD5[:])),
+	}).Once().Return(&s3.HeadObjectOutput{ContentLength: 3, ETag: &etag, SSECustomerAlgorithm: optional("AES256")}, nil)
+	attrs, err = s.awsClient.Stat(ctx, fileName, bucket.WithCustomerKey(key))
+	s.NoError(err)
+	s.Equal(bucket.Encryption{Type: bucket.EncryptionCustomerKey}, attrs.Encryption)
+	s.Empty(attrs.MD5)
+	_, err = s.awsClient.Stat(ctx, fileName, bucket.WithCustomerKey(key[:16]))
+	s.ErrorAs(err, &bucket.ErrUnsupportedEncryption{})
+
+	admin := &Admin{client: &s.s3Client, region: defaultRegion}
+	s.s3Client.On("CreateBucket", ctx, &s3.CreateBucketInput{Bucket: &s.bucket}).Once().Return(&s3.CreateBucketOutput{}, nil)
+	s.s3Client.On("PutBucketEncryption", ctx, mock.MatchedBy(func(input *s3.PutBucketEncryptionInput) bool {
+		rule := input.ServerSideEncryptionConfiguration.Rules[0].ApplyServerSideEncryptionByDefault
+		return *input.Bucket == s.bucket && rule.SSEAlgorithm == types.ServerSideEncryptionAwsKms && deref(rule.KMSMasterKeyID) == keyID
+	})).Once().Return(&s3.PutBucketEncryptionOutput{}, nil)
+	s.NoError(admin.CreateBucket(ctx, s.bucket, bucket.WithDefaultEncryption(bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: keyID})))
+	s.ErrorAs(admin.CreateBucket(ctx, s.bucket, bucket.WithDefaultEncryption(bucket.Encryption{Type: bucket.EncryptionCustomerKey, CustomerKey: key})),
+		&bucket.ErrUnsupportedEncryption{})
+	s.s3Client.AssertExpectations(s.T())
+}
+
+func (s *Suite) TestNewWriter() {
+	ctx := context.Background()
+	fileName := "fileName"
+	s.s3Client.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		body, _ := io.ReadAll(input.Body)
+		return *input.Key == fileName && string(body) == "abc"
+	})).Once().Return(&s3.PutObjectOutput{}, nil)
+	w := s.awsClient.NewWriter(ctx, fileName)
+	_, err := io.WriteString(w, "abc")
+	s.NoError(err)
+	s.NoError(w.Close())
+
+	w = s.awsClient.NewWriter(ctx, fileName, bucket.WithStorageClass("frozen"))
+	s.ErrorAs(w.Close(), &bucket.ErrUnsupportedStorageClass{})
+	s.s3Client.AssertExpectations(s.T())
+}
+
+// TestNewWriterMultipart writes two full parts and a short one, the last part is sent by Close.
+func (s *Suite) TestNewWriterMultipart() {
+	ctx := context.Background()
+	fileName := "fileName"
+	key := bytes.Repeat([]byte("k"), 32)
+	uploadID := "upload"
+	s.s3Client.On("CreateMultipartUpload", ctx, mock.MatchedBy(func(input *s3.CreateMultipartUploadInput) bool {
+		return *input.Key == fileName && input.StorageClass == types.StorageClassStandardIa && input.SSECustomerKey != nil
+	})).Once().Return(&s3.CreateMultipartUploadOutput{UploadId: &uploadID}, nil)
+	var sizes []int64
+	s.s3Client.On("UploadPart", ctx, mock.MatchedBy(func(input *s3.UploadPartInput) bool {
+		return *input.UploadId == uploadID && int(input.PartNumber) == len(sizes)+1 && input.SSECustomerKey != nil
+	})).Times(3).Run(func(args mock.Arguments) {
+		sizes = append(sizes, args.Get(1).(*s3.UploadPartInput).ContentLength)
+	}).Return(func(_ context.Context, input *s3.UploadPartInput, _ ...func(*s3.Options)) *s3.UploadPartOutput {
+		return &s3.UploadPartOutput{ETag: optional(strconv.Itoa(int(input.PartNumber)))}
+	}, nil)
+	s.s3Client.On("CompleteMultipartUpload", ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:   &s.bucket,
+		Key:      &fileName,
+		UploadId: &uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: []types.CompletedPart{
+			{ETag: optional("1"), PartNumber: 1}, {ETag: optional("2"), PartNumber: 2}, {ETag: optional("3"), PartNumber: 3},
+		}},
+	}).Once().Return(&s3.CompleteMultipartUploadOutput{}, nil)
+
+	w := s.awsClient.NewWriter(ctx, fileName, bucket.WithCustomerKey(key), bucket.WithStorageClass(bucket.StorageClassCool))
+	chunk := make([]byte, partSize/2)
+	for i := 0; i < 5; i++ {
+		_, err := w.Write(chunk)
+		s.NoError(err)
+	}
+	s.NoError(w.Close())
+	s.Equal([]int64{partSize, partSize, partSize / 2}, sizes)
+	s.s3Client.AssertExpectations(s.T())
+}
+
+func (s *Suite) TestNewWriterAbort() {
+	ctx := context.Background()
+	fileName := "fileName"
+	uploadID := "upload"
+	s.s3Client.On("CreateMultipartUpload", ctx, mock.Anything).Once().
+		Return(&s3.CreateMultipartUploadOutput{UploadId: &uploadID}, nil)
+	s.s3Client.On("UploadPart", ctx, mock.Anything).Once().Return(&s3.UploadPartOutput{ETag: optional("1")}, nil)
+	s.s3Client.On("AbortMultipartUpload", ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &s.bucket,
+		Key:      &fileName,
+		UploadId: &uploadID,
+	}).Once().Return(&s3.AbortMultipartUploadOutput{}, nil)
+
+	w := s.awsClient.NewWriter(ctx, fileName)
+	_, err := w.Write(make([]byte, partSize+1))
+	s.NoError(err)
+	e := errors.New("encoder failed")
+	s.NoError(w.CloseWithError(e))
+	s.Equal(e, w.Close())
+
+	// a completion rejected by the precondition aborts the upload as well
+	s.s3Client.On("CreateMultipartUpload", ctx, mock.Anything).Once().
+		Return(&s3.CreateMultipartUploadOutput{UploadId: &uploadID}, nil)
+	s.s3Client.On("UploadPart", ctx, mock.Anything).Twice().Return(&s3.UploadPartOutput{ETag: optional("1")}, nil)
+	s.s3Client.On("CompleteMultipartUpload", ctx, mock.Anything, mock.Anything).Once().
+		Return(nil, responseError(http.StatusPreconditionFailed))
+	s.s3Client.On("AbortMultipartUpload", ctx, mock.Anything).Once().Return(&s3.AbortMultipartUploadOutput{}, nil)
+	w = s.awsClient.NewWriter(ctx, fileName, bucket.IfNoneMatch(bucket.ETagAny))
+	_, err = w.Write(make([]byte, partSize+1))
+	s.NoError(err)
+	s.ErrorIs(w.Close(), bucket.ErrPreconditionFailed{})
+	s.s3Client.AssertExpectations(s.T())
+}
+
+func (s *Suite) TestTTL() {
+	ctx := context.Background()
+	s.ErrorIs(s.awsClient.UploadBytes(ctx, []byte("abc"), "a", bucket.WithTTL(time.Hour)), bucket.ErrUnsupportedTTL{TTL: time.Hour})
+	s.ErrorAs(s.awsClient.NewWriter(ctx, "a", bucket.WithTTL(time.Hour)).Close(), &bucket.ErrUnsupportedTTL{})
+	s.s3Client.AssertNotCalled(s.T(), "PutObject", mock.Anything, mock.Anything)
+}
+
+func (s *Suite) TestStorageClass() {
+	ctx := context.Background()
+	fileName := "dir/a b"
+	head := &s3.HeadObjectInput{Bucket: &s.bucket, Key: &fileName}
+	s.s3Client.On("PutObject", ctx, mock.MatchedBy(func(input *s3.PutObjectInput) bool {
+		return input.StorageClass == types.StorageClassDeepArchive
+	})).Once().Return(&s3.PutObjectOutput{}, nil)
+	s.NoError(s.awsClient.UploadBytes(ctx, []byte("abc"), fileName, bucket.WithStorageClass(bucket.StorageClassArchive)))
+	s.ErrorAs(s.awsClient.UploadBytes(ctx, []byte("abc"), fileName, bucket.WithStorageClass("frozen")), &bucket.ErrUnsupportedStorageClass{})
+
+	restoring := `ongoing-request="true"`
+	s.s3Client.On("HeadObject", ctx, head).Once().
+		Return(&s3.HeadObjectOutput{StorageClass: types.StorageClassDeepArchive, Restore: &restoring}, nil)
+	attrs, err := s.awsClient.Stat(ctx, fileName)
+	s.NoError(err)
+	s.Equal(bucket.StorageClassArchive, attrs.StorageClass)
+	s.Equal(&bucket.Rehydration{Pending: true}, attrs.Rehydration)
+
+	// archived objects are restored before the copy
+	s.s3Client.On("HeadObject", ctx, head).Twice().Return(&s3.HeadObjectOutput{StorageClass: types.StorageClassDeepArchive}, nil)
+	s.s3Client.On("RestoreObject", ctx, mock.MatchedBy(func(input *s3.RestoreObjectInput) bool {
+		return *input.Key == fileName && input.RestoreRequest.Days == restoreDays
+	})).Once().Return(&s3.RestoreObjectOutput{}, nil)
+	s.Equal(bucket.ErrRehydrationPending{Name: fileName}, s.awsClient.SetStorageClass(ctx, fileName, bucket.StorageClassHot))
+	s.s3Client.On("RestoreObject", ctx, mock.Anything).Once().Return(nil, &smithy.GenericAPIError{Code: "RestoreAlreadyInProgress"})
+	s.Equal(bucket.ErrRehydrationPending{Name: fileName}, s.awsClient.SetStorageClass(ctx, fileName, bucket.StorageClassHot))
+
+	restored := `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`
+	keyID := "alias/uploads"
+	s.s3Client.On("HeadObject", ctx, head).Once().Return(&s3.HeadObjectOutput{
+		StorageClass:         types.StorageClassDeepArchive,
+		Restore:              &restored,
+		ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          &keyID,
+	}, nil)
+	s.s3Client.On("CopyObject", ctx, &s3.CopyObjectInput{
+		Bucket:               &s.bucket,
+		Key:                  &fileName,
+		CopySource:           optional("bucket%2Fdir%2Fa%20b"),
+		StorageClass:         types.StorageClassStandard,
+		ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+		SSEKMSKeyId:          &keyID,
+	}).Once().Return(&s3.CopyObjectOutput{}, nil)
+	s.NoError(s.awsClient.SetStorageClass(ctx, fileName, bucket.StorageClassHot))
+
+	s.s3Client.On("HeadObject", ctx, head).Once().Return(&s3.HeadObjectOutput{}, nil)
+	s.NoError(s.awsClient.SetStorageClass(ctx, fileName, bucket.StorageClassHot))
+	s.s3Client.AssertExpectations(s.T())
+
+	s.Equal(&bucket.Rehydration{Expires: time.Date(2012, 12, 21, 0, 0, 0, 0, time.UTC)}, rehydration(&restored))
+	s.Equal(bucket.StorageClass(""), storageClassOf("INTELLIGENT_TIERING"))
+}
@@ -0,0 +1,110 @@
+package aws
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// sseCustomerAlgorithm is the only algorithm of SSE-C keys.
+const sseCustomerAlgorithm = "AES256"
+
+// customerKey returns the SSE-C headers of a customer key, all nil for other encryption types.
+func customerKey(e bucket.Encryption) (algorithm, key, keyMD5 *string) {
+	if e.Type != bucket.EncryptionCustomerKey {
+		return nil, nil, nil
+	}
+	sum := md5.Sum(e.CustomerKey)
+	return optional(sseCustomerAlgorithm), optional(base64.StdEncoding.EncodeToString(e.CustomerKey)),
+		optional(base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+// putEncryption sets SSE-S3, SSE-KMS or SSE-C on the upload.
+func putEncryption(input *s3.PutObjectInput, e bucket.Encryption) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	switch e.Type {
+	case bucket.EncryptionProviderKey:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case bucket.EncryptionKMS:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = optional(e.KMSKeyID)
+	case bucket.EncryptionCustomerKey:
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(e)
+	}
+	return nil
+}
+
+// createEncryption sets SSE-S3, SSE-KMS or SSE-C on a multipart upload, the parts repeat the customer key.
+func createEncryption(input *s3.CreateMultipartUploadInput, e bucket.Encryption) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	switch e.Type {
+	case bucket.EncryptionProviderKey:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case bucket.EncryptionKMS:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = optional(e.KMSKeyID)
+	case bucket.EncryptionCustomerKey:
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(e)
+	}
+	return nil
+}
+
+// getEncryption sets the customer key of the download, S3 decrypts the other types by itself.
+func getEncryption(input *s3.GetObjectInput, e bucket.Encryption) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(e)
+	return nil
+}
+
+// headObjectEncryption sets the customer key of the HEAD request, S3 reports the other types by itself.
+func headObjectEncryption(input *s3.HeadObjectInput, e bucket.Encryption) error {
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(e)
+	return nil
+}
+
+// headEncryption reads the encryption of the object from the response to HeadObject.
+func headEncryption(res *s3.HeadObjectOutput) bucket.Encryption {
+	switch {
+	case res.SSECustomerAlgorithm != nil:
+		return bucket.Encryption{Type: bucket.EncryptionCustomerKey}
+	case res.ServerSideEncryption == types.ServerSideEncryptionAwsKms:
+		return bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: deref(res.SSEKMSKeyId)}
+	case res.ServerSideEncryption == types.ServerSideEncryptionAes256:
+		return bucket.Encryption{Type: bucket.EncryptionProviderKey}
+	default:
+		return bucket.Encryption{}
+	}
+}
+
+// bucketEncryption returns the default encryption configuration of a bucket, S3 has no default customer keys.
+func bucketEncryption(e bucket.Encryption) (*types.ServerSideEncryptionConfiguration, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	rule := types.ServerSideEncryptionByDefault{}
+	switch e.Type {
+	case bucket.EncryptionProviderKey:
+		rule.SSEAlgorithm = types.ServerSideEncryptionAes256
+	case bucket.EncryptionKMS:
+		rule.SSEAlgorithm = types.ServerSideEncryptionAwsKms
+		rule.KMSMasterKeyID = optional(e.KMSKeyID)
+	default:
+		return nil, bucket.ErrUnsupportedEncryption{Type: e.Type, Reason: "S3 buckets can not default to customer keys"}
+	}
+	return &types.ServerSideEncryptionConfiguration{
+		Rules: []types.ServerSideEncryptionRule{{ApplyServerSideEncryptionByDefault: &rule}},
+	}, nil
+}
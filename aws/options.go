@@ -0,0 +1,96 @@
+package aws
+
+import (
+	"context"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Option configures OpenBucket and NewAdmin, by default the client is set up by the default config.
+type Option func(*options)
+
+type options struct {
+	create      bool
+	createOpts  []bucket.CreateOption
+	endpoint    string
+	pathStyle   bool
+	region      string
+	credentials *credentials.StaticCredentialsProvider
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithCreate makes OpenBucket create the bucket with opts when it does not exist.
+func WithCreate(opts ...bucket.CreateOption) Option {
+	return func(o *options) {
+		o.create = true
+		o.createOpts = opts
+	}
+}
+
+// WithEndpoint sends the requests and signs the URLs for an S3-compatible store such as MinIO, Ceph or
+// localstack, "http://localhost:9000" for example. Without a region the client uses us-east-1.
+func WithEndpoint(url string) Option {
+	return func(o *options) {
+		o.endpoint = url
+	}
+}
+
+// WithPathStyle addresses buckets by path, endpoint/bucket/key, instead of by host, bucket.endpoint/key.
+// Most S3-compatible stores need it.
+func WithPathStyle() Option {
+	return func(o *options) {
+		o.pathStyle = true
+	}
+}
+
+// WithRegion overrides the region of the default config.
+func WithRegion(region string) Option {
+	return func(o *options) {
+		o.region = region
+	}
+}
+
+// WithStaticCredentials authorizes the client with an access key instead of the credential chain of the default
+// config, sessionToken may be empty.
+func WithStaticCredentials(accessKeyID, secretAccessKey, sessionToken string) Option {
+	return func(o *options) {
+		provider := credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, sessionToken)
+		o.credentials = &provider
+	}
+}
+
+// newClient loads the default config with the overrides of o and returns the client with its region.
+func newClient(ctx context.Context, o options) (*s3.Client, string, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if o.region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(o.region))
+	}
+	if o.credentials != nil {
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(*o.credentials))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, "", err
+	}
+	if o.endpoint != "" && cfg.Region == "" {
+		cfg.Region = defaultRegion
+	}
+	client := s3.NewFromConfig(cfg, func(so *s3.Options) {
+		if o.endpoint != "" {
+			so.EndpointResolver = s3.EndpointResolverFromURL(o.endpoint)
+		}
+		so.UsePathStyle = o.pathStyle
+	})
+	return client, cfg.Region, nil
+}
@@ -0,0 +1,130 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// restoreDays is how long S3 keeps the readable copy of an archived object restored by SetStorageClass.
+const restoreDays = 7
+
+// storageClasses maps the storage classes to the S3 classes of uploads and copies.
+var storageClasses = map[bucket.StorageClass]types.StorageClass{
+	bucket.StorageClassHot:     types.StorageClassStandard,
+	bucket.StorageClassCool:    types.StorageClassStandardIa,
+	bucket.StorageClassCold:    types.StorageClassGlacier,
+	bucket.StorageClassArchive: types.StorageClassDeepArchive,
+}
+
+// s3Class returns the S3 class of class, empty for the default of the bucket.
+func s3Class(class bucket.StorageClass) (types.StorageClass, error) {
+	if class == "" {
+		return "", nil
+	}
+	s3Class, ok := storageClasses[class]
+	if !ok {
+		return "", bucket.ErrUnsupportedStorageClass{Class: class}
+	}
+	return s3Class, nil
+}
+
+// storageClassOf returns the storage class of an S3 class, HEAD responses omit STANDARD. Classes without
+// an equivalent, INTELLIGENT_TIERING for example, are reported as empty.
+func storageClassOf(s3Class string) bucket.StorageClass {
+	if s3Class == "" {
+		return bucket.StorageClassHot
+	}
+	for class, c := range storageClasses {
+		if string(c) == s3Class {
+			return class
+		}
+	}
+	return ""
+}
+
+// archived reports the classes whose objects have to be restored before they can be read or copied.
+func archived(s3Class types.StorageClass) bool {
+	return s3Class == types.StorageClassGlacier || s3Class == types.StorageClassDeepArchive
+}
+
+// rehydration parses the x-amz-restore header, `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`
+// once the copy is readable.
+func rehydration(restore *string) *bucket.Rehydration {
+	if restore == nil {
+		return nil
+	}
+	r := &bucket.Rehydration{Pending: strings.Contains(*restore, `ongoing-request="true"`)}
+	const expiry = `expiry-date="`
+	if i := strings.Index(*restore, expiry); i >= 0 {
+		date := (*restore)[i+len(expiry):]
+		if j := strings.IndexByte(date, '"'); j >= 0 {
+			r.Expires, _ = http.ParseTime(date[:j])
+		}
+	}
+	return r
+}
+
+// SetStorageClass copies the object onto itself in the class, keeping its metadata, tags and KMS key.
+// An archived object is restored first and ErrRehydrationPending is returned, the copy succeeds once Stat
+// reports the restore done. Objects encrypted with a customer key are not supported.
+func (c *AWSBucket) SetStorageClass(ctx context.Context, filename string, class bucket.StorageClass) error {
+	target, ok := storageClasses[class]
+	if !ok {
+		return bucket.ErrUnsupportedStorageClass{Class: class}
+	}
+	head, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &c.bucket, Key: &filename})
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	current := head.StorageClass
+	if current == "" {
+		current = types.StorageClassStandard
+	}
+	if current == target {
+		return nil
+	}
+	if archived(current) {
+		if r := rehydration(head.Restore); r == nil || r.Pending {
+			return c.restore(ctx, filename)
+		}
+	}
+	_, err = c.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:               &c.bucket,
+		Key:                  &filename,
+		CopySource:           optional(url.PathEscape(c.bucket + "/" + filename)),
+		StorageClass:         target,
+		ServerSideEncryption: head.ServerSideEncryption,
+		SSEKMSKeyId:          head.SSEKMSKeyId,
+	})
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// restore requests a readable copy of the archived object, a restore in progress is not an error.
+func (c *AWSBucket) restore(ctx context.Context, filename string) error {
+	_, err := c.client.RestoreObject(ctx, &s3.RestoreObjectInput{
+		Bucket: &c.bucket,
+		Key:    &filename,
+		RestoreRequest: &types.RestoreRequest{
+			Days:                 restoreDays,
+			GlacierJobParameters: &types.GlacierJobParameters{Tier: types.TierStandard},
+		},
+	})
+	var ae smithy.APIError
+	if err != nil && !(errors.As(err, &ae) && ae.ErrorCode() == "RestoreAlreadyInProgress") {
+		return fmt.Errorf("%w", err)
+	}
+	return bucket.ErrRehydrationPending{Name: filename}
+}
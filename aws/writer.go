@@ -0,0 +1,130 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// partSize is the size of the parts of a multipart upload, S3 rejects smaller parts except the last one.
+const partSize = 5 << 20
+
+// NewWriter buffers the writes in parts of partSize and sends them with a multipart upload, which is started
+// by the first full part. Smaller objects are uploaded by a single PutObject on Close. CloseWithError aborts
+// the multipart upload, S3 keeps parts of uploads that are neither completed nor aborted until a lifecycle
+// rule removes them.
+func (c *AWSBucket) NewWriter(ctx context.Context, filename string, opts ...bucket.Option) bucket.Writer {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.FailedWriter(bucket.ErrUnsupportedTTL{TTL: o.TTL})
+	}
+	if err := o.Encryption.Validate(); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	if _, err := s3Class(o.StorageClass); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	return bucket.NewUploadWriter(&multipartUpload{c: c, ctx: ctx, filename: filename, o: o}, o)
+}
+
+// multipartUpload is the upload of NewWriter, uploadID is set once the first part is sent.
+type multipartUpload struct {
+	c        *AWSBucket
+	ctx      context.Context
+	filename string
+	o        bucket.Options
+
+	buf      []byte
+	uploadID *string
+	parts    []types.CompletedPart
+}
+
+// Write keeps at least one byte in the buffer, so the last part is never empty.
+func (u *multipartUpload) Write(p []byte) (int, error) {
+	u.buf = append(u.buf, p...)
+	for len(u.buf) > partSize {
+		if err := u.uploadPart(u.buf[:partSize]); err != nil {
+			return len(p), err
+		}
+		u.buf = append(u.buf[:0], u.buf[partSize:]...)
+	}
+	return len(p), nil
+}
+
+func (u *multipartUpload) uploadPart(part []byte) error {
+	if u.uploadID == nil {
+		input := &s3.CreateMultipartUploadInput{
+			Bucket: &u.c.bucket,
+			Key:    &u.filename,
+		}
+		if err := createEncryption(input, u.o.Encryption); err != nil {
+			return err
+		}
+		input.StorageClass, _ = s3Class(u.o.StorageClass)
+		res, err := u.c.client.CreateMultipartUpload(u.ctx, input)
+		if err != nil {
+			return fmt.Errorf("CreateMultipartUpload: %w", err)
+		}
+		u.uploadID = res.UploadId
+	}
+	number := int32(len(u.parts) + 1)
+	input := &s3.UploadPartInput{
+		Bucket:        &u.c.bucket,
+		Key:           &u.filename,
+		UploadId:      u.uploadID,
+		PartNumber:    number,
+		Body:          bytes.NewReader(part),
+		ContentLength: int64(len(part)),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = customerKey(u.o.Encryption)
+	res, err := u.c.client.UploadPart(u.ctx, input)
+	if err != nil {
+		return fmt.Errorf("UploadPart(%d): %w", number, err)
+	}
+	u.parts = append(u.parts, types.CompletedPart{ETag: res.ETag, PartNumber: number})
+	return nil
+}
+
+// Commit sends the rest of the buffer as the last part and completes the upload, the preconditions are
+// checked by the completion.
+func (u *multipartUpload) Commit() error {
+	if u.uploadID == nil {
+		return u.c.putObject(u.ctx, bytes.NewReader(u.buf), u.filename, u.o)
+	}
+	if err := u.uploadPart(u.buf); err != nil {
+		_ = u.Abort()
+		return err
+	}
+	_, err := u.c.client.CompleteMultipartUpload(u.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &u.c.bucket,
+		Key:             &u.filename,
+		UploadId:        u.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: u.parts},
+	}, putConditions(u.o)...)
+	if err != nil {
+		_ = u.Abort()
+		return fmt.Errorf("CompleteMultipartUpload: %w", preconditionErr(err))
+	}
+	return nil
+}
+
+func (u *multipartUpload) Abort() error {
+	u.buf = nil
+	if u.uploadID == nil {
+		return nil
+	}
+	_, err := u.c.client.AbortMultipartUpload(u.ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   &u.c.bucket,
+		Key:      &u.filename,
+		UploadId: u.uploadID,
+	})
+	if err != nil {
+		return fmt.Errorf("AbortMultipartUpload: %w", err)
+	}
+	return nil
+}
@@ -3,148 +3,482 @@ package azure
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/Azure/azure-pipeline-go/pipeline"
 	"github.com/Azure/azure-storage-blob-go/azblob"
+	"golang.org/x/oauth2"
 )
 
+// adapter runs the calls of one bucket method with the pipeline of the bucket and the context of the call.
 type adapter struct {
-	ctx context.Context
+	ctx     context.Context
+	account account
 }
 
 const (
-	bufferSize        = 1024 * 1024 // size of the rotating buffers used when uploading
-	maxBuffers        = 4           // number of rotating buffers used when uploading
-	objectListMaxSize = 10          // set max number of objects listed in ListObjectMethod
+	bufferSize        = 1024 * 1024     // size of the rotating buffers used when uploading
+	maxBuffers        = 4               // number of rotating buffers used when uploading
+	objectListMaxSize = 5000            // max number of objects returned by one listing request
+	blockSize         = 4 * 1024 * 1024 // size of the blocks staged by the writers of NewWriter
+	downloadRetries   = 3               // number of requests resuming a download that broke off mid-stream
+
+	defaultEncryptionScope = "$account-encryption-key" // scope of the blobs encrypted with the account key
 )
 
 type adapterInterface interface {
 	Delete(bucketName string, objName string) error
-	Upload(fileAsBytes []byte, bucketName string, objName string) error
-	UploadChunks(fileAsRead io.Reader, bucketName string, objName string) error
-	DownloadBytes(bucketName string, objName string) (io.ReadCloser, error)
+	Upload(fileAsBytes []byte, bucketName string, objName string, opts bucket.Options) error
+	UploadChunks(fileAsRead io.Reader, bucketName string, objName string, opts bucket.Options) error
+	StageBlock(bucketName string, objName string, blockID string, block []byte, opts bucket.Options) error
+	CommitBlockList(bucketName string, objName string, blockIDs []string, contentType string, opts bucket.Options) error
+	DownloadBytes(bucketName string, objName string, opts bucket.Options) (io.ReadCloser, error)
 	GenerateSignedURL(bucketName string, objName string, ttl time.Time) (string, error)
+	List(bucketName string, prefix string) ([]azblob.BlobItemInternal, error)
+	Stat(bucketName string, objName string, opts bucket.Options) (bucket.ObjectAttrs, error)
+	ListVersions(bucketName string, objName string) ([]azblob.BlobItemInternal, error)
+	DownloadVersion(bucketName string, objName string, versionID string, opts bucket.Options) (io.ReadCloser, error)
+	DeleteVersion(bucketName string, objName string, versionID string) error
+	SetTier(bucketName string, objName string, tier azblob.AccessTierType) error
+	GetTags(bucketName string, objName string) (map[string]string, error)
+	SetTags(bucketName string, objName string, tags map[string]string) error
+	FindByTags(bucketName string, filter map[string]string) ([]string, error)
+	Lifecycle(bucketName string) ([]bucket.LifecycleRule, error)
+	SetLifecycle(bucketName string, rules []bucket.LifecycleRule) error
 }
 
-func newAdapter(ctx context.Context) (adapterInterface, error) {
-	return &adapter{ctx}, nil
+func (a *adapter) containerURL(bucketName string) azblob.ContainerURL {
+	return a.account.serviceURL.NewContainerURL(bucketName)
 }
 
-func createContainerURL(bucketName string) azblob.ContainerURL {
+func (a *adapter) blobURL(bucketName, objName string) azblob.BlockBlobURL {
+	return a.containerURL(bucketName).NewBlockBlobURL(objName)
+}
 
-	accountName, accountKey := accountInfo()
-	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
-	if err != nil {
-		log.Fatalf("reading credential error: %v", err)
-		return azblob.ContainerURL{}
-	}
+func (a *adapter) List(bucketName string, prefix string) ([]azblob.BlobItemInternal, error) {
+	return a.list(bucketName, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+}
 
-	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+// ListVersions needs blob versioning enabled on the storage account, otherwise only the current blob is listed.
+func (a *adapter) ListVersions(bucketName string, objName string) ([]azblob.BlobItemInternal, error) {
 
-	u, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", accountName))
+	items, err := a.list(bucketName, azblob.ListBlobsSegmentOptions{
+		Prefix:  objName,
+		Details: azblob.BlobListingDetails{Versions: true},
+	})
 	if err != nil {
-		log.Fatalf("failed to create bucket with curren credentials: %v ", err)
-		return azblob.ContainerURL{}
+		return nil, err
 	}
-	serviceURL := azblob.NewServiceURL(*u, p)
-	containerURL := serviceURL.NewContainerURL(bucketName)
 
-	_, err = containerURL.Create(context.Background(), azblob.Metadata{}, "container")
-	if err != nil {
-		log.Fatalf("container creation failed: %v", err)
-		return azblob.ContainerURL{}
+	var versions []azblob.BlobItemInternal
+	for _, item := range items {
+		if item.Name == objName {
+			versions = append(versions, item)
+		}
 	}
-	return containerURL
+	return versions, nil
 }
 
-func createBlobURL(bucketName, objName string) azblob.BlockBlobURL {
+func (a *adapter) list(bucketName string, options azblob.ListBlobsSegmentOptions) ([]azblob.BlobItemInternal, error) {
 
-	return createContainerURL(bucketName).NewBlockBlobURL(objName)
+	containerURL := a.containerURL(bucketName)
+	options.MaxResults = objectListMaxSize
 
+	var list []azblob.BlobItemInternal
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := containerURL.ListBlobsFlatSegment(a.ctx, marker, options)
+		if err != nil {
+			return nil, fmt.Errorf("listing objects error: %w", err)
+		}
+		list = append(list, resp.Segment.BlobItems...)
+		marker = resp.NextMarker
+	}
+
+	return list, nil
 }
 
-func (a *adapter) ListObjects(bucketName string) ([]string, error) {
+// Stat sends the customer key of opts, the properties of a blob encrypted with one are not returned without it.
+func (a *adapter) Stat(bucketName string, objName string, opts bucket.Options) (bucket.ObjectAttrs, error) {
 
-	tmp, err := createContainerURL(bucketName).ListBlobsFlatSegment(context.Background(), azblob.Marker{}, azblob.ListBlobsSegmentOptions{
-		Details:    azblob.BlobListingDetails{},
-		Prefix:     "",
-		MaxResults: objectListMaxSize,
-	})
+	blobURL := a.blobURL(bucketName, objName)
 
-	list := make([]string, len(tmp.Segment.BlobItems))
+	cpk, err := clientProvidedKey(opts.Encryption)
+	if err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
 
-	for i := range tmp.Segment.BlobItems {
-		list[i] = tmp.Segment.BlobItems[i].Name
+	props, err := blobURL.GetProperties(a.ctx, azblob.BlobAccessConditions{}, cpk)
+	if err != nil {
+		return bucket.ObjectAttrs{}, fmt.Errorf("getting properties error: %w", err)
 	}
 
-	return list, fmt.Errorf("failed to create list of objects: %v", err)
+	return bucket.ObjectAttrs{
+		Name:         objName,
+		Size:         props.ContentLength(),
+		MD5:          props.ContentMD5(),
+		ETag:         string(props.ETag()),
+		ContentType:  props.ContentType(),
+		Updated:      props.LastModified(),
+		Encryption:   blobEncryption(props.EncryptionKeySha256(), props.EncryptionScope(), props.IsServerEncrypted()),
+		StorageClass: storageClassOf(props.AccessTier()),
+		Rehydration:  rehydration(props.ArchiveStatus()),
+	}, nil
 }
 
-func (a *adapter) Upload(fileAsBytes []byte, bucketName string, objName string) error {
+func (a *adapter) Upload(fileAsBytes []byte, bucketName string, objName string, opts bucket.Options) error {
 
-	blobURL := createBlobURL(bucketName, objName)
+	blobURL := a.blobURL(bucketName, objName)
+
+	cpk, err := clientProvidedKey(opts.Encryption)
+	if err != nil {
+		return err
+	}
+	tier, err := accessTier(opts.StorageClass)
+	if err != nil {
+		return err
+	}
 
-	_, err := blobURL.Upload(a.ctx, bytes.NewReader(fileAsBytes), azblob.BlobHTTPHeaders{ContentType: http.DetectContentType(fileAsBytes)}, azblob.Metadata{}, azblob.BlobAccessConditions{}, azblob.DefaultAccessTier, nil, azblob.ClientProvidedKeyOptions{})
+	body := opts.ProgressReader(bytes.NewReader(fileAsBytes), -1).(io.ReadSeeker)
+	_, err = blobURL.Upload(a.ctx, body, azblob.BlobHTTPHeaders{ContentType: http.DetectContentType(fileAsBytes)}, azblob.Metadata{}, accessConditions(opts, false), tier, nil, cpk)
 	if err != nil {
-		fmt.Errorf("uploading file error: %w", err)
+		return fmt.Errorf("uploading file error: %w", preconditionErr(err))
 	}
 
-	return err
+	return nil
 }
 
-func (a *adapter) UploadChunks(fileAsRead io.Reader, bucketName string, objName string) error {
+func (a *adapter) UploadChunks(fileAsRead io.Reader, bucketName string, objName string, opts bucket.Options) error {
+
+	blobURL := a.blobURL(bucketName, objName)
 
-	blobURL := createBlobURL(bucketName, objName)
+	cpk, err := clientProvidedKey(opts.Encryption)
+	if err != nil {
+		return err
+	}
+	tier, err := accessTier(opts.StorageClass)
+	if err != nil {
+		return err
+	}
 
 	// Perform UploadStreamToBlockBlob
 	bufferSize := bufferSize
 	maxBuffers := maxBuffers
-	_, err := azblob.UploadStreamToBlockBlob(a.ctx, fileAsRead, blobURL,
-		azblob.UploadStreamToBlockBlobOptions{BufferSize: bufferSize, MaxBuffers: maxBuffers})
+	_, err = azblob.UploadStreamToBlockBlob(a.ctx, opts.ProgressReader(fileAsRead, -1), blobURL,
+		azblob.UploadStreamToBlockBlobOptions{BufferSize: bufferSize, MaxBuffers: maxBuffers, AccessConditions: accessConditions(opts, false),
+			BlobAccessTier: tier, ClientProvidedKeyOptions: cpk})
+	if err != nil {
+		return fmt.Errorf("uploading by chunks error: %w", preconditionErr(err))
+	}
+
+	return nil
+}
+
+// StageBlock uploads an uncommitted block of the blob, blocks that are never committed are removed by the service.
+func (a *adapter) StageBlock(bucketName string, objName string, blockID string, block []byte, opts bucket.Options) error {
+
+	cpk, err := clientProvidedKey(opts.Encryption)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.blobURL(bucketName, objName).StageBlock(a.ctx, blockID, bytes.NewReader(block), azblob.LeaseAccessConditions{}, nil, cpk)
+	if err != nil {
+		return fmt.Errorf("staging block error: %w", err)
+	}
 
-	return fmt.Errorf("uploading by chunks error: %w", err)
+	return nil
+}
+
+// CommitBlockList replaces the blob with the staged blocks, the access conditions of opts are checked by the commit.
+func (a *adapter) CommitBlockList(bucketName string, objName string, blockIDs []string, contentType string, opts bucket.Options) error {
+
+	cpk, err := clientProvidedKey(opts.Encryption)
+	if err != nil {
+		return err
+	}
+	tier, err := accessTier(opts.StorageClass)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.blobURL(bucketName, objName).CommitBlockList(a.ctx, blockIDs, azblob.BlobHTTPHeaders{ContentType: contentType}, azblob.Metadata{},
+		accessConditions(opts, false), tier, nil, cpk)
+	if err != nil {
+		return fmt.Errorf("committing blocks error: %w", preconditionErr(err))
+	}
+
+	return nil
 }
 
 func (a *adapter) Delete(bucketName string, objName string) error {
 
-	blobURL := createBlobURL(bucketName, objName)
+	blobURL := a.blobURL(bucketName, objName)
 	_, err := blobURL.Delete(a.ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
-	return fmt.Errorf("deleting the file error: %w", err)
+	if err != nil {
+		return fmt.Errorf("deleting the file error: %w", err)
+	}
+	return nil
 }
 
-func (a *adapter) DownloadBytes(bucketName string, objName string) (io.ReadCloser, error) {
+func (a *adapter) DownloadBytes(bucketName string, objName string, opts bucket.Options) (io.ReadCloser, error) {
 
-	blobURL := createBlobURL(bucketName, objName)
+	blobURL := a.blobURL(bucketName, objName)
+
+	cpk, err := clientProvidedKey(opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
 
-	get, err := blobURL.Download(a.ctx, 0, 0, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	get, err := blobURL.Download(a.ctx, 0, 0, accessConditions(opts, true), false, cpk)
 	if err != nil {
-		return nil, fmt.Errorf("downloading file error: %w", err)
+		return nil, fmt.Errorf("downloading file error: %w", preconditionErr(err))
 	}
 
-	// Wrap the response body in a ResponseBodyProgress and pass a callback function for progress reporting.
-	responseBody := pipeline.NewResponseBodyProgress(get.Body(azblob.RetryReaderOptions{}),
-		func(bytesTransferred int64) {
-			fmt.Printf("Read %d of %d bytes.", bytesTransferred, get.ContentLength())
-		})
-	return responseBody, nil
+	return bucket.Stream(opts.ProgressReadCloser(get.Body(retryOptions(cpk)), get.ContentLength()), get.ContentLength(), nil), nil
 }
 
+func (a *adapter) DownloadVersion(bucketName string, objName string, versionID string, opts bucket.Options) (io.ReadCloser, error) {
+
+	blobURL := a.blobURL(bucketName, objName).WithVersionID(versionID)
+
+	cpk, err := clientProvidedKey(opts.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	get, err := blobURL.Download(a.ctx, 0, 0, azblob.BlobAccessConditions{}, false, cpk)
+	if err != nil {
+		return nil, fmt.Errorf("downloading version error: %w", err)
+	}
+
+	return bucket.Stream(opts.ProgressReadCloser(get.Body(retryOptions(cpk)), get.ContentLength()), get.ContentLength(), nil), nil
+}
+
+// retryOptions resume a broken download with the key of the first request, a blob encrypted with a customer key
+// can not be read without it.
+func retryOptions(cpk azblob.ClientProvidedKeyOptions) azblob.RetryReaderOptions {
+	return azblob.RetryReaderOptions{MaxRetryRequests: downloadRetries, ClientProvidedKeyOptions: cpk}
+}
+
+func (a *adapter) DeleteVersion(bucketName string, objName string, versionID string) error {
+
+	blobURL := a.blobURL(bucketName, objName).WithVersionID(versionID)
+	_, err := blobURL.Delete(a.ctx, azblob.DeleteSnapshotsOptionNone, azblob.BlobAccessConditions{})
+	if err != nil {
+		return fmt.Errorf("deleting version error: %w", err)
+	}
+	return nil
+}
+
+// SetTier moves the blob to the tier. Moving an archived blob starts its rehydration, a blob being rehydrated
+// fails with bucket.ErrRehydrationPending.
+func (a *adapter) SetTier(bucketName string, objName string, tier azblob.AccessTierType) error {
+
+	blobURL := a.blobURL(bucketName, objName)
+
+	_, err := blobURL.SetTier(a.ctx, tier, azblob.LeaseAccessConditions{})
+	if serviceCode(err) == azblob.ServiceCodeBlobBeingRehydrated {
+		return bucket.ErrRehydrationPending{Name: objName}
+	}
+	if err != nil {
+		return fmt.Errorf("setting tier error: %w", err)
+	}
+	return nil
+}
+
+func (a *adapter) GetTags(bucketName string, objName string) (map[string]string, error) {
+
+	blobURL := a.blobURL(bucketName, objName)
+
+	resp, err := blobURL.GetTags(a.ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting tags error: %w", err)
+	}
+
+	tags := make(map[string]string, len(resp.BlobTagSet))
+	for _, t := range resp.BlobTagSet {
+		tags[t.Key] = t.Value
+	}
+	return tags, nil
+}
+
+func (a *adapter) SetTags(bucketName string, objName string, tags map[string]string) error {
+
+	blobURL := a.blobURL(bucketName, objName)
+
+	_, err := blobURL.SetTags(a.ctx, nil, nil, nil, tags)
+	if err != nil {
+		return fmt.Errorf("setting tags error: %w", err)
+	}
+	return nil
+}
+
+// FindByTags runs a blob index query scoped to the container, the index is updated asynchronously
+// so recently tagged blobs may be missing.
+func (a *adapter) FindByTags(bucketName string, filter map[string]string) ([]string, error) {
+
+	where := tagQuery(bucketName, filter)
+	maxResults := int32(objectListMaxSize)
+
+	var names []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := a.account.serviceURL.FindBlobsByTags(a.ctx, nil, nil, &where, marker, &maxResults)
+		if err != nil {
+			return nil, fmt.Errorf("finding blobs by tags error: %w", err)
+		}
+		for _, blob := range resp.Blobs {
+			names = append(names, blob.Name)
+		}
+		marker = azblob.Marker{Val: resp.NextMarker}
+	}
+
+	return names, nil
+}
+
+// tagQuery builds the blob index expression matching every tag of filter in the container.
+// Tag values can not contain quotes, so they need no escaping.
+func tagQuery(bucketName string, filter map[string]string) string {
+	keys := make([]string, 0, len(filter))
+	for k := range filter {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	conds := []string{fmt.Sprintf("@container='%s'", bucketName)}
+	for _, k := range keys {
+		conds = append(conds, fmt.Sprintf(`"%s"='%s'`, k, filter[k]))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// accessConditions maps the preconditions of opts, IfModifiedSince is only sent with downloads.
+func accessConditions(opts bucket.Options, download bool) azblob.BlobAccessConditions {
+	conds := azblob.ModifiedAccessConditions{
+		IfMatch:     azblob.ETag(opts.IfMatch),
+		IfNoneMatch: azblob.ETag(opts.IfNoneMatch),
+	}
+	if download {
+		conds.IfModifiedSince = opts.IfModifiedSince
+	}
+	return azblob.BlobAccessConditions{ModifiedAccessConditions: conds}
+}
+
+// clientProvidedKey maps the encryption to a customer-provided key or an encryption scope, provider keys
+// need no options. Downloads send the key only, blobs of an encryption scope are decrypted without it.
+func clientProvidedKey(enc bucket.Encryption) (azblob.ClientProvidedKeyOptions, error) {
+	if err := enc.Validate(); err != nil {
+		return azblob.ClientProvidedKeyOptions{}, err
+	}
+	switch enc.Type {
+	case bucket.EncryptionKMS:
+		if enc.KMSKeyID == "" {
+			return azblob.ClientProvidedKeyOptions{}, bucket.ErrUnsupportedEncryption{Type: enc.Type, Reason: "Azure needs an encryption scope"}
+		}
+		return azblob.ClientProvidedKeyOptions{EncryptionScope: &enc.KMSKeyID}, nil
+	case bucket.EncryptionCustomerKey:
+		sum := sha256.Sum256(enc.CustomerKey)
+		key := base64.StdEncoding.EncodeToString(enc.CustomerKey)
+		keySHA256 := base64.StdEncoding.EncodeToString(sum[:])
+		return azblob.NewClientProvidedKeyOptions(&key, &keySHA256, nil), nil
+	}
+	return azblob.ClientProvidedKeyOptions{}, nil
+}
+
+// accessTiers maps the storage classes to the Azure access tiers, Azure has no tier between Cool and Archive.
+var accessTiers = map[bucket.StorageClass]azblob.AccessTierType{
+	bucket.StorageClassHot:     azblob.AccessTierHot,
+	bucket.StorageClassCool:    azblob.AccessTierCool,
+	bucket.StorageClassArchive: azblob.AccessTierArchive,
+}
+
+// accessTier returns the tier of class, none for the default tier of the account.
+func accessTier(class bucket.StorageClass) (azblob.AccessTierType, error) {
+	if class == "" {
+		return azblob.AccessTierNone, nil
+	}
+	tier, ok := accessTiers[class]
+	if !ok {
+		return "", bucket.ErrUnsupportedStorageClass{Class: class}
+	}
+	return tier, nil
+}
+
+// storageClassOf returns the storage class of an access tier, empty for the premium tiers.
+func storageClassOf(tier string) bucket.StorageClass {
+	for class, t := range accessTiers {
+		if string(t) == tier {
+			return class
+		}
+	}
+	return ""
+}
+
+// rehydration reports the archive status of a blob moved out of the Archive tier, the blob changes its tier
+// once it is readable.
+func rehydration(archiveStatus string) *bucket.Rehydration {
+	switch azblob.ArchiveStatusType(archiveStatus) {
+	case azblob.ArchiveStatusRehydratePendingToHot:
+		return &bucket.Rehydration{Pending: true, Class: bucket.StorageClassHot}
+	case azblob.ArchiveStatusRehydratePendingToCool:
+		return &bucket.Rehydration{Pending: true, Class: bucket.StorageClassCool}
+	}
+	return nil
+}
+
+// blobEncryption reports the encryption of the blob properties, the key hash is set for customer-provided keys
+// and the scope for every blob of an account with encryption scopes, "$account-encryption-key" is the default one.
+func blobEncryption(keySHA256, scope, serverEncrypted string) bucket.Encryption {
+	switch {
+	case keySHA256 != "":
+		return bucket.Encryption{Type: bucket.EncryptionCustomerKey}
+	case scope != "" && scope != defaultEncryptionScope:
+		return bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: scope}
+	case serverEncrypted == "true":
+		return bucket.Encryption{Type: bucket.EncryptionProviderKey}
+	}
+	return bucket.Encryption{}
+}
+
+// preconditionErr turns the responses to failed conditional requests into bucket.ErrPreconditionFailed.
+func preconditionErr(err error) error {
+	var stgErr azblob.StorageError
+	if errors.As(err, &stgErr) && stgErr.Response() != nil {
+		switch stgErr.Response().StatusCode {
+		case http.StatusPreconditionFailed, http.StatusNotModified:
+			return bucket.ErrPreconditionFailed{}
+		}
+	}
+	return err
+}
+
+// GenerateSignedURL signs with the account key or a user delegation key, buckets authorized by a SAS token
+// fail with ErrSigningUnavailable.
 func (a *adapter) GenerateSignedURL(bucketName string, objName string, ttl time.Time) (string, error) {
 
-	accountName, accountKey := accountInfo()
-	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	credential, err := a.account.signingCredential(a.ctx, ttl)
 	if err != nil {
-		return "", fmt.Errorf("reading credential error: %w", err)
+		return "", err
+	}
+
+	u := a.blobURL(bucketName, objName).URL()
+	protocol := azblob.SASProtocolHTTPS
+	if u.Scheme == "http" {
+		protocol = azblob.SASProtocolHTTPSandHTTP
 	}
 
 	sasQueryParams, err := azblob.BlobSASSignatureValues{
-		Protocol:      azblob.SASProtocolHTTPS,
+		Protocol:      protocol,
 		ExpiryTime:    ttl,
 		ContainerName: bucketName,
 		BlobName:      objName,
@@ -155,10 +489,104 @@ func (a *adapter) GenerateSignedURL(bucketName string, objName string, ttl time.
 		return "", fmt.Errorf("creating query parametrs error: %w", err)
 	}
 
-	qp := sasQueryParams.Encode()
+	u.RawQuery = sasQueryParams.Encode()
 
-	signedURL := fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s?%s",
-		accountName, bucketName, objName, qp)
+	return u.String(), nil
+}
+
+const managementAPIVersion = "2021-04-01"
+
+// managementEndpoint is Azure Resource Manager, tests point it to a local server.
+var managementEndpoint = "https://management.azure.com"
+
+// managementClient returns an HTTP client authorized for Azure Resource Manager and the URL of the management
+// policy of the storage account. Management policies are not part of the blob API, they are reached with the
+// token source of the bucket, buckets authorized by an account key or a SAS token get ErrManagementUnavailable.
+func (a *adapter) managementClient() (*http.Client, string, error) {
+	if a.account.tokenSource == nil {
+		return nil, "", ErrManagementUnavailable{}
+	}
+	var (
+		subscription  = os.Getenv("AZURE_SUBSCRIPTION_ID")
+		resourceGroup = os.Getenv("AZURE_RESOURCE_GROUP")
+	)
+	if subscription == "" || resourceGroup == "" {
+		return nil, "", errors.New("AZURE_SUBSCRIPTION_ID and AZURE_RESOURCE_GROUP must be set to manage lifecycle rules")
+	}
+	if a.account.name == "" {
+		return nil, "", errors.New("the storage account name is required to manage lifecycle rules")
+	}
+
+	policyURL := fmt.Sprintf(
+		"%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Storage/storageAccounts/%s/managementPolicies/default?api-version=%s",
+		managementEndpoint, url.PathEscape(subscription), url.PathEscape(resourceGroup), url.PathEscape(a.account.name), managementAPIVersion)
+
+	return oauth2.NewClient(a.ctx, a.account.tokenSource), policyURL, nil
+}
 
-	return signedURL, nil
+// managementRequest sends the policy request and decodes the response into policy unless it is nil.
+// A missing policy is reported as found with no rules.
+func (a *adapter) managementRequest(method string, body *managementPolicy, policy *managementPolicy) error {
+	client, policyURL, err := a.managementClient()
+	if err != nil {
+		return err
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(a.ctx, method, policyURL, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("management policy request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil
+	case resp.StatusCode >= http.StatusBadRequest:
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("management policy request error: %s: %s", resp.Status, msg)
+	case policy != nil:
+		if err := json.NewDecoder(resp.Body).Decode(policy); err != nil {
+			return fmt.Errorf("decoding management policy error: %w", err)
+		}
+	}
+	return nil
+}
+
+func (a *adapter) Lifecycle(bucketName string) ([]bucket.LifecycleRule, error) {
+	var policy managementPolicy
+	if err := a.managementRequest(http.MethodGet, nil, &policy); err != nil {
+		return nil, err
+	}
+	return containerRules(policy, bucketName)
+}
+
+// SetLifecycle updates the management policy of the account, the policy is deleted when no rules remain.
+// Concurrent updates of the policy for different containers may overwrite each other.
+func (a *adapter) SetLifecycle(bucketName string, rules []bucket.LifecycleRule) error {
+	var policy managementPolicy
+	if err := a.managementRequest(http.MethodGet, nil, &policy); err != nil {
+		return err
+	}
+	policy, err := withContainerRules(policy, bucketName, rules)
+	if err != nil {
+		return err
+	}
+	if len(policy.Properties.Policy.Rules) == 0 {
+		return a.managementRequest(http.MethodDelete, nil, nil)
+	}
+	return a.managementRequest(http.MethodPut, &policy, nil)
 }
@@ -0,0 +1,109 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// Admin manages the containers of the storage account.
+type Admin struct {
+	account account
+}
+
+var _ bucket.Admin = (*Admin)(nil)
+
+// NewAdmin authorizes like OpenBucket, a SAS token scoped to one container can not manage containers.
+func NewAdmin(_ context.Context, opts ...Option) (*Admin, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	acc, err := newAccount(o)
+	if err != nil {
+		return nil, err
+	}
+	return &Admin{account: acc}, nil
+}
+
+// BucketExists reads the properties of the container only.
+func (a *Admin) BucketExists(ctx context.Context, name string) (bool, error) {
+	_, err := a.account.serviceURL.NewContainerURL(name).GetProperties(ctx, azblob.LeaseAccessConditions{})
+	if serviceCode(err) == azblob.ServiceCodeContainerNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("container properties error: %w", err)
+	}
+	return true, nil
+}
+
+// CreateBucket creates a private container, bucket.WithPublicRead allows anonymous reads of its blobs but not
+// listing them. Containers are in the location
+// of the storage account and have its default access tier, so neither can be set. The container API of azblob
+// has no default encryption scope, only the provider key is accepted as the default encryption.
+func (a *Admin) CreateBucket(ctx context.Context, name string, opts ...bucket.CreateOption) error {
+	o := bucket.NewCreateOptions(opts...)
+	if o.Location != "" {
+		return bucket.ErrUnsupportedLocation{Location: o.Location}
+	}
+	if o.StorageClass != "" {
+		return bucket.ErrUnsupportedStorageClass{Class: o.StorageClass}
+	}
+	if t := o.Encryption.Type; t != "" && t != bucket.EncryptionProviderKey {
+		return bucket.ErrUnsupportedEncryption{Type: t, Reason: "containers have no default encryption scope"}
+	}
+	access := azblob.PublicAccessNone
+	if o.PublicRead {
+		access = azblob.PublicAccessBlob
+	}
+	_, err := a.account.serviceURL.NewContainerURL(name).Create(ctx, azblob.Metadata{}, access)
+	if serviceCode(err) == azblob.ServiceCodeContainerAlreadyExists {
+		return bucket.ErrBucketExists{Bucket: name}
+	}
+	if err != nil {
+		return fmt.Errorf("container creation error: %w", err)
+	}
+	return nil
+}
+
+// DeleteBucket deletes the container with its blobs, Azure does not require it to be empty.
+func (a *Admin) DeleteBucket(ctx context.Context, name string) error {
+	_, err := a.account.serviceURL.NewContainerURL(name).Delete(ctx, azblob.ContainerAccessConditions{})
+	if serviceCode(err) == azblob.ServiceCodeContainerNotFound {
+		return bucket.ErrBucketNotFound{Bucket: name}
+	}
+	if err != nil {
+		return fmt.Errorf("container deletion error: %w", err)
+	}
+	return nil
+}
+
+func (a *Admin) ListBuckets(ctx context.Context) ([]string, error) {
+	var names []string
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := a.account.serviceURL.ListContainersSegment(ctx, marker, azblob.ListContainersSegmentOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("listing containers error: %w", err)
+		}
+		for _, item := range resp.ContainerItems {
+			names = append(names, item.Name)
+		}
+		marker = resp.NextMarker
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func serviceCode(err error) azblob.ServiceCodeType {
+	var stgErr azblob.StorageError
+	if errors.As(err, &stgErr) {
+		return stgErr.ServiceCode()
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"golang.org/x/oauth2"
+)
+
+// minTokenRefresh keeps a token source that returns the same token until it expires from being polled.
+const minTokenRefresh = time.Second
+
+// tokenRetryInterval is the wait before retrying a failed token refresh.
+const tokenRetryInterval = 10 * time.Second
+
+type ErrSigningUnavailable struct{}
+
+func (e ErrSigningUnavailable) Error() string {
+	return "signed URLs need an account key or a token credential, the bucket is authorized by a SAS token"
+}
+
+type ErrManagementUnavailable struct{}
+
+func (e ErrManagementUnavailable) Error() string {
+	return "lifecycle rules need a token credential for the management API, the bucket is authorized by an account key or a SAS token"
+}
+
+// Option configures how OpenBucket authorizes the bucket, by default with the account key of ACCOUNT_NAME
+// and ACCOUNT_KEY.
+type Option func(*options)
+
+type options struct {
+	connectionString string
+	accountName      string
+	sasToken         string
+	tokenSource      oauth2.TokenSource
+	create           bool
+	createOpts       []bucket.CreateOption
+}
+
+// WithCreate makes OpenBucket create the container with opts when it does not exist. Buckets authorized by
+// a SAS token are not checked.
+func WithCreate(opts ...bucket.CreateOption) Option {
+	return func(o *options) {
+		o.create = true
+		o.createOpts = opts
+	}
+}
+
+// WithConnectionString authorizes the bucket with a storage account connection string holding either
+// an AccountKey or a SharedAccessSignature. BlobEndpoint overrides the endpoint of the account.
+func WithConnectionString(connectionString string) Option {
+	return func(o *options) {
+		o.connectionString = connectionString
+	}
+}
+
+// WithSASToken authorizes the bucket with a SAS token scoped to the container, the container has to exist.
+// Signed URLs are not available.
+func WithSASToken(accountName, sasToken string) Option {
+	return func(o *options) {
+		o.accountName = accountName
+		o.sasToken = sasToken
+	}
+}
+
+// WithTokenSource authorizes the bucket with OAuth tokens for Azure Storage, e.g. of a workload identity.
+// The token is refreshed before it expires and signed URLs use a user delegation key. Lifecycle rules are
+// managed through Azure Resource Manager with the same token source.
+func WithTokenSource(accountName string, ts oauth2.TokenSource) Option {
+	return func(o *options) {
+		o.accountName = accountName
+		o.tokenSource = ts
+	}
+}
+
+// account is how the bucket reaches the storage account, it is built once by OpenBucket.
+type account struct {
+	serviceURL azblob.ServiceURL
+	// name is the storage account, the management API addresses its lifecycle policy by it.
+	name string
+	// sharedKey signs URLs of buckets authorized by the account key.
+	sharedKey *azblob.SharedKeyCredential
+	// delegation makes signed URLs use a user delegation key, the bucket is authorized by a token.
+	delegation bool
+	// containerSAS is set for buckets authorized by a SAS token, they can not create the container.
+	containerSAS bool
+	// tokenSource authorizes the management API of buckets authorized by a token.
+	tokenSource oauth2.TokenSource
+}
+
+func newAccount(opts options) (account, error) {
+	switch {
+	case opts.connectionString != "":
+		return connectionStringAccount(opts.connectionString)
+	case opts.sasToken != "":
+		return sasAccount(accountEndpoint(opts.accountName), opts.accountName, opts.sasToken)
+	case opts.tokenSource != nil:
+		return tokenAccount(accountEndpoint(opts.accountName), opts.accountName, opts.tokenSource)
+	default:
+		accountName, accountKey := accountInfo()
+		return sharedKeyAccount(accountEndpoint(accountName), accountName, accountKey)
+	}
+}
+
+func accountEndpoint(accountName string) string {
+	return fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
+}
+
+func sharedKeyAccount(endpoint, accountName, accountKey string) (account, error) {
+	credential, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return account{}, fmt.Errorf("reading credential error: %w", err)
+	}
+	serviceURL, err := newServiceURL(endpoint, "", credential)
+	if err != nil {
+		return account{}, err
+	}
+	return account{serviceURL: serviceURL, name: accountName, sharedKey: credential}, nil
+}
+
+func sasAccount(endpoint, accountName, sasToken string) (account, error) {
+	serviceURL, err := newServiceURL(endpoint, strings.TrimPrefix(sasToken, "?"), azblob.NewAnonymousCredential())
+	if err != nil {
+		return account{}, err
+	}
+	return account{serviceURL: serviceURL, name: accountName, containerSAS: true}, nil
+}
+
+// tokenAccount fetches the first token so a broken token source fails OpenBucket, the credential then
+// refreshes it in the background.
+func tokenAccount(endpoint, accountName string, ts oauth2.TokenSource) (account, error) {
+	token, err := ts.Token()
+	if err != nil {
+		return account{}, fmt.Errorf("getting token error: %w", err)
+	}
+	credential := azblob.NewTokenCredential(token.AccessToken, tokenRefresher(ts, time.Now))
+	serviceURL, err := newServiceURL(endpoint, "", credential)
+	if err != nil {
+		return account{}, err
+	}
+	return account{serviceURL: serviceURL, name: accountName, delegation: true, tokenSource: ts}, nil
+}
+
+// tokenRefresher sets the token of ts and asks to be called again when three quarters of its remaining
+// lifetime passed. Sources reusing a token until shortly before it expires are polled a few times more,
+// a token without expiry is not refreshed.
+func tokenRefresher(ts oauth2.TokenSource, now func() time.Time) azblob.TokenRefresher {
+	return func(credential azblob.TokenCredential) time.Duration {
+		token, err := ts.Token()
+		if err != nil {
+			log.Println("azure token refresh err", err)
+			return tokenRetryInterval
+		}
+		credential.SetToken(token.AccessToken)
+		if token.Expiry.IsZero() {
+			return 0
+		}
+		d := token.Expiry.Sub(now()) * 3 / 4
+		if d < minTokenRefresh {
+			d = minTokenRefresh
+		}
+		return d
+	}
+}
+
+// connectionStringAccount reads the account of a connection string such as
+// "DefaultEndpointsProtocol=https;AccountName=name;AccountKey=key;EndpointSuffix=core.windows.net".
+func connectionStringAccount(connectionString string) (account, error) {
+	settings := make(map[string]string)
+	for _, part := range strings.Split(connectionString, ";") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return account{}, fmt.Errorf("connection string: invalid setting %q", kv[0])
+		}
+		settings[kv[0]] = kv[1]
+	}
+
+	endpoint := settings["BlobEndpoint"]
+	if endpoint == "" {
+		if settings["AccountName"] == "" {
+			return account{}, errors.New("connection string: AccountName or BlobEndpoint is required")
+		}
+		protocol, suffix := settings["DefaultEndpointsProtocol"], settings["EndpointSuffix"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		endpoint = fmt.Sprintf("%s://%s.blob.%s", protocol, settings["AccountName"], suffix)
+	}
+
+	accountName := settings["AccountName"]
+	if accountName == "" {
+		accountName = endpointAccount(endpoint)
+	}
+
+	switch {
+	case settings["AccountKey"] != "":
+		return sharedKeyAccount(endpoint, accountName, settings["AccountKey"])
+	case settings["SharedAccessSignature"] != "":
+		return sasAccount(endpoint, accountName, settings["SharedAccessSignature"])
+	default:
+		return account{}, errors.New("connection string: AccountKey or SharedAccessSignature is required")
+	}
+}
+
+// endpointAccount returns the account of a blob endpoint such as "https://name.blob.core.windows.net", empty for
+// other endpoints.
+func endpointAccount(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	if i := strings.Index(u.Hostname(), ".blob."); i > 0 {
+		return u.Hostname()[:i]
+	}
+	return ""
+}
+
+func newServiceURL(endpoint, rawQuery string, credential azblob.Credential) (azblob.ServiceURL, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return azblob.ServiceURL{}, fmt.Errorf("parsing account URL error: %w", err)
+	}
+	u.RawQuery = rawQuery
+	return azblob.NewServiceURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{})), nil
+}
+
+// signingCredential returns the credential signing URLs valid until expiry, a user delegation key is requested
+// for buckets authorized by a token.
+func (acc account) signingCredential(ctx context.Context, expiry time.Time) (azblob.StorageAccountCredential, error) {
+	switch {
+	case acc.sharedKey != nil:
+		return acc.sharedKey, nil
+	case acc.delegation:
+		credential, err := acc.serviceURL.GetUserDelegationCredential(ctx, azblob.NewKeyInfo(time.Now().Add(-time.Minute), expiry), nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("getting user delegation key error: %w", err)
+		}
+		return credential, nil
+	default:
+		return nil, ErrSigningUnavailable{}
+	}
+}
@@ -3,10 +3,14 @@ package azure
 import (
 	"context"
 	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"io"
 	"io/ioutil"
 	"os"
+	"sort"
 	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
 )
 
 type bucketAzure struct {
@@ -14,32 +18,70 @@ type bucketAzure struct {
 	newAdapter func(ctx context.Context) (adapterInterface, error)
 }
 
-func OpenBucket(ctx context.Context, bucketName string) (bucketAzure, error) {
-	return bucketAzure{bucketName: bucketName, newAdapter: newAdapter}, nil
+var _ bucket.Bucket = (*bucketAzure)(nil)
+var _ bucket.Lifecycler = (*bucketAzure)(nil)
+var _ bucket.StorageClassSetter = (*bucketAzure)(nil)
+
+// OpenBucket builds the pipeline of the storage account once and checks that the container exists, it is
+// created when WithCreate is given. The bucket is authorized with the account key of ACCOUNT_NAME and ACCOUNT_KEY unless an Option says otherwise,
+// with a SAS token the container is expected to exist.
+func OpenBucket(ctx context.Context, bucketName string, opts ...Option) (bucketAzure, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	acc, err := newAccount(o)
+	if err != nil {
+		return bucketAzure{}, err
+	}
+	return openBucket(ctx, acc, bucketName, o)
+}
+
+// openBucket checks that the container exists, a SAS token scoped to the container can not read its properties.
+func openBucket(ctx context.Context, acc account, bucketName string, o options) (bucketAzure, error) {
+	if !acc.containerSAS {
+		if err := bucket.EnsureBucket(ctx, &Admin{account: acc}, bucketName, o.create, o.createOpts...); err != nil {
+			return bucketAzure{}, err
+		}
+	}
+	return bucketAzure{
+		bucketName: bucketName,
+		newAdapter: func(ctx context.Context) (adapterInterface, error) {
+			return &adapter{ctx: ctx, account: acc}, nil
+		},
+	}, nil
 }
 
 func accountInfo() (string, string) {
 	return os.Getenv("ACCOUNT_NAME"), os.Getenv("ACCOUNT_KEY")
 }
 
-func (c bucketAzure) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string) error {
+func (c bucketAzure) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string, opts ...bucket.Option) error {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.ErrUnsupportedTTL{TTL: o.TTL}
+	}
 
 	a, err := c.newAdapter(ctx)
 	if err != nil {
 		return fmt.Errorf("initialization adapter error: %w", err)
 	}
 
-	err = a.Upload(fileAsBytes, c.bucketName, objName)
+	err = a.Upload(fileAsBytes, c.bucketName, objName, o)
 	return err
 }
 
-func (c bucketAzure) UploadByChunks(ctx context.Context, fileAsRead io.Reader, objName string) error {
+func (c bucketAzure) UploadByChunks(ctx context.Context, fileAsRead io.Reader, objName string, opts ...bucket.Option) error {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.ErrUnsupportedTTL{TTL: o.TTL}
+	}
 
 	a, err := c.newAdapter(ctx)
 	if err != nil {
 		return fmt.Errorf("initialization adapter error: %w", err)
 	}
-	err = a.UploadChunks(fileAsRead, c.bucketName, objName)
+	err = a.UploadChunks(fileAsRead, c.bucketName, objName, o)
 	return err
 }
 
@@ -55,6 +97,25 @@ func (c bucketAzure) Delete(ctx context.Context, objName string) error {
 	return err
 }
 
+// DeleteMany deletes the blobs in parallel, the blob batch API is not exposed by azblob.
+func (c bucketAzure) DeleteMany(ctx context.Context, objNames []string) error {
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return fmt.Errorf("initialization adapter error: %w", err)
+	}
+	return bucket.DeleteEach(ctx, objNames, func(_ context.Context, objName string) error {
+		return a.Delete(c.bucketName, objName)
+	})
+}
+
+func (c bucketAzure) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := c.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return c.DeleteMany(ctx, bucket.Names(objects))
+}
+
 func (c bucketAzure) GenerateGetObjectSignedURL(ctx context.Context, objName string, ttl time.Time) (string, error) {
 	a, err := c.newAdapter(ctx)
 	if err != nil {
@@ -64,34 +125,195 @@ func (c bucketAzure) GenerateGetObjectSignedURL(ctx context.Context, objName str
 	return signedURL, err
 }
 
-func (c bucketAzure) DownloadBytes(ctx context.Context, objName string) ([]byte, error) {
+func (c bucketAzure) DownloadBytes(ctx context.Context, objName string, opts ...bucket.Option) ([]byte, error) {
 
 	a, err := c.newAdapter(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("initialization adapter error: %w", err)
 	}
-	resp, err := a.DownloadBytes(c.bucketName, objName)
+	resp, err := a.DownloadBytes(c.bucketName, objName, bucket.NewOptions(opts...))
 	if err != nil {
 		return nil, fmt.Errorf("reading file from Azure error: %w", err)
 	}
 
-	var downloadedData []byte
-	downloadedData, err = ioutil.ReadAll(resp)
-	err = resp.Close()
+	defer resp.Close()
+
+	downloadedData, err := ioutil.ReadAll(resp)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("reading file from Azure error: %w", err)
 	}
-	return downloadedData, err
+	return downloadedData, nil
 }
 
-func (c bucketAzure) DownloadByChunks(ctx context.Context, objName string) (io.ReadCloser, error) {
+func (c bucketAzure) DownloadByChunks(ctx context.Context, objName string, opts ...bucket.Option) (io.ReadCloser, error) {
 	a, err := c.newAdapter(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("initialization adapter error: %w", err)
 	}
-	resp, err := a.DownloadBytes(c.bucketName, objName)
+	resp, err := a.DownloadBytes(c.bucketName, objName, bucket.NewOptions(opts...))
 	if err != nil {
 		return nil, fmt.Errorf("reading file from Azure error: %w", err)
 	}
 	return resp, nil
 }
+
+func (c bucketAzure) List(ctx context.Context, prefix string) ([]bucket.ObjectAttrs, error) {
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("initialization adapter error: %w", err)
+	}
+	items, err := a.List(c.bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]bucket.ObjectAttrs, len(items))
+	for i, item := range items {
+		list[i] = blobAttrs(item)
+	}
+	return list, nil
+}
+
+func blobAttrs(item azblob.BlobItemInternal) bucket.ObjectAttrs {
+	attrs := bucket.ObjectAttrs{
+		Name:         item.Name,
+		MD5:          item.Properties.ContentMD5,
+		ETag:         string(item.Properties.Etag),
+		Updated:      item.Properties.LastModified,
+		StorageClass: storageClassOf(string(item.Properties.AccessTier)),
+	}
+	if item.Properties.ContentLength != nil {
+		attrs.Size = *item.Properties.ContentLength
+	}
+	return attrs
+}
+
+// SetStorageClass sets the access tier of the blob. A blob leaving the Archive tier keeps it until it is
+// rehydrated, which takes hours, Stat reports the progress. Cold has no Azure tier.
+func (c bucketAzure) SetStorageClass(ctx context.Context, objName string, class bucket.StorageClass) error {
+	tier, ok := accessTiers[class]
+	if !ok {
+		return bucket.ErrUnsupportedStorageClass{Class: class}
+	}
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return fmt.Errorf("initialization adapter error: %w", err)
+	}
+	return a.SetTier(c.bucketName, objName, tier)
+}
+
+// ListVersions returns the blob versions, their IDs are timestamps and sort in creation order.
+func (c bucketAzure) ListVersions(ctx context.Context, objName string) ([]bucket.ObjectVersion, error) {
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("initialization adapter error: %w", err)
+	}
+	items, err := a.ListVersions(c.bucketName, objName)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]bucket.ObjectVersion, len(items))
+	for i, item := range items {
+		versions[i] = bucket.ObjectVersion{ObjectAttrs: blobAttrs(item)}
+		if item.VersionID != nil {
+			versions[i].VersionID = *item.VersionID
+		}
+		if item.IsCurrentVersion != nil {
+			versions[i].IsLatest = *item.IsCurrentVersion
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].VersionID > versions[j].VersionID })
+	return versions, nil
+}
+
+func (c bucketAzure) DownloadVersion(ctx context.Context, objName, versionID string, opts ...bucket.Option) (io.ReadCloser, error) {
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("initialization adapter error: %w", err)
+	}
+	resp, err := a.DownloadVersion(c.bucketName, objName, versionID, bucket.NewOptions(opts...))
+	if err != nil {
+		return nil, fmt.Errorf("reading version from Azure error: %w", err)
+	}
+	return resp, nil
+}
+
+func (c bucketAzure) DeleteVersion(ctx context.Context, objName, versionID string) error {
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return fmt.Errorf("initialization adapter error: %w", err)
+	}
+	return a.DeleteVersion(c.bucketName, objName, versionID)
+}
+
+func (c bucketAzure) Stat(ctx context.Context, objName string, opts ...bucket.Option) (bucket.ObjectAttrs, error) {
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return bucket.ObjectAttrs{}, fmt.Errorf("initialization adapter error: %w", err)
+	}
+	return a.Stat(c.bucketName, objName, bucket.NewOptions(opts...))
+}
+
+func (c bucketAzure) GetTags(ctx context.Context, objName string) (map[string]string, error) {
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("initialization adapter error: %w", err)
+	}
+	return a.GetTags(c.bucketName, objName)
+}
+
+func (c bucketAzure) SetTags(ctx context.Context, objName string, tags map[string]string) error {
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return fmt.Errorf("initialization adapter error: %w", err)
+	}
+	return a.SetTags(c.bucketName, objName, tags)
+}
+
+// FindByTags uses the blob index query, an empty filter matches every blob and lists the container instead.
+func (c bucketAzure) FindByTags(ctx context.Context, filter map[string]string) ([]string, error) {
+	if len(filter) == 0 {
+		objects, err := c.List(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		names := bucket.Names(objects)
+		sort.Strings(names)
+		return names, nil
+	}
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("initialization adapter error: %w", err)
+	}
+	names, err := a.FindByTags(c.bucketName, filter)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (c bucketAzure) GetLifecycle(ctx context.Context) ([]bucket.LifecycleRule, error) {
+
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("initialization adapter error: %w", err)
+	}
+
+	return a.Lifecycle(c.bucketName)
+}
+
+// SetLifecycle replaces the rules of the container in the management policy of the storage account.
+// The rule IDs are the rule names of the policy and have to be unique within the account.
+func (c bucketAzure) SetLifecycle(ctx context.Context, rules []bucket.LifecycleRule) error {
+
+	if err := bucket.ValidateLifecycle(rules); err != nil {
+		return err
+	}
+
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return fmt.Errorf("initialization adapter error: %w", err)
+	}
+
+	return a.SetLifecycle(c.bucketName, rules)
+}
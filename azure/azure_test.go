@@ -3,10 +3,22 @@ package azure
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"git.epam.com/epm-gdsp/cloud-uploader-lab/mocks/azure"
+	"github.com/Azure/azure-pipeline-go/pipeline"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"golang.org/x/oauth2"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 )
@@ -42,7 +54,7 @@ func (s *Suite) TestUploadBytesSuccess() {
 	fileName := "fileName"
 	content := []byte("UsefulInfo")
 
-	s.adapter.On("Upload", content, s.bucket, fileName).Once().Return(nil)
+	s.adapter.On("Upload", content, s.bucket, fileName, bucket.Options{}).Once().Return(nil)
 	err := s.azure.UploadBytes(ctx, content, fileName)
 	s.NoError(err)
 }
@@ -52,7 +64,7 @@ func (s *Suite) TestUploadChunksSuccess() {
 	fileName := "fileName"
 	fileAsReader := reader{}
 
-	s.adapter.On("UploadChunks", fileAsReader, s.bucket, fileName).Once().Return(nil)
+	s.adapter.On("UploadChunks", fileAsReader, s.bucket, fileName, bucket.Options{}).Once().Return(nil)
 	err := s.azure.UploadByChunks(ctx, fileAsReader, fileName)
 	s.NoError(err)
 }
@@ -81,21 +93,659 @@ func (s *Suite) TestDownloadBytesSuccess() {
 	fileName := "fileName"
 	file := []byte("usefulInfo")
 
-	s.adapter.On("DownloadBytes", s.bucket, fileName).Once().Return(ioutil.NopCloser(bytes.NewReader(file)), nil) // reader is not a reader interface
+	s.adapter.On("DownloadBytes", s.bucket, fileName, bucket.Options{}).Once().Return(ioutil.NopCloser(bytes.NewReader(file)), nil) // reader is not a reader interface
 
 	arr, err := s.azure.DownloadBytes(ctx, fileName)
 	s.Equal(arr, file)
 	s.NoError(err)
 }
 
+// TestDownloadBytesTruncated checks that a stream ending early fails the download instead of returning a prefix.
+func (s *Suite) TestDownloadBytesTruncated() {
+	ctx := context.Background()
+	fileName := "fileName"
+	truncated := bucket.Stream(ioutil.NopCloser(bytes.NewReader([]byte("use"))), 10, nil)
+
+	s.adapter.On("DownloadBytes", s.bucket, fileName, bucket.Options{}).Once().Return(truncated, nil)
+
+	arr, err := s.azure.DownloadBytes(ctx, fileName)
+	s.Nil(arr)
+	s.ErrorIs(err, io.ErrUnexpectedEOF)
+}
+
 func (s *Suite) TestDownloadByChunksSuccess() {
 	ctx := context.Background()
 	fileName := "fileName"
 	file := []byte("usefulInfo")
 
-	s.adapter.On("DownloadBytes", s.bucket, fileName).Once().Return(ioutil.NopCloser(bytes.NewReader(file)), nil) // reader is not a reader interface
+	s.adapter.On("DownloadBytes", s.bucket, fileName, bucket.Options{}).Once().Return(ioutil.NopCloser(bytes.NewReader(file)), nil) // reader is not a reader interface
 
 	arr, err := s.azure.DownloadByChunks(ctx, fileName)
 	s.Equal(arr, ioutil.NopCloser(bytes.NewReader(file)))
 	s.NoError(err)
 }
+
+func (s *Suite) TestListSuccess() {
+	ctx := context.Background()
+	prefix := "dir/"
+	size := int64(3)
+	updated := time.Now()
+	items := []azblob.BlobItemInternal{{
+		Name: "dir/a",
+		Properties: azblob.BlobProperties{
+			ContentLength: &size,
+			ContentMD5:    []byte("md5"),
+			Etag:          "etag",
+			LastModified:  updated,
+		},
+	}}
+
+	s.adapter.On("List", s.bucket, prefix).Once().Return(items, nil)
+	list, err := s.azure.List(ctx, prefix)
+	s.Equal([]bucket.ObjectAttrs{{Name: "dir/a", Size: 3, MD5: []byte("md5"), ETag: "etag", Updated: updated}}, list)
+	s.NoError(err)
+}
+
+func (s *Suite) TestStatSuccess() {
+	ctx := context.Background()
+	fileName := "fileName"
+	attrs := bucket.ObjectAttrs{Name: fileName, Size: 3, ContentType: "text/plain"}
+
+	s.adapter.On("Stat", s.bucket, fileName, bucket.Options{}).Once().Return(attrs, nil)
+	got, err := s.azure.Stat(ctx, fileName)
+	s.Equal(attrs, got)
+	s.NoError(err)
+}
+
+func (s *Suite) TestDeletePrefix() {
+	ctx := context.Background()
+	prefix := "dir/"
+	e := errors.New("error")
+
+	s.adapter.On("List", s.bucket, prefix).Once().
+		Return([]azblob.BlobItemInternal{{Name: "dir/a"}, {Name: "dir/b"}}, nil)
+	s.adapter.On("Delete", s.bucket, "dir/a").Once().Return(nil)
+	s.adapter.On("Delete", s.bucket, "dir/b").Once().Return(e)
+
+	err := s.azure.DeletePrefix(ctx, prefix)
+	s.Equal(bucket.ErrDeleteFailed{Errors: map[string]error{"dir/b": e}}, err)
+}
+
+func (s *Suite) TestUploadIfMatch() {
+	ctx := context.Background()
+	fileName := "fileName"
+	content := []byte("UsefulInfo")
+	etag := "0x8D9A0F3A1C2B3D4"
+
+	s.adapter.On("Upload", content, s.bucket, fileName, bucket.Options{IfMatch: etag}).Once().
+		Return(bucket.ErrPreconditionFailed{})
+	err := s.azure.UploadBytes(ctx, content, fileName, bucket.IfMatch(etag))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+}
+
+func (s *Suite) TestAccessConditions() {
+	since := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	opts := bucket.NewOptions(bucket.IfNoneMatch(bucket.ETagAny), bucket.IfModifiedSince(since))
+
+	s.Equal(azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny},
+	}, accessConditions(opts, false))
+	s.Equal(azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{IfNoneMatch: azblob.ETagAny, IfModifiedSince: since},
+	}, accessConditions(opts, true))
+}
+
+func (s *Suite) TestListVersions() {
+	ctx := context.Background()
+	fileName := "fileName"
+	older, newer := "2021-11-01T10:00:00.0000000Z", "2021-11-02T10:00:00.0000000Z"
+	current := true
+
+	s.adapter.On("ListVersions", s.bucket, fileName).Once().Return([]azblob.BlobItemInternal{
+		{Name: fileName, VersionID: &older},
+		{Name: fileName, VersionID: &newer, IsCurrentVersion: &current},
+	}, nil)
+
+	versions, err := s.azure.ListVersions(ctx, fileName)
+	s.NoError(err)
+	s.Require().Len(versions, 2)
+	s.Equal(newer, versions[0].VersionID)
+	s.True(versions[0].IsLatest)
+	s.Equal(older, versions[1].VersionID)
+	s.False(versions[1].IsLatest)
+}
+
+func (s *Suite) TestDeleteVersion() {
+	ctx := context.Background()
+	fileName := "fileName"
+	versionID := "2021-11-01T10:00:00.0000000Z"
+
+	s.adapter.On("DeleteVersion", s.bucket, fileName, versionID).Once().Return(nil)
+	s.NoError(s.azure.DeleteVersion(ctx, fileName, versionID))
+}
+
+func (s *Suite) TestFindByTags() {
+	ctx := context.Background()
+	filter := map[string]string{"tenant": "acme"}
+
+	s.adapter.On("FindByTags", s.bucket, filter).Once().Return([]string{"b", "a"}, nil)
+	names, err := s.azure.FindByTags(ctx, filter)
+	s.NoError(err)
+	s.Equal([]string{"a", "b"}, names)
+}
+
+func (s *Suite) TestTagQuery() {
+	s.Equal(`@container='bucket' AND "class"='gold' AND "tenant"='acme'`,
+		tagQuery(s.bucket, map[string]string{"tenant": "acme", "class": "gold"}))
+}
+
+func (s *Suite) TestSetLifecycle() {
+	ctx := context.Background()
+	rules := []bucket.LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpireAfterDays: 7}}
+
+	s.adapter.On("SetLifecycle", s.bucket, rules).Once().Return(nil)
+	s.NoError(s.azure.SetLifecycle(ctx, rules))
+
+	err := s.azure.SetLifecycle(ctx, []bucket.LifecycleRule{{ID: "tmp"}})
+	s.Equal(bucket.ErrInvalidLifecycleRule{ID: "tmp", Reason: "no action"}, err)
+}
+
+func (s *Suite) TestManagementPolicy() {
+	var policy managementPolicy
+	s.Require().NoError(json.Unmarshal([]byte(`{"properties": {"policy": {"rules": [
+		{"enabled": true, "name": "other", "type": "Lifecycle", "definition": {
+			"actions": {"baseBlob": {"delete": {"daysAfterModificationGreaterThan": 1}}, "snapshot": {"delete": {"daysAfterCreationGreaterThan": 1}}},
+			"filters": {"blobTypes": ["blockBlob"], "prefixMatch": ["other/"]}}},
+		{"enabled": true, "name": "old", "type": "Lifecycle", "definition": {
+			"actions": {"baseBlob": {"delete": {"daysAfterModificationGreaterThan": 1}}},
+			"filters": {"blobTypes": ["blockBlob"], "prefixMatch": ["bucket/old/"]}}}
+	]}}}`), &policy))
+
+	rules := []bucket.LifecycleRule{
+		{ID: "tmp", Prefix: "tmp/", ExpireAfterDays: 7},
+		{ID: "logs", Prefix: "logs/", ExpireAfterDays: 365, TransitionAfterDays: 30, TransitionStorageClass: bucket.StorageClassArchive},
+	}
+	updated, err := withContainerRules(policy, s.bucket, rules)
+	s.Require().NoError(err)
+	s.Require().Len(updated.Properties.Policy.Rules, 3)
+	s.JSONEq(string(policy.Properties.Policy.Rules[0]), string(updated.Properties.Policy.Rules[0]))
+
+	got, err := containerRules(updated, s.bucket)
+	s.NoError(err)
+	s.Equal(rules, got)
+
+	_, err = withContainerRules(policy, s.bucket, []bucket.LifecycleRule{
+		{ID: "cold", TransitionAfterDays: 1, TransitionStorageClass: bucket.StorageClassCold},
+	})
+	s.Equal(bucket.ErrUnsupportedStorageClass{Class: bucket.StorageClassCold}, err)
+}
+
+// TestOpenBucket creates the container through a server that already has it and checks the calls of the bucket
+// use the pipeline of OpenBucket with their own context.
+func (s *Suite) TestOpenBucket() {
+	var creates, deletes int
+	var access []string
+	exists := false
+	status := http.StatusCreated
+	writeErr := func(w http.ResponseWriter, status int, code string) {
+		w.Header().Set("x-ms-error-code", code)
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?><Error><Code>`+code+`</Code><Message>m</Message></Error>`)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isContainer := r.URL.Query().Get("restype") == "container"
+		switch {
+		case status == http.StatusForbidden:
+			writeErr(w, status, "AuthorizationFailure")
+		case r.Method == http.MethodGet && isContainer:
+			if !exists {
+				writeErr(w, http.StatusNotFound, "ContainerNotFound")
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut && isContainer:
+			creates++
+			access = append(access, r.Header.Get("x-ms-blob-public-access"))
+			if exists {
+				writeErr(w, http.StatusConflict, "ContainerAlreadyExists")
+				return
+			}
+			exists = true
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodDelete && isContainer:
+			if !exists {
+				writeErr(w, http.StatusNotFound, "ContainerNotFound")
+				return
+			}
+			exists = false
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodDelete:
+			deletes++
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/account")
+	s.Require().NoError(err)
+	serviceURL := azblob.NewServiceURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
+	acc := account{serviceURL: serviceURL}
+	ctx := context.Background()
+
+	_, err = openBucket(ctx, acc, s.bucket, options{})
+	s.Equal(bucket.ErrBucketNotFound{Bucket: s.bucket}, err)
+	s.Zero(creates)
+
+	b, err := openBucket(ctx, acc, s.bucket, options{create: true})
+	s.Require().NoError(err)
+	s.Equal(1, creates)
+	_, err = openBucket(ctx, acc, s.bucket, options{create: true})
+	s.Require().NoError(err)
+	s.Equal(1, creates)
+
+	s.NoError(b.Delete(ctx, "a"))
+	s.NoError(b.DeleteMany(ctx, []string{"b", "c"}))
+	s.Equal(1, creates)
+	s.Equal(3, deletes)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	s.Error(b.Delete(canceled, "a"))
+	s.Equal(3, deletes)
+
+	admin := &Admin{account: acc}
+	s.Equal(bucket.ErrBucketExists{Bucket: s.bucket}, admin.CreateBucket(ctx, s.bucket))
+	s.Equal(bucket.ErrUnsupportedLocation{Location: "westeurope"}, admin.CreateBucket(ctx, "other", bucket.WithLocation("westeurope")))
+	s.NoError(admin.DeleteBucket(ctx, s.bucket))
+	s.Equal(bucket.ErrBucketNotFound{Bucket: s.bucket}, admin.DeleteBucket(ctx, s.bucket))
+	s.NoError(admin.CreateBucket(ctx, s.bucket, bucket.WithPublicRead()))
+	s.NoError(admin.DeleteBucket(ctx, s.bucket))
+	s.Equal([]string{"", "", "blob"}, access, "containers are private unless public read is asked for")
+
+	status = http.StatusForbidden
+	_, err = openBucket(ctx, acc, s.bucket, options{create: true})
+	var stgErr azblob.StorageError
+	s.Require().ErrorAs(err, &stgErr)
+	s.Equal(azblob.ServiceCodeType("AuthorizationFailure"), stgErr.ServiceCode())
+}
+
+// devKey is the well-known key of the storage emulator.
+const devKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+func (s *Suite) TestConnectionString() {
+	ctx := context.Background()
+	acc, err := connectionStringAccount("DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=" + devKey +
+		";BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1;")
+	s.Require().NoError(err)
+	s.Require().NotNil(acc.sharedKey)
+	s.Equal("devstoreaccount1", acc.sharedKey.AccountName())
+
+	a := &adapter{ctx: ctx, account: acc}
+	signed, err := a.GenerateSignedURL(s.bucket, "dir/a b", time.Now().Add(time.Hour))
+	s.Require().NoError(err)
+	u, err := url.Parse(signed)
+	s.Require().NoError(err)
+	s.Equal("127.0.0.1:10000", u.Host)
+	s.Equal("/devstoreaccount1/bucket/dir/a b", u.Path)
+	s.Equal("https,http", u.Query().Get("spr"))
+	s.NotEmpty(u.Query().Get("sig"))
+
+	acc, err = connectionStringAccount("AccountName=name;AccountKey=" + devKey)
+	s.Require().NoError(err)
+	s.Equal("https://name.blob.core.windows.net", acc.serviceURL.String())
+
+	acc, err = connectionStringAccount("BlobEndpoint=https://name.blob.core.windows.net;SharedAccessSignature=sv=2020-08-04&sig=abc")
+	s.Require().NoError(err)
+	s.True(acc.containerSAS)
+	s.Equal("name", acc.name)
+
+	// lifecycle rules need a token credential
+	_, _, err = (&adapter{ctx: ctx, account: acc}).managementClient()
+	s.ErrorIs(err, ErrManagementUnavailable{})
+
+	_, err = connectionStringAccount("AccountName=name")
+	s.Error(err)
+	_, err = connectionStringAccount("AccountName")
+	s.Error(err)
+}
+
+// TestSASToken opens the bucket without checking or creating the container and keeps the token on every URL.
+func (s *Suite) TestSASToken() {
+	ctx := context.Background()
+	acc, err := sasAccount("https://name.blob.core.windows.net", "name", "?sv=2020-08-04&sr=c&sig=abc")
+	s.Require().NoError(err)
+
+	b, err := openBucket(ctx, acc, s.bucket, options{create: true})
+	s.Require().NoError(err)
+	a, err := b.newAdapter(ctx)
+	s.Require().NoError(err)
+	blobURL := a.(*adapter).blobURL(s.bucket, "a").URL()
+	s.Equal("/bucket/a", blobURL.Path)
+	s.Equal("abc", blobURL.Query().Get("sig"))
+
+	_, err = b.GenerateGetObjectSignedURL(ctx, "a", time.Now().Add(time.Hour))
+	s.ErrorIs(err, ErrSigningUnavailable{})
+}
+
+type tokenSource struct {
+	token *oauth2.Token
+	err   error
+}
+
+func (ts *tokenSource) Token() (*oauth2.Token, error) {
+	return ts.token, ts.err
+}
+
+func (s *Suite) TestTokenRefresher() {
+	now := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	ts := &tokenSource{token: &oauth2.Token{AccessToken: "first", Expiry: now.Add(40 * time.Minute)}}
+	credential := azblob.NewTokenCredential("initial", nil)
+	refresh := tokenRefresher(ts, func() time.Time { return now })
+
+	s.Equal(30*time.Minute, refresh(credential))
+	s.Equal("first", credential.Token())
+
+	ts.token = &oauth2.Token{AccessToken: "second", Expiry: now.Add(time.Second)}
+	s.Equal(minTokenRefresh, refresh(credential))
+	s.Equal("second", credential.Token())
+
+	ts.err = errors.New("unavailable")
+	s.Equal(tokenRetryInterval, refresh(credential))
+	s.Equal("second", credential.Token())
+
+	ts.err, ts.token = nil, &oauth2.Token{AccessToken: "static"}
+	s.Zero(refresh(credential))
+}
+
+// TestUserDelegation signs URLs of a bucket authorized by a token with a user delegation key.
+func (s *Suite) TestUserDelegation() {
+	ctx := context.Background()
+	var authorization string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Query().Get("comp") != "userdelegationkey" {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?><UserDelegationKey>`+
+			`<SignedOid>oid</SignedOid><SignedTid>tid</SignedTid>`+
+			`<SignedStart>2021-11-01T00:00:00Z</SignedStart><SignedExpiry>2021-11-02T00:00:00Z</SignedExpiry>`+
+			`<SignedService>b</SignedService><SignedVersion>2020-02-10</SignedVersion>`+
+			`<Value>`+devKey+`</Value></UserDelegationKey>`)
+	}))
+	defer srv.Close()
+
+	sender := pipeline.FactoryFunc(func(next pipeline.Policy, po *pipeline.PolicyOptions) pipeline.PolicyFunc {
+		return func(ctx context.Context, request pipeline.Request) (pipeline.Response, error) {
+			resp, err := srv.Client().Do(request.WithContext(ctx))
+			return pipeline.NewHTTPResponse(resp), err
+		}
+	})
+	u, err := url.Parse(srv.URL)
+	s.Require().NoError(err)
+	credential := azblob.NewTokenCredential("token", nil)
+	acc := account{
+		serviceURL: azblob.NewServiceURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{HTTPSender: sender})),
+		delegation: true,
+	}
+
+	a := &adapter{ctx: ctx, account: acc}
+	signed, err := a.GenerateSignedURL(s.bucket, "a", time.Now().Add(time.Hour))
+	s.Require().NoError(err)
+	s.Equal("Bearer token", authorization)
+	signedURL, err := url.Parse(signed)
+	s.Require().NoError(err)
+	s.Equal("oid", signedURL.Query().Get("skoid"))
+	s.NotEmpty(signedURL.Query().Get("sig"))
+}
+
+// TestManagementToken manages the lifecycle rules with the token source of the bucket.
+func (s *Suite) TestManagementToken() {
+	ctx := context.Background()
+	var authorization, path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization, path = r.Header.Get("Authorization"), r.URL.Path
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	endpoint := managementEndpoint
+	managementEndpoint = srv.URL
+	defer func() { managementEndpoint = endpoint }()
+	s.T().Setenv("AZURE_SUBSCRIPTION_ID", "subscription")
+	s.T().Setenv("AZURE_RESOURCE_GROUP", "group")
+
+	acc, err := tokenAccount("https://name.blob.core.windows.net", "name", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))
+	s.Require().NoError(err)
+	rules, err := (&adapter{ctx: ctx, account: acc}).Lifecycle(s.bucket)
+	s.Require().NoError(err)
+	s.Empty(rules)
+	s.Equal("Bearer token", authorization)
+	s.Equal("/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Storage/storageAccounts/name/managementPolicies/default", path)
+
+	acc, err = sasAccount("https://name.blob.core.windows.net", "name", "?sv=2020-08-04&sr=c&sig=abc")
+	s.Require().NoError(err)
+	_, err = (&adapter{ctx: ctx, account: acc}).Lifecycle(s.bucket)
+	s.ErrorIs(err, ErrManagementUnavailable{})
+}
+
+// TestEncryption checks the headers of customer-provided keys and encryption scopes and the encryption reported by Stat.
+func (s *Suite) TestEncryption() {
+	key := bytes.Repeat([]byte{7}, 32)
+	sum := sha256.Sum256(key)
+	keySHA256 := base64.StdEncoding.EncodeToString(sum[:])
+	var headers http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		switch r.Method {
+		case http.MethodPut:
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			w.Header().Set("Content-Length", "4")
+			w.WriteHeader(http.StatusOK)
+			_, _ = io.WriteString(w, "data")
+		case http.MethodHead:
+			w.Header().Set("x-ms-server-encrypted", "true")
+			w.Header().Set("x-ms-encryption-key-sha256", keySHA256)
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/account")
+	s.Require().NoError(err)
+	acc := account{serviceURL: azblob.NewServiceURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))}
+	a := &adapter{ctx: context.Background(), account: acc}
+
+	s.Require().NoError(a.Upload([]byte("data"), s.bucket, "a", bucket.NewOptions(bucket.WithCustomerKey(key))))
+	s.Equal(base64.StdEncoding.EncodeToString(key), headers.Get("x-ms-encryption-key"))
+	s.Equal(keySHA256, headers.Get("x-ms-encryption-key-sha256"))
+	s.Equal("AES256", headers.Get("x-ms-encryption-algorithm"))
+
+	rc, err := a.DownloadBytes(s.bucket, "a", bucket.NewOptions(bucket.WithCustomerKey(key)))
+	s.Require().NoError(err)
+	s.NoError(rc.Close())
+	s.Equal(keySHA256, headers.Get("x-ms-encryption-key-sha256"))
+
+	s.Require().NoError(a.Upload([]byte("data"), s.bucket, "b", bucket.NewOptions(bucket.WithKMSKey("scope"))))
+	s.Equal("scope", headers.Get("x-ms-encryption-scope"))
+	s.Empty(headers.Get("x-ms-encryption-key"))
+
+	attrs, err := a.Stat(s.bucket, "a", bucket.NewOptions(bucket.WithCustomerKey(key)))
+	s.Require().NoError(err)
+	s.Equal(keySHA256, headers.Get("x-ms-encryption-key-sha256"), "properties of the blob are read with the key")
+	s.Equal(bucket.Encryption{Type: bucket.EncryptionCustomerKey}, attrs.Encryption)
+
+	s.Equal(bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: "scope"}, blobEncryption("", "scope", "true"))
+	s.Equal(bucket.Encryption{Type: bucket.EncryptionProviderKey}, blobEncryption("", defaultEncryptionScope, "true"))
+
+	var unsupported bucket.ErrUnsupportedEncryption
+	s.ErrorAs(a.Upload([]byte("data"), s.bucket, "c", bucket.NewOptions(bucket.WithKMSKey(""))), &unsupported)
+	s.ErrorAs(a.Upload([]byte("data"), s.bucket, "c", bucket.NewOptions(bucket.WithCustomerKey(key[:16]))), &unsupported)
+	admin := &Admin{account: acc}
+	s.ErrorAs(admin.CreateBucket(context.Background(), "other", bucket.WithDefaultEncryption(bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: "scope"})), &unsupported)
+}
+
+// TestDownloadRetry resumes a download of a blob encrypted with a customer key that broke off, with the key.
+func (s *Suite) TestDownloadRetry() {
+	key := bytes.Repeat([]byte{7}, 32)
+	var keys, ranges []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("x-ms-encryption-key"))
+		ranges = append(ranges, r.Header.Get("x-ms-range"))
+		w.Header().Set("ETag", `"etag"`)
+		if len(keys) == 1 {
+			w.Header().Set("Content-Length", "4")
+			w.WriteHeader(http.StatusOK)
+			_, _ = io.WriteString(w, "da")
+			return
+		}
+		w.Header().Set("Content-Length", "2")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = io.WriteString(w, "ta")
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/account")
+	s.Require().NoError(err)
+	acc := account{serviceURL: azblob.NewServiceURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))}
+	a := &adapter{ctx: context.Background(), account: acc}
+
+	rc, err := a.DownloadBytes(s.bucket, "a", bucket.NewOptions(bucket.WithCustomerKey(key)))
+	s.Require().NoError(err)
+	data, err := ioutil.ReadAll(rc)
+	s.Require().NoError(err)
+	s.NoError(rc.Close())
+	s.Equal("data", string(data))
+	encoded := base64.StdEncoding.EncodeToString(key)
+	s.Equal([]string{encoded, encoded}, keys)
+	s.Equal([]string{"", "bytes=2-"}, ranges)
+}
+
+func (s *Suite) TestStorageClass() {
+	var tiers []string
+	rehydrating := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Query().Get("comp") == "tier":
+			tiers = append(tiers, r.Header.Get("x-ms-access-tier"))
+			if rehydrating {
+				w.Header().Set("x-ms-error-code", "BlobBeingRehydrated")
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			rehydrating = true
+			w.WriteHeader(http.StatusAccepted)
+		case r.Method == http.MethodPut:
+			// staged blocks have no tier, the block list sets it
+			if r.URL.Query().Get("comp") != "block" {
+				tiers = append(tiers, r.Header.Get("x-ms-access-tier"))
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodHead:
+			w.Header().Set("x-ms-access-tier", "Archive")
+			w.Header().Set("x-ms-archive-status", "rehydrate-pending-to-hot")
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/account")
+	s.Require().NoError(err)
+	acc := account{serviceURL: azblob.NewServiceURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))}
+	a := &adapter{ctx: context.Background(), account: acc}
+
+	s.Require().NoError(a.Upload([]byte("data"), s.bucket, "a", bucket.NewOptions(bucket.WithStorageClass(bucket.StorageClassArchive))))
+	s.Require().NoError(a.UploadChunks(bytes.NewReader([]byte("data")), s.bucket, "b", bucket.NewOptions(bucket.WithStorageClass(bucket.StorageClassCool))))
+	s.ErrorAs(a.Upload([]byte("data"), s.bucket, "c", bucket.NewOptions(bucket.WithStorageClass(bucket.StorageClassCold))), &bucket.ErrUnsupportedStorageClass{})
+
+	attrs, err := a.Stat(s.bucket, "a", bucket.Options{})
+	s.Require().NoError(err)
+	s.Equal(bucket.StorageClassArchive, attrs.StorageClass)
+	s.Equal(&bucket.Rehydration{Pending: true, Class: bucket.StorageClassHot}, attrs.Rehydration)
+
+	s.NoError(a.SetTier(s.bucket, "a", azblob.AccessTierHot))
+	s.Equal(bucket.ErrRehydrationPending{Name: "a"}, a.SetTier(s.bucket, "a", azblob.AccessTierHot))
+	s.Equal([]string{"Archive", "Cool", "Hot", "Hot"}, tiers)
+
+	s.adapter.On("SetTier", s.bucket, "a", azblob.AccessTierCool).Once().Return(nil)
+	s.NoError(s.azure.SetStorageClass(context.Background(), "a", bucket.StorageClassCool))
+	s.ErrorAs(s.azure.SetStorageClass(context.Background(), "a", bucket.StorageClassCold), &bucket.ErrUnsupportedStorageClass{})
+	s.adapter.AssertExpectations(s.T())
+}
+
+func (s *Suite) TestNewWriter() {
+	ctx := context.Background()
+	fileName := "fileName"
+
+	s.adapter.On("Upload", []byte("abc"), s.bucket, fileName, bucket.Options{}).Once().Return(nil)
+	w := s.azure.NewWriter(ctx, fileName, bucket.WithProgress(func(bucket.Progress) {}))
+	_, err := io.WriteString(w, "abc")
+	s.NoError(err)
+	s.NoError(w.Close())
+
+	var ids []string
+	s.adapter.On("StageBlock", s.bucket, fileName, mock.Anything, mock.Anything, bucket.Options{}).Times(3).
+		Run(func(args mock.Arguments) { ids = append(ids, args.String(2)) }).Return(nil)
+	s.adapter.On("CommitBlockList", s.bucket, fileName, mock.Anything, "text/plain; charset=utf-8", bucket.Options{}).Once().
+		Return(nil)
+	w = s.azure.NewWriter(ctx, fileName)
+	_, err = w.Write(bytes.Repeat([]byte("a"), 2*blockSize+1))
+	s.NoError(err)
+	s.NoError(w.Close())
+	s.Len(ids, 3)
+	s.Equal(ids, s.adapter.Calls[len(s.adapter.Calls)-1].Arguments.Get(2))
+	for _, id := range ids {
+		s.Len(id, len(ids[0]))
+	}
+
+	// an aborted writer commits nothing, the staged blocks are left to the service
+	s.adapter.On("StageBlock", s.bucket, fileName, mock.Anything, mock.Anything, bucket.Options{}).Once().Return(nil)
+	w = s.azure.NewWriter(ctx, fileName)
+	_, err = w.Write(make([]byte, blockSize+1))
+	s.NoError(err)
+	s.NoError(w.CloseWithError(nil))
+	s.ErrorIs(w.Close(), bucket.ErrWriterClosed{})
+
+	w = s.azure.NewWriter(ctx, fileName, bucket.WithStorageClass(bucket.StorageClassCold))
+	s.ErrorAs(w.Close(), &bucket.ErrUnsupportedStorageClass{})
+	s.ErrorAs(s.azure.NewWriter(ctx, fileName, bucket.WithTTL(time.Hour)).Close(), &bucket.ErrUnsupportedTTL{})
+	s.ErrorAs(s.azure.UploadBytes(ctx, []byte("data"), fileName, bucket.WithTTL(time.Hour)), &bucket.ErrUnsupportedTTL{})
+	s.adapter.AssertExpectations(s.T())
+}
+
+// TestCommitBlockList checks the requests of staged uploads against a stand-in server.
+func (s *Suite) TestCommitBlockList() {
+	var blocks []string
+	var blockList, tier, contentType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("comp") {
+		case "block":
+			blocks = append(blocks, r.URL.Query().Get("blockid"))
+		case "blocklist":
+			body, _ := io.ReadAll(r.Body)
+			blockList = string(body)
+			tier = r.Header.Get("x-ms-access-tier")
+			contentType = r.Header.Get("x-ms-blob-content-type")
+			if r.Header.Get("If-None-Match") == "*" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL + "/account")
+	s.Require().NoError(err)
+	acc := account{serviceURL: azblob.NewServiceURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))}
+	a := &adapter{ctx: context.Background(), account: acc}
+
+	s.Require().NoError(a.StageBlock(s.bucket, "a", "MDA=", []byte("data"), bucket.Options{}))
+	s.Require().NoError(a.CommitBlockList(s.bucket, "a", []string{"MDA="}, "text/csv", bucket.NewOptions(bucket.WithStorageClass(bucket.StorageClassCool))))
+	s.Equal([]string{"MDA="}, blocks)
+	s.Contains(blockList, "<Latest>MDA=</Latest>")
+	s.Equal("Cool", tier)
+	s.Equal("text/csv", contentType)
+
+	err = a.CommitBlockList(s.bucket, "a", []string{"MDA="}, "text/csv", bucket.NewOptions(bucket.IfNoneMatch(bucket.ETagAny)))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+}
@@ -0,0 +1,125 @@
+package azure
+
+import (
+	"encoding/json"
+	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"strings"
+)
+
+// managementPolicy is the lifecycle management policy of a storage account. It is shared by all containers
+// of the account, the rules of other containers are kept as they are.
+type managementPolicy struct {
+	Properties struct {
+		Policy struct {
+			Rules []json.RawMessage `json:"rules"`
+		} `json:"policy"`
+	} `json:"properties"`
+}
+
+type policyRule struct {
+	Enabled    bool   `json:"enabled"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Definition struct {
+		Actions struct {
+			BaseBlob baseBlobActions `json:"baseBlob"`
+		} `json:"actions"`
+		Filters struct {
+			BlobTypes   []string `json:"blobTypes"`
+			PrefixMatch []string `json:"prefixMatch,omitempty"`
+		} `json:"filters"`
+	} `json:"definition"`
+}
+
+type baseBlobActions struct {
+	TierToCool    *afterModification `json:"tierToCool,omitempty"`
+	TierToArchive *afterModification `json:"tierToArchive,omitempty"`
+	Delete        *afterModification `json:"delete,omitempty"`
+}
+
+type afterModification struct {
+	DaysAfterModificationGreaterThan int `json:"daysAfterModificationGreaterThan"`
+}
+
+// containerRule reports whether the rule belongs to the container, every prefix of it has to be in the container.
+func (r policyRule) containerRule(bucketName string) bool {
+	if len(r.Definition.Filters.PrefixMatch) != 1 {
+		return false
+	}
+	return strings.HasPrefix(r.Definition.Filters.PrefixMatch[0], bucketName+"/")
+}
+
+// containerRules returns the rules of the policy that belong to the container. The rule names are the IDs.
+func containerRules(policy managementPolicy, bucketName string) ([]bucket.LifecycleRule, error) {
+	var rules []bucket.LifecycleRule
+	for _, raw := range policy.Properties.Policy.Rules {
+		var r policyRule
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, fmt.Errorf("decoding management policy error: %w", err)
+		}
+		if !r.Enabled || !r.containerRule(bucketName) {
+			continue
+		}
+		rule := bucket.LifecycleRule{
+			ID:     r.Name,
+			Prefix: strings.TrimPrefix(r.Definition.Filters.PrefixMatch[0], bucketName+"/"),
+		}
+		actions := r.Definition.Actions.BaseBlob
+		if actions.Delete != nil {
+			rule.ExpireAfterDays = actions.Delete.DaysAfterModificationGreaterThan
+		}
+		if actions.TierToCool != nil {
+			rule.TransitionAfterDays = actions.TierToCool.DaysAfterModificationGreaterThan
+			rule.TransitionStorageClass = bucket.StorageClassCool
+		}
+		if actions.TierToArchive != nil {
+			rule.TransitionAfterDays = actions.TierToArchive.DaysAfterModificationGreaterThan
+			rule.TransitionStorageClass = bucket.StorageClassArchive
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// withContainerRules replaces the rules of the container in the policy. Azure tiers blobs to Cool and Archive only.
+func withContainerRules(policy managementPolicy, bucketName string, rules []bucket.LifecycleRule) (managementPolicy, error) {
+	var kept []json.RawMessage
+	for _, raw := range policy.Properties.Policy.Rules {
+		var r policyRule
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return managementPolicy{}, fmt.Errorf("decoding management policy error: %w", err)
+		}
+		if !r.containerRule(bucketName) {
+			kept = append(kept, raw)
+		}
+	}
+
+	for _, rule := range rules {
+		r := policyRule{Enabled: true, Name: rule.ID, Type: "Lifecycle"}
+		r.Definition.Filters.BlobTypes = []string{"blockBlob"}
+		r.Definition.Filters.PrefixMatch = []string{bucketName + "/" + rule.Prefix}
+		actions := &r.Definition.Actions.BaseBlob
+		if rule.ExpireAfterDays > 0 {
+			actions.Delete = &afterModification{rule.ExpireAfterDays}
+		}
+		if rule.TransitionAfterDays > 0 {
+			switch rule.TransitionStorageClass {
+			case bucket.StorageClassCool:
+				actions.TierToCool = &afterModification{rule.TransitionAfterDays}
+			case bucket.StorageClassArchive:
+				actions.TierToArchive = &afterModification{rule.TransitionAfterDays}
+			default:
+				return managementPolicy{}, bucket.ErrUnsupportedStorageClass{Class: rule.TransitionStorageClass}
+			}
+		}
+		raw, err := json.Marshal(r)
+		if err != nil {
+			return managementPolicy{}, err
+		}
+		kept = append(kept, raw)
+	}
+
+	policy.Properties.Policy.Rules = kept
+	return policy, nil
+}
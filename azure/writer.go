@@ -0,0 +1,91 @@
+package azure
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+)
+
+// NewWriter stages the writes as blocks of blockSize and commits the block list on Close, smaller blobs are
+// uploaded by a single request. CloseWithError leaves the staged blocks uncommitted, the service discards them.
+func (c bucketAzure) NewWriter(ctx context.Context, objName string, opts ...bucket.Option) bucket.Writer {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.FailedWriter(bucket.ErrUnsupportedTTL{TTL: o.TTL})
+	}
+	if _, err := clientProvidedKey(o.Encryption); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	if _, err := accessTier(o.StorageClass); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	a, err := c.newAdapter(ctx)
+	if err != nil {
+		return bucket.FailedWriter(fmt.Errorf("initialization adapter error: %w", err))
+	}
+	var prefix [8]byte
+	if _, err := rand.Read(prefix[:]); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	// the writer reports the progress, the requests must not report it again
+	uo := o
+	uo.Progress = nil
+	return bucket.NewUploadWriter(&blockUpload{a: a, bucketName: c.bucketName, objName: objName, o: uo, prefix: fmt.Sprintf("%x", prefix)}, o)
+}
+
+// blockUpload is the upload of NewWriter. The IDs of the blocks of a blob have to be of the same length,
+// they are made of a random prefix and the index of the block.
+type blockUpload struct {
+	a          adapterInterface
+	bucketName string
+	objName    string
+	o          bucket.Options
+	prefix     string
+
+	buf         []byte
+	blockIDs    []string
+	contentType string
+}
+
+// Write keeps at least one byte in the buffer, so the last block is never empty.
+func (u *blockUpload) Write(p []byte) (int, error) {
+	u.buf = append(u.buf, p...)
+	for len(u.buf) > blockSize {
+		if err := u.stage(u.buf[:blockSize]); err != nil {
+			return len(p), err
+		}
+		u.buf = append(u.buf[:0], u.buf[blockSize:]...)
+	}
+	return len(p), nil
+}
+
+func (u *blockUpload) stage(block []byte) error {
+	if u.blockIDs == nil {
+		u.contentType = http.DetectContentType(block)
+	}
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%06d", u.prefix, len(u.blockIDs))))
+	if err := u.a.StageBlock(u.bucketName, u.objName, id, block, u.o); err != nil {
+		return err
+	}
+	u.blockIDs = append(u.blockIDs, id)
+	return nil
+}
+
+func (u *blockUpload) Commit() error {
+	if u.blockIDs == nil {
+		return u.a.Upload(u.buf, u.bucketName, u.objName, u.o)
+	}
+	if err := u.stage(u.buf); err != nil {
+		return err
+	}
+	return u.a.CommitBlockList(u.bucketName, u.objName, u.blockIDs, u.contentType, u.o)
+}
+
+func (u *blockUpload) Abort() error {
+	u.buf = nil
+	return nil
+}
@@ -0,0 +1,119 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrBucketNotFound is returned for a bucket that does not exist, e.g. by OpenBucket without a create option.
+type ErrBucketNotFound struct {
+	Bucket string
+}
+
+func (e ErrBucketNotFound) Error() string {
+	return fmt.Sprintf("bucket %q not found", e.Bucket)
+}
+
+// ErrBucketExists is returned by CreateBucket when the name is taken.
+type ErrBucketExists struct {
+	Bucket string
+}
+
+func (e ErrBucketExists) Error() string {
+	return fmt.Sprintf("bucket %q already exists", e.Bucket)
+}
+
+// ErrUnsupportedLocation is returned by CreateBucket of providers that can not place a bucket in Location.
+type ErrUnsupportedLocation struct {
+	Location string
+}
+
+func (e ErrUnsupportedLocation) Error() string {
+	return fmt.Sprintf("unsupported bucket location %q", e.Location)
+}
+
+// ErrUnsupportedPublicRead is returned by CreateBucket of providers that can not grant anonymous read access.
+type ErrUnsupportedPublicRead struct {
+	Bucket string
+}
+
+func (e ErrUnsupportedPublicRead) Error() string {
+	return fmt.Sprintf("public read access to bucket %q is not supported", e.Bucket)
+}
+
+// CreateOption configures a bucket created by CreateBucket.
+type CreateOption func(*CreateOptions)
+
+// CreateOptions is the resolved set of CreateOption values, providers build it with NewCreateOptions.
+type CreateOptions struct {
+	// Location is the region or location of the bucket, empty is the default of the client.
+	Location string
+	// StorageClass is the class of the objects uploaded without one, empty is the default of the provider.
+	StorageClass StorageClass
+	// Encryption is the default encryption of the objects, empty is the default of the provider.
+	Encryption Encryption
+	// PublicRead grants anonymous read access to the objects, buckets are private otherwise.
+	PublicRead bool
+}
+
+func NewCreateOptions(opts ...CreateOption) CreateOptions {
+	var o CreateOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithLocation creates the bucket in the region or location, "eu-central-1" or "EU" for example.
+func WithLocation(location string) CreateOption {
+	return func(o *CreateOptions) {
+		o.Location = location
+	}
+}
+
+// WithDefaultStorageClass sets the storage class of the objects uploaded to the bucket without one.
+func WithDefaultStorageClass(class StorageClass) CreateOption {
+	return func(o *CreateOptions) {
+		o.StorageClass = class
+	}
+}
+
+// WithPublicRead lets anyone read the objects of the bucket without credentials, listing stays private.
+func WithPublicRead() CreateOption {
+	return func(o *CreateOptions) {
+		o.PublicRead = true
+	}
+}
+
+// Admin manages the buckets of an account or project. Providers implement it next to OpenBucket,
+// the mem server implements it as well.
+type Admin interface {
+	BucketExists(ctx context.Context, name string) (bool, error)
+	// CreateBucket returns ErrBucketExists when the name is taken.
+	CreateBucket(ctx context.Context, name string, opts ...CreateOption) error
+	// DeleteBucket deletes an empty bucket, ErrBucketNotFound is returned when it does not exist.
+	DeleteBucket(ctx context.Context, name string) error
+	// ListBuckets returns the sorted names of the buckets.
+	ListBuckets(ctx context.Context) ([]string, error)
+}
+
+// EnsureBucket checks that the bucket exists. A missing bucket is created when create is set, a bucket
+// created concurrently counts as created, otherwise ErrBucketNotFound is returned.
+func EnsureBucket(ctx context.Context, admin Admin, name string, create bool, opts ...CreateOption) error {
+	exists, err := admin.BucketExists(ctx, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if !create {
+		return ErrBucketNotFound{Bucket: name}
+	}
+	err = admin.CreateBucket(ctx, name, opts...)
+	if errors.As(err, &ErrBucketExists{}) {
+		return nil
+	}
+	return err
+}
@@ -8,11 +8,47 @@ import (
 
 type Bucket interface {
 	Delete(ctx context.Context, objName string) error
-	UploadBytes(ctx context.Context, fileAsBytes []byte, objName string) error
-	UploadByChunks(ctx context.Context, fileAsRead io.Reader, objName string) error
-	DownloadBytes(ctx context.Context, objName string) ([]byte, error)
-	DownloadByChunks(ctx context.Context, objName string) (io.ReadCloser, error)
+	DeleteMany(ctx context.Context, objNames []string) error
+	DeletePrefix(ctx context.Context, prefix string) error
+	UploadBytes(ctx context.Context, fileAsBytes []byte, objName string, opts ...Option) error
+	UploadByChunks(ctx context.Context, fileAsRead io.Reader, objName string, opts ...Option) error
+	// NewWriter uploads what is written to the Writer, the object is stored by Close. Errors of ctx or opts
+	// are returned by the writes and Close.
+	NewWriter(ctx context.Context, objName string, opts ...Option) Writer
+	DownloadBytes(ctx context.Context, objName string, opts ...Option) ([]byte, error)
+	DownloadByChunks(ctx context.Context, objName string, opts ...Option) (io.ReadCloser, error)
 	GenerateGetObjectSignedURL(ctx context.Context, objName string, ttl time.Time) (string, error)
+	List(ctx context.Context, prefix string) ([]ObjectAttrs, error)
+	// Stat takes the customer key of an object encrypted with one, S3 and Azure do not answer without it.
+	// Other options have no effect.
+	Stat(ctx context.Context, objName string, opts ...Option) (ObjectAttrs, error)
+	// ListVersions returns the versions of the object, newest first.
+	ListVersions(ctx context.Context, objName string) ([]ObjectVersion, error)
+	DownloadVersion(ctx context.Context, objName, versionID string, opts ...Option) (io.ReadCloser, error)
+	DeleteVersion(ctx context.Context, objName, versionID string) error
+	GetTags(ctx context.Context, objName string) (map[string]string, error)
+	// SetTags replaces the tags of the object. Uploading an object again drops its tags.
+	SetTags(ctx context.Context, objName string, tags map[string]string) error
+	// FindByTags returns the sorted names of the objects that have every tag of filter.
+	FindByTags(ctx context.Context, filter map[string]string) ([]string, error)
+}
+
+// ObjectAttrs describes a stored object as returned by List and Stat.
+// MD5 is empty when the provider does not report a content hash for the object,
+// ContentType and Encryption may be left empty by List.
+type ObjectAttrs struct {
+	Name        string
+	Size        int64
+	MD5         []byte
+	ETag        string
+	ContentType string
+	Updated     time.Time
+	// Encryption is reported by Stat without the customer key.
+	Encryption Encryption
+	// StorageClass is empty when the provider does not report it or has no equivalent class.
+	StorageClass StorageClass
+	// Rehydration is reported by Stat once the rehydration of an archived object was requested.
+	Rehydration *Rehydration
 }
 
 /*
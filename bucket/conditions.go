@@ -0,0 +1,65 @@
+package bucket
+
+import "time"
+
+// ETagAny matches every existing object, IfNoneMatch(ETagAny) makes an upload create-only.
+const ETagAny = "*"
+
+// ErrPreconditionFailed is returned when the object does not satisfy the IfMatch, IfNoneMatch
+// or IfModifiedSince option of the request. Nothing is written or read in that case.
+type ErrPreconditionFailed struct{}
+
+func (e ErrPreconditionFailed) Error() string {
+	return "precondition failed"
+}
+
+// IfMatch makes the request fail unless the object exists and has the etag, as reported by Stat and List.
+// Uploading with IfMatch is a compare-and-swap of the object.
+func IfMatch(etag string) Option {
+	return func(o *Options) {
+		o.IfMatch = etag
+	}
+}
+
+// IfNoneMatch makes the request fail when the object has the etag, or exists at all for ETagAny.
+func IfNoneMatch(etag string) Option {
+	return func(o *Options) {
+		o.IfNoneMatch = etag
+	}
+}
+
+// IfModifiedSince makes a download fail unless the object was updated after t. Uploads ignore it.
+func IfModifiedSince(t time.Time) Option {
+	return func(o *Options) {
+		o.IfModifiedSince = t
+	}
+}
+
+// Conditional reports whether any precondition is set.
+func (o Options) Conditional() bool {
+	return o.IfMatch != "" || o.IfNoneMatch != "" || !o.IfModifiedSince.IsZero()
+}
+
+// CheckUpload evaluates IfMatch and IfNoneMatch against attrs of the current object, nil when it does not exist.
+// It is meant for providers that can not send the preconditions along with the request.
+func (o Options) CheckUpload(attrs *ObjectAttrs) error {
+	if o.IfMatch != "" && (attrs == nil || o.IfMatch != ETagAny && o.IfMatch != attrs.ETag) {
+		return ErrPreconditionFailed{}
+	}
+	if attrs != nil && (o.IfNoneMatch == ETagAny || o.IfNoneMatch != "" && o.IfNoneMatch == attrs.ETag) {
+		return ErrPreconditionFailed{}
+	}
+	return nil
+}
+
+// CheckDownload is CheckUpload that also evaluates IfModifiedSince. A missing object only fails IfMatch,
+// the download reports it as usual otherwise.
+func (o Options) CheckDownload(attrs *ObjectAttrs) error {
+	if err := o.CheckUpload(attrs); err != nil {
+		return err
+	}
+	if attrs != nil && !o.IfModifiedSince.IsZero() && !attrs.Updated.After(o.IfModifiedSince) {
+		return ErrPreconditionFailed{}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package bucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestConditions(t *testing.T) {
+	suite.Run(t, new(ConditionsSuite))
+}
+
+type ConditionsSuite struct {
+	suite.Suite
+	attrs ObjectAttrs
+}
+
+func (s *ConditionsSuite) SetupTest() {
+	s.attrs = ObjectAttrs{Name: "name", ETag: `"1"`, Updated: time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (s *ConditionsSuite) TestCheckUpload() {
+	tests := map[string]struct {
+		opts    []Option
+		attrs   *ObjectAttrs
+		wantErr bool
+	}{
+		"no_conditions":             {attrs: &s.attrs},
+		"if_none_match_any_new":     {opts: []Option{IfNoneMatch(ETagAny)}},
+		"if_none_match_any":         {opts: []Option{IfNoneMatch(ETagAny)}, attrs: &s.attrs, wantErr: true},
+		"if_none_match_other":       {opts: []Option{IfNoneMatch(`"2"`)}, attrs: &s.attrs},
+		"if_none_match_same":        {opts: []Option{IfNoneMatch(`"1"`)}, attrs: &s.attrs, wantErr: true},
+		"if_match_same":             {opts: []Option{IfMatch(`"1"`)}, attrs: &s.attrs},
+		"if_match_other":            {opts: []Option{IfMatch(`"2"`)}, attrs: &s.attrs, wantErr: true},
+		"if_match_missing":          {opts: []Option{IfMatch(`"1"`)}, wantErr: true},
+		"if_match_any":              {opts: []Option{IfMatch(ETagAny)}, attrs: &s.attrs},
+		"if_modified_since_ignored": {opts: []Option{IfModifiedSince(s.attrs.Updated)}, attrs: &s.attrs},
+	}
+	for name, test := range tests {
+		s.Run(name, func() {
+			err := NewOptions(test.opts...).CheckUpload(test.attrs)
+			if test.wantErr {
+				s.ErrorIs(err, ErrPreconditionFailed{})
+			} else {
+				s.NoError(err)
+			}
+		})
+	}
+}
+
+func (s *ConditionsSuite) TestCheckDownload() {
+	s.ErrorIs(NewOptions(IfModifiedSince(s.attrs.Updated)).CheckDownload(&s.attrs), ErrPreconditionFailed{})
+	s.NoError(NewOptions(IfModifiedSince(s.attrs.Updated.Add(-time.Second))).CheckDownload(&s.attrs))
+	s.NoError(NewOptions(IfModifiedSince(s.attrs.Updated)).CheckDownload(nil))
+	s.ErrorIs(NewOptions(IfNoneMatch(`"1"`)).CheckDownload(&s.attrs), ErrPreconditionFailed{})
+}
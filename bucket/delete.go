@@ -0,0 +1,71 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DeleteConcurrency bounds the parallel deletes of DeleteEach.
+const DeleteConcurrency = 16
+
+// ErrDeleteFailed is returned by DeleteMany and DeletePrefix when some objects could not be deleted.
+// The other objects are deleted regardless.
+type ErrDeleteFailed struct {
+	Errors map[string]error
+}
+
+func (e ErrDeleteFailed) Error() string {
+	names := make([]string, 0, len(e.Errors))
+	for name := range e.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fmt.Sprintf("%s: %v", name, e.Errors[name])
+	}
+	return fmt.Sprintf("failed to delete %d objects: %s", len(names), strings.Join(msgs, "; "))
+}
+
+// DeleteEach calls del for every name with at most DeleteConcurrency calls running at once,
+// for providers without a batch delete. Failures are collected into ErrDeleteFailed.
+func DeleteEach(ctx context.Context, objNames []string, del func(ctx context.Context, objName string) error) error {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs = make(map[string]error)
+		sem  = make(chan struct{}, DeleteConcurrency)
+	)
+	for _, name := range objNames {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := del(ctx, name); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return ErrDeleteFailed{Errors: errs}
+	}
+	return nil
+}
+
+// Names returns the names of the listed objects.
+func Names(objects []ObjectAttrs) []string {
+	names := make([]string, len(objects))
+	for i, o := range objects {
+		names[i] = o.Name
+	}
+	return names
+}
@@ -0,0 +1,93 @@
+package bucket
+
+import "fmt"
+
+// EncryptionType is the kind of key an object is encrypted with at rest.
+type EncryptionType string
+
+const (
+	// EncryptionProviderKey is a key managed by the provider: S3 SSE-S3, the Google and Microsoft managed keys.
+	EncryptionProviderKey EncryptionType = "provider"
+	// EncryptionKMS is a key of the key management service of the provider: S3 SSE-KMS, GCS CMEK,
+	// Azure encryption scopes.
+	EncryptionKMS EncryptionType = "kms"
+	// EncryptionCustomerKey is an AES-256 key sent along with every request: S3 SSE-C, GCS CSEK,
+	// Azure customer-provided keys.
+	EncryptionCustomerKey EncryptionType = "customer"
+)
+
+// customerKeySize is the size of an AES-256 key.
+const customerKeySize = 32
+
+// Encryption is the server-side encryption of an object, or the default of a bucket. The zero value leaves
+// the choice to the provider or the bucket.
+type Encryption struct {
+	Type EncryptionType
+	// KMSKeyID is the key of EncryptionKMS: the key ID or ARN for S3, where empty is the default KMS key,
+	// "projects/p/locations/l/keyRings/r/cryptoKeys/k" for GCS and the encryption scope for Azure.
+	KMSKeyID string
+	// CustomerKey is the key of EncryptionCustomerKey, downloads of the object need it again.
+	// Stat does not report it.
+	CustomerKey []byte
+}
+
+// ErrUnsupportedEncryption is returned when the provider can not apply the encryption for the operation.
+type ErrUnsupportedEncryption struct {
+	Type   EncryptionType
+	Reason string
+}
+
+func (e ErrUnsupportedEncryption) Error() string {
+	return fmt.Sprintf("unsupported encryption %q: %s", e.Type, e.Reason)
+}
+
+// Validate checks that the encryption has the key its type needs and no other.
+func (e Encryption) Validate() error {
+	switch e.Type {
+	case "":
+		if e.KMSKeyID != "" || e.CustomerKey != nil {
+			return ErrUnsupportedEncryption{Type: e.Type, Reason: "key without encryption type"}
+		}
+	case EncryptionProviderKey:
+		if e.KMSKeyID != "" || e.CustomerKey != nil {
+			return ErrUnsupportedEncryption{Type: e.Type, Reason: "provider keys can not be chosen"}
+		}
+	case EncryptionKMS:
+		if e.CustomerKey != nil {
+			return ErrUnsupportedEncryption{Type: e.Type, Reason: "customer key with KMS encryption"}
+		}
+	case EncryptionCustomerKey:
+		if len(e.CustomerKey) != customerKeySize {
+			return ErrUnsupportedEncryption{Type: e.Type, Reason: fmt.Sprintf("customer key has %d bytes, not %d", len(e.CustomerKey), customerKeySize)}
+		}
+	default:
+		return ErrUnsupportedEncryption{Type: e.Type, Reason: "unknown encryption type"}
+	}
+	return nil
+}
+
+// WithEncryption encrypts the uploaded object. Downloads use the customer key only, the other types are
+// decrypted by the provider.
+func WithEncryption(e Encryption) Option {
+	return func(o *Options) {
+		o.Encryption = e
+	}
+}
+
+// WithKMSKey encrypts the uploaded object with the KMS key, see Encryption.KMSKeyID.
+func WithKMSKey(keyID string) Option {
+	return WithEncryption(Encryption{Type: EncryptionKMS, KMSKeyID: keyID})
+}
+
+// WithCustomerKey encrypts the uploaded object with the AES-256 key, or decrypts the downloaded one.
+func WithCustomerKey(key []byte) Option {
+	return WithEncryption(Encryption{Type: EncryptionCustomerKey, CustomerKey: key})
+}
+
+// WithDefaultEncryption sets the encryption of the objects uploaded to the bucket without one. Customer keys
+// can not be a default.
+func WithDefaultEncryption(e Encryption) CreateOption {
+	return func(o *CreateOptions) {
+		o.Encryption = e
+	}
+}
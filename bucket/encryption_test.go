@@ -0,0 +1,53 @@
+package bucket
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestEncryption(t *testing.T) {
+	suite.Run(t, new(EncryptionSuite))
+}
+
+type EncryptionSuite struct {
+	suite.Suite
+}
+
+func (s *EncryptionSuite) TestValidate() {
+	key := bytes.Repeat([]byte{1}, customerKeySize)
+	tests := map[string]struct {
+		enc     Encryption
+		wantErr bool
+	}{
+		"none":                    {},
+		"key_without_type":        {enc: Encryption{KMSKeyID: "k"}, wantErr: true},
+		"provider":                {enc: Encryption{Type: EncryptionProviderKey}},
+		"provider_with_key":       {enc: Encryption{Type: EncryptionProviderKey, CustomerKey: key}, wantErr: true},
+		"kms":                     {enc: Encryption{Type: EncryptionKMS, KMSKeyID: "k"}},
+		"kms_default_key":         {enc: Encryption{Type: EncryptionKMS}},
+		"kms_with_customer_key":   {enc: Encryption{Type: EncryptionKMS, CustomerKey: key}, wantErr: true},
+		"customer":                {enc: Encryption{Type: EncryptionCustomerKey, CustomerKey: key}},
+		"customer_short_key":      {enc: Encryption{Type: EncryptionCustomerKey, CustomerKey: key[:16]}, wantErr: true},
+		"customer_without_key":    {enc: Encryption{Type: EncryptionCustomerKey}, wantErr: true},
+		"unknown_encryption_type": {enc: Encryption{Type: "rot13"}, wantErr: true},
+	}
+	for name, test := range tests {
+		s.Run(name, func() {
+			err := test.enc.Validate()
+			if test.wantErr {
+				s.ErrorAs(err, &ErrUnsupportedEncryption{})
+			} else {
+				s.NoError(err)
+			}
+		})
+	}
+}
+
+func (s *EncryptionSuite) TestOptions() {
+	key := bytes.Repeat([]byte{1}, customerKeySize)
+	s.Equal(Encryption{Type: EncryptionKMS, KMSKeyID: "k"}, NewOptions(WithKMSKey("k")).Encryption)
+	s.Equal(Encryption{Type: EncryptionCustomerKey, CustomerKey: key}, NewOptions(WithCustomerKey(key)).Encryption)
+	s.Equal(Encryption{Type: EncryptionProviderKey}, NewCreateOptions(WithDefaultEncryption(Encryption{Type: EncryptionProviderKey})).Encryption)
+}
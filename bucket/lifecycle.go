@@ -0,0 +1,77 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+)
+
+// StorageClass is a provider neutral access tier. Providers map it to their closest class:
+// S3 STANDARD, STANDARD_IA, GLACIER and DEEP_ARCHIVE, GCS STANDARD, NEARLINE, COLDLINE and ARCHIVE,
+// Azure Hot, Cool and Archive.
+type StorageClass string
+
+const (
+	StorageClassHot     StorageClass = "hot"
+	StorageClassCool    StorageClass = "cool"
+	StorageClassCold    StorageClass = "cold"
+	StorageClassArchive StorageClass = "archive"
+)
+
+// ErrUnsupportedStorageClass is returned when the provider has no equivalent of the class for the operation.
+type ErrUnsupportedStorageClass struct {
+	Class StorageClass
+}
+
+func (e ErrUnsupportedStorageClass) Error() string {
+	return fmt.Sprintf("unsupported storage class %q", e.Class)
+}
+
+// LifecycleRule applies to the objects whose name starts with Prefix, an empty prefix matches every object.
+// The objects are deleted ExpireAfterDays days after they were last written and moved to
+// TransitionStorageClass after TransitionAfterDays days. A zero number of days disables the action.
+type LifecycleRule struct {
+	ID                     string
+	Prefix                 string
+	ExpireAfterDays        int
+	TransitionAfterDays    int
+	TransitionStorageClass StorageClass
+}
+
+// ErrInvalidLifecycleRule is returned by SetLifecycle for a rule without actions or with inconsistent ones.
+type ErrInvalidLifecycleRule struct {
+	ID     string
+	Reason string
+}
+
+func (e ErrInvalidLifecycleRule) Error() string {
+	return fmt.Sprintf("invalid lifecycle rule %q: %s", e.ID, e.Reason)
+}
+
+// Lifecycler is implemented by the buckets that can configure lifecycle rules. The rules are a bucket
+// setting enforced by the provider in the background, usually about once a day.
+type Lifecycler interface {
+	GetLifecycle(ctx context.Context) ([]LifecycleRule, error)
+	// SetLifecycle replaces the rules of the bucket, no rules removes the configuration.
+	SetLifecycle(ctx context.Context, rules []LifecycleRule) error
+}
+
+// ValidateLifecycle checks that every rule has a unique ID and at least one action.
+func ValidateLifecycle(rules []LifecycleRule) error {
+	ids := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		switch {
+		case r.ID == "":
+			return ErrInvalidLifecycleRule{ID: r.ID, Reason: "missing ID"}
+		case ids[r.ID]:
+			return ErrInvalidLifecycleRule{ID: r.ID, Reason: "duplicate ID"}
+		case r.ExpireAfterDays < 0 || r.TransitionAfterDays < 0:
+			return ErrInvalidLifecycleRule{ID: r.ID, Reason: "negative number of days"}
+		case r.ExpireAfterDays == 0 && r.TransitionAfterDays == 0:
+			return ErrInvalidLifecycleRule{ID: r.ID, Reason: "no action"}
+		case r.TransitionAfterDays > 0 && r.TransitionStorageClass == "":
+			return ErrInvalidLifecycleRule{ID: r.ID, Reason: "transition without storage class"}
+		}
+		ids[r.ID] = true
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package bucket
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestLifecycle(t *testing.T) {
+	suite.Run(t, new(LifecycleSuite))
+}
+
+type LifecycleSuite struct {
+	suite.Suite
+}
+
+func (s *LifecycleSuite) TestValidateLifecycle() {
+	s.NoError(ValidateLifecycle(nil))
+	s.NoError(ValidateLifecycle([]LifecycleRule{
+		{ID: "tmp", Prefix: "tmp/", ExpireAfterDays: 7},
+		{ID: "logs", TransitionAfterDays: 30, TransitionStorageClass: StorageClassArchive},
+	}))
+
+	tests := map[string]struct {
+		rules  []LifecycleRule
+		reason string
+	}{
+		"missing ID":    {rules: []LifecycleRule{{ExpireAfterDays: 1}}, reason: "missing ID"},
+		"duplicate ID":  {rules: []LifecycleRule{{ID: "a", ExpireAfterDays: 1}, {ID: "a", ExpireAfterDays: 2}}, reason: "duplicate ID"},
+		"negative days": {rules: []LifecycleRule{{ID: "a", ExpireAfterDays: -1}}, reason: "negative number of days"},
+		"no action":     {rules: []LifecycleRule{{ID: "a", Prefix: "tmp/"}}, reason: "no action"},
+		"no class":      {rules: []LifecycleRule{{ID: "a", TransitionAfterDays: 1}}, reason: "transition without storage class"},
+	}
+	for name, test := range tests {
+		s.Run(name, func() {
+			err := ValidateLifecycle(test.rules)
+			s.IsType(ErrInvalidLifecycleRule{}, err)
+			s.Equal(test.reason, err.(ErrInvalidLifecycleRule).Reason)
+		})
+	}
+}
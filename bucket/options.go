@@ -0,0 +1,56 @@
+package bucket
+
+import (
+	"fmt"
+	"time"
+)
+
+// Option configures a single upload or download.
+type Option func(*Options)
+
+// Options is the resolved set of Option values, providers build it with NewOptions.
+type Options struct {
+	Progress ProgressFunc
+
+	IfMatch         string
+	IfNoneMatch     string
+	IfModifiedSince time.Time
+
+	TTL time.Duration
+
+	Encryption Encryption
+
+	StorageClass StorageClass
+}
+
+func NewOptions(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithProgress calls fn after every chunk of the object is transferred.
+func WithProgress(fn ProgressFunc) Option {
+	return func(o *Options) {
+		o.Progress = fn
+	}
+}
+
+// WithTTL makes the uploaded object expire after ttl. Only mem supports it, the uploads of the other providers
+// fail with ErrUnsupportedTTL, use lifecycle rules there.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *Options) {
+		o.TTL = ttl
+	}
+}
+
+// ErrUnsupportedTTL is returned by the uploads of the providers that can not expire single objects.
+type ErrUnsupportedTTL struct {
+	TTL time.Duration
+}
+
+func (e ErrUnsupportedTTL) Error() string {
+	return fmt.Sprintf("unsupported object TTL %v, use lifecycle rules", e.TTL)
+}
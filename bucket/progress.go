@@ -0,0 +1,97 @@
+package bucket
+
+import (
+	"io"
+	"time"
+)
+
+// Progress describes the state of a transfer.
+type Progress struct {
+	Transferred int64
+	// Total is the size of the object, -1 when it is not known.
+	Total int64
+	// Rate is the average number of bytes per second since the transfer started.
+	Rate float64
+}
+
+type ProgressFunc func(Progress)
+
+// ProgressReader returns r reporting its reads to the progress callback, or r itself when no callback is set.
+// A negative total is taken from r when it reports its length, as bytes.Reader and strings.Reader do.
+// The returned reader implements io.Seeker when r does, seeking moves the reported position.
+func (o Options) ProgressReader(r io.Reader, total int64) io.Reader {
+	if o.Progress == nil {
+		return r
+	}
+	p := newProgressReader(r, total, o.Progress)
+	if s, ok := r.(io.Seeker); ok {
+		return &progressReadSeeker{progressReader: p, s: s}
+	}
+	return p
+}
+
+// ProgressReadCloser is ProgressReader for streams that have to be closed.
+func (o Options) ProgressReadCloser(rc io.ReadCloser, total int64) io.ReadCloser {
+	if o.Progress == nil {
+		return rc
+	}
+	return &progressReadCloser{progressReader: newProgressReader(rc, total, o.Progress), c: rc}
+}
+
+type progressReader struct {
+	r           io.Reader
+	fn          ProgressFunc
+	total       int64
+	transferred int64
+	started     time.Time
+}
+
+func newProgressReader(r io.Reader, total int64, fn ProgressFunc) *progressReader {
+	if l, ok := r.(interface{ Len() int }); ok && total < 0 {
+		total = int64(l.Len())
+	}
+	if total < 0 {
+		total = -1
+	}
+	return &progressReader{r: r, fn: fn, total: total, started: time.Now()}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.transferred += int64(n)
+		p.report()
+	}
+	return n, err
+}
+
+func (p *progressReader) report() {
+	var rate float64
+	if elapsed := time.Since(p.started).Seconds(); elapsed > 0 {
+		rate = float64(p.transferred) / elapsed
+	}
+	p.fn(Progress{Transferred: p.transferred, Total: p.total, Rate: rate})
+}
+
+type progressReadSeeker struct {
+	*progressReader
+	s io.Seeker
+}
+
+// Seek keeps the count right when an SDK rewinds the body to retry or to compute a checksum.
+func (p *progressReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	pos, err := p.s.Seek(offset, whence)
+	if err == nil {
+		p.transferred = pos
+	}
+	return pos, err
+}
+
+type progressReadCloser struct {
+	*progressReader
+	c io.Closer
+}
+
+func (p *progressReadCloser) Close() error {
+	return p.c.Close()
+}
@@ -0,0 +1,81 @@
+package bucket
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestService(t *testing.T) {
+	suite.Run(t, new(Suite))
+}
+
+type Suite struct {
+	suite.Suite
+	reports []Progress
+	opts    Options
+}
+
+func (s *Suite) SetupTest() {
+	s.reports = nil
+	s.opts = NewOptions(WithProgress(func(p Progress) {
+		s.reports = append(s.reports, p)
+	}))
+}
+
+func (s *Suite) TestWithoutProgress() {
+	r := strings.NewReader("abc")
+	s.Equal(io.Reader(r), NewOptions().ProgressReader(r, -1))
+}
+
+func (s *Suite) TestProgressReader() {
+	r := s.opts.ProgressReader(io.LimitReader(strings.NewReader("abcdef"), 6), 6)
+	buf := make([]byte, 4)
+
+	_, err := r.Read(buf)
+	s.NoError(err)
+	_, err = r.Read(buf)
+	s.NoError(err)
+	s.Require().Len(s.reports, 2)
+	s.Equal(int64(4), s.reports[0].Transferred)
+	s.Equal(int64(6), s.reports[1].Transferred)
+	s.Equal(int64(6), s.reports[1].Total)
+}
+
+func (s *Suite) TestUnknownTotal() {
+	r := s.opts.ProgressReader(io.LimitReader(strings.NewReader("abc"), 3), -1)
+
+	_, err := io.ReadAll(r)
+	s.NoError(err)
+	s.Equal(int64(-1), s.reports[len(s.reports)-1].Total)
+}
+
+func (s *Suite) TestSeekerKeepsCount() {
+	r := s.opts.ProgressReader(bytes.NewReader([]byte("abcdef")), -1)
+	seeker, ok := r.(io.ReadSeeker)
+	s.Require().True(ok)
+
+	_, err := io.ReadAll(seeker)
+	s.NoError(err)
+	_, err = seeker.Seek(0, io.SeekStart)
+	s.NoError(err)
+	_, err = io.ReadAll(seeker)
+	s.NoError(err)
+
+	last := s.reports[len(s.reports)-1]
+	s.Equal(int64(6), last.Transferred)
+	s.Equal(int64(6), last.Total)
+}
+
+func (s *Suite) TestProgressReadCloser() {
+	rc := s.opts.ProgressReadCloser(io.NopCloser(strings.NewReader("abc")), 3)
+
+	data, err := io.ReadAll(rc)
+	s.NoError(err)
+	s.Equal("abc", string(data))
+	s.NoError(rc.Close())
+	s.Equal(Progress{Transferred: 3, Total: 3, Rate: s.reports[0].Rate}, s.reports[0])
+}
@@ -0,0 +1,42 @@
+package bucket
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WithStorageClass uploads the object to the class instead of the default of the bucket.
+func WithStorageClass(class StorageClass) Option {
+	return func(o *Options) {
+		o.StorageClass = class
+	}
+}
+
+// Rehydration is the state of an archived object that is made readable again.
+type Rehydration struct {
+	// Pending is set until the object can be read.
+	Pending bool
+	// Class is the class the object moves to, empty when the provider keeps the object archived and serves
+	// a temporary copy as S3 does.
+	Class StorageClass
+	// Expires is when the temporary copy is deleted, zero when there is none.
+	Expires time.Time
+}
+
+// ErrRehydrationPending is returned by SetStorageClass when the archived object has to be rehydrated before
+// its class can change. The rehydration has been requested, Stat reports when it is done and SetStorageClass
+// can be called again.
+type ErrRehydrationPending struct {
+	Name string
+}
+
+func (e ErrRehydrationPending) Error() string {
+	return fmt.Sprintf("object %q is being rehydrated from the archive", e.Name)
+}
+
+// StorageClassSetter is implemented by the buckets that can move stored objects to another class.
+type StorageClassSetter interface {
+	// SetStorageClass moves the object to the class, archived objects are rehydrated first.
+	SetStorageClass(ctx context.Context, objName string, class StorageClass) error
+}
@@ -0,0 +1,57 @@
+package bucket
+
+import (
+	"io"
+	"sync"
+)
+
+// ErrStreamClosed is returned by reads of a download stream after Close.
+type ErrStreamClosed struct{}
+
+func (e ErrStreamClosed) Error() string {
+	return "read from closed stream"
+}
+
+// Stream returns rc as the body of a download that owns the resources the provider keeps open for it.
+// Closing the stream closes rc and then release, once however often it is called. A stream that ends before
+// size bytes were read fails with io.ErrUnexpectedEOF instead of io.EOF, a negative size is not checked.
+// release may be nil.
+func Stream(rc io.ReadCloser, size int64, release io.Closer) io.ReadCloser {
+	return &stream{rc: rc, size: size, release: release}
+}
+
+type stream struct {
+	rc      io.ReadCloser
+	size    int64
+	read    int64
+	release io.Closer
+
+	once   sync.Once
+	closed bool
+	err    error
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	if s.closed {
+		return 0, ErrStreamClosed{}
+	}
+	n, err := s.rc.Read(p)
+	s.read += int64(n)
+	if err == io.EOF && s.size >= 0 && s.read < s.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (s *stream) Close() error {
+	s.once.Do(func() {
+		s.closed = true
+		s.err = s.rc.Close()
+		if s.release != nil {
+			if err := s.release.Close(); s.err == nil {
+				s.err = err
+			}
+		}
+	})
+	return s.err
+}
@@ -0,0 +1,61 @@
+package bucket
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestStream(t *testing.T) {
+	suite.Run(t, new(StreamSuite))
+}
+
+type StreamSuite struct {
+	suite.Suite
+}
+
+type countingCloser struct {
+	closed int
+	err    error
+}
+
+func (c *countingCloser) Close() error {
+	c.closed++
+	return c.err
+}
+
+func (s *StreamSuite) TestRelease() {
+	body := &countingCloser{}
+	release := &countingCloser{err: errors.New("release")}
+	rc := Stream(struct {
+		io.Reader
+		io.Closer
+	}{strings.NewReader("abc"), body}, 3, release)
+
+	data, err := io.ReadAll(rc)
+	s.NoError(err)
+	s.Equal("abc", string(data))
+	s.Zero(release.closed)
+
+	s.EqualError(rc.Close(), "release")
+	s.EqualError(rc.Close(), "release")
+	s.Equal(1, body.closed)
+	s.Equal(1, release.closed)
+
+	_, err = rc.Read(make([]byte, 1))
+	s.ErrorIs(err, ErrStreamClosed{})
+}
+
+func (s *StreamSuite) TestTruncated() {
+	rc := Stream(io.NopCloser(strings.NewReader("abc")), 5, nil)
+	_, err := io.ReadAll(rc)
+	s.ErrorIs(err, io.ErrUnexpectedEOF)
+	s.NoError(rc.Close())
+
+	rc = Stream(io.NopCloser(strings.NewReader("abc")), -1, nil)
+	_, err = io.ReadAll(rc)
+	s.NoError(err)
+}
@@ -0,0 +1,74 @@
+package bucket
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// TagsConcurrency bounds the parallel tag requests of FilterByTags.
+const TagsConcurrency = 16
+
+// MatchTags reports whether tags have every key of filter with the same value.
+func MatchTags(tags, filter map[string]string) bool {
+	for k, v := range filter {
+		if got, ok := tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterByTags is the list-and-filter fallback of FindByTags for providers without a tag query.
+// It fetches the tags of up to TagsConcurrency objects at once and returns the sorted names that match filter,
+// or the first error.
+func FilterByTags(ctx context.Context, objNames []string, filter map[string]string,
+	getTags func(ctx context.Context, objName string) (map[string]string, error)) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		matched  []string
+		firstErr error
+		sem      = make(chan struct{}, TagsConcurrency)
+	)
+	for _, name := range objNames {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			tags, err := getTags(ctx, name)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+			case MatchTags(tags, filter):
+				matched = append(matched, name)
+			}
+		}(name)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(matched)
+	return matched, nil
+}
@@ -0,0 +1,55 @@
+package bucket
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTags(t *testing.T) {
+	suite.Run(t, new(TagsSuite))
+}
+
+type TagsSuite struct {
+	suite.Suite
+	tags map[string]map[string]string
+}
+
+func (s *TagsSuite) SetupTest() {
+	s.tags = map[string]map[string]string{
+		"a": {"tenant": "acme", "class": "gold"},
+		"b": {"tenant": "acme"},
+		"c": {"tenant": "other", "class": "gold"},
+		"d": nil,
+	}
+}
+
+func (s *TagsSuite) getTags(_ context.Context, objName string) (map[string]string, error) {
+	tags, ok := s.tags[objName]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return tags, nil
+}
+
+func (s *TagsSuite) TestFilterByTags() {
+	ctx := context.Background()
+	names := []string{"d", "c", "b", "a"}
+
+	got, err := FilterByTags(ctx, names, map[string]string{"tenant": "acme"}, s.getTags)
+	s.NoError(err)
+	s.Equal([]string{"a", "b"}, got)
+
+	got, err = FilterByTags(ctx, names, map[string]string{"class": "gold", "tenant": "other"}, s.getTags)
+	s.NoError(err)
+	s.Equal([]string{"c"}, got)
+
+	got, err = FilterByTags(ctx, names, nil, s.getTags)
+	s.NoError(err)
+	s.Equal([]string{"a", "b", "c", "d"}, got)
+
+	_, err = FilterByTags(ctx, append(names, "missing"), map[string]string{"tenant": "acme"}, s.getTags)
+	s.EqualError(err, "not found")
+}
@@ -0,0 +1,22 @@
+package bucket
+
+import "context"
+
+// ObjectVersion is a stored version of an object. VersionID is the S3 version ID, the GCS generation
+// or the Azure blob version, the current version has IsLatest set.
+type ObjectVersion struct {
+	ObjectAttrs
+	VersionID string
+	IsLatest  bool
+}
+
+// RestoreVersion makes the version the current content of the object by uploading it again,
+// the replaced content stays available as a version.
+func RestoreVersion(ctx context.Context, b Bucket, objName, versionID string, opts ...Option) error {
+	rc, err := b.DownloadVersion(ctx, objName, versionID)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return b.UploadByChunks(ctx, rc, objName, opts...)
+}
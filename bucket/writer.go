@@ -0,0 +1,101 @@
+package bucket
+
+import (
+	"io"
+	"time"
+)
+
+// ErrWriterClosed is returned by writes to a Writer after Close.
+type ErrWriterClosed struct{}
+
+func (e ErrWriterClosed) Error() string {
+	return "write to closed writer"
+}
+
+// Writer is the object being uploaded by NewWriter of a bucket. Close commits the object and returns the error
+// of the upload, nothing is stored before. CloseWithError discards the written data instead. A Writer is not
+// safe for concurrent use.
+type Writer interface {
+	io.WriteCloser
+	// CloseWithError aborts the upload, the object keeps its previous content. Later writes and Close return err,
+	// or ErrWriterClosed when err is nil. It has no effect after Close and returns the error of the abort.
+	CloseWithError(err error) error
+}
+
+// Upload is an upload in progress, providers turn it into a Writer with NewUploadWriter.
+type Upload interface {
+	// Write sends or buffers p, a failed write ends the upload with Abort.
+	Write(p []byte) (int, error)
+	// Commit stores the object from the written data.
+	Commit() error
+	// Abort discards the written data, it is called instead of Commit.
+	Abort() error
+}
+
+// NewUploadWriter returns u as a Writer that reports its writes to the progress callback of o and calls
+// Commit or Abort once. The total of the progress is not known.
+func NewUploadWriter(u Upload, o Options) Writer {
+	w := &uploadWriter{u: u}
+	if o.Progress != nil {
+		w.progress = &progressReader{fn: o.Progress, total: -1, started: time.Now()}
+	}
+	return w
+}
+
+// FailedWriter returns a Writer for an upload that could not start, its writes and Close return err.
+func FailedWriter(err error) Writer {
+	return &uploadWriter{err: err, done: true}
+}
+
+type uploadWriter struct {
+	u        Upload
+	progress *progressReader
+
+	// err is the error of a failed write, or of Close or CloseWithError once done is set.
+	err  error
+	done bool
+}
+
+func (w *uploadWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.done {
+		return 0, ErrWriterClosed{}
+	}
+	n, err := w.u.Write(p)
+	if n > 0 && w.progress != nil {
+		w.progress.transferred += int64(n)
+		w.progress.report()
+	}
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Close commits the object unless a write failed, the upload is aborted and the error of the write returned then.
+func (w *uploadWriter) Close() error {
+	if w.done {
+		return w.err
+	}
+	w.done = true
+	if w.err != nil {
+		_ = w.u.Abort()
+		return w.err
+	}
+	w.err = w.u.Commit()
+	return w.err
+}
+
+func (w *uploadWriter) CloseWithError(err error) error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if err == nil {
+		err = ErrWriterClosed{}
+	}
+	w.err = err
+	return w.u.Abort()
+}
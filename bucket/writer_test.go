@@ -0,0 +1,109 @@
+package bucket
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWriter(t *testing.T) {
+	suite.Run(t, new(WriterSuite))
+}
+
+type WriterSuite struct {
+	suite.Suite
+}
+
+// recordingUpload keeps the written data and counts the calls of Commit and Abort.
+type recordingUpload struct {
+	bytes.Buffer
+	writeErr  error
+	commits   int
+	aborts    int
+	commitErr error
+}
+
+func (u *recordingUpload) Write(p []byte) (int, error) {
+	if u.writeErr != nil {
+		return 0, u.writeErr
+	}
+	return u.Buffer.Write(p)
+}
+
+func (u *recordingUpload) Commit() error {
+	u.commits++
+	return u.commitErr
+}
+
+func (u *recordingUpload) Abort() error {
+	u.aborts++
+	return nil
+}
+
+func (s *WriterSuite) TestClose() {
+	u := &recordingUpload{}
+	var reported []Progress
+	w := NewUploadWriter(u, NewOptions(WithProgress(func(p Progress) { reported = append(reported, p) })))
+
+	_, err := w.Write([]byte("ab"))
+	s.NoError(err)
+	_, err = w.Write([]byte("c"))
+	s.NoError(err)
+	s.NoError(w.Close())
+	s.NoError(w.Close())
+	s.NoError(w.CloseWithError(errors.New("late")))
+	s.Equal("abc", u.String())
+	s.Equal(1, u.commits)
+	s.Zero(u.aborts)
+	s.Len(reported, 2)
+	s.Equal(int64(3), reported[1].Transferred)
+	s.Equal(int64(-1), reported[1].Total)
+
+	_, err = w.Write([]byte("d"))
+	s.ErrorIs(err, ErrWriterClosed{})
+
+	u = &recordingUpload{commitErr: ErrPreconditionFailed{}}
+	w = NewUploadWriter(u, Options{})
+	s.ErrorIs(w.Close(), ErrPreconditionFailed{})
+	s.ErrorIs(w.Close(), ErrPreconditionFailed{})
+	s.Equal(1, u.commits)
+}
+
+func (s *WriterSuite) TestCloseWithError() {
+	u := &recordingUpload{}
+	w := NewUploadWriter(u, Options{})
+	_, err := w.Write([]byte("abc"))
+	s.NoError(err)
+	e := errors.New("encoder failed")
+	s.NoError(w.CloseWithError(e))
+	s.Equal(e, w.Close())
+	_, err = w.Write([]byte("d"))
+	s.Equal(e, err)
+	s.Zero(u.commits)
+	s.Equal(1, u.aborts)
+
+	w = NewUploadWriter(&recordingUpload{}, Options{})
+	s.NoError(w.CloseWithError(nil))
+	s.ErrorIs(w.Close(), ErrWriterClosed{})
+}
+
+func (s *WriterSuite) TestFailedWrite() {
+	e := errors.New("part upload failed")
+	u := &recordingUpload{writeErr: e}
+	w := NewUploadWriter(u, Options{})
+	_, err := w.Write([]byte("abc"))
+	s.Equal(e, err)
+	_, err = w.Write([]byte("abc"))
+	s.Equal(e, err)
+	s.Equal(e, w.Close())
+	s.Zero(u.commits)
+	s.Equal(1, u.aborts)
+
+	w = FailedWriter(e)
+	_, err = w.Write([]byte("abc"))
+	s.Equal(e, err)
+	s.Equal(e, w.Close())
+	s.NoError(w.CloseWithError(nil))
+}
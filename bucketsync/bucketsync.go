@@ -0,0 +1,215 @@
+package bucketsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const defaultConcurrency = 4
+
+type Op string
+
+const (
+	OpCopy   Op = "copy"
+	OpDelete Op = "delete"
+)
+
+// Action is a single change needed to make the target match the source.
+// Key is the object name in the target bucket.
+type Action struct {
+	Op   Op
+	Key  string
+	Size int64
+}
+
+func (a Action) String() string {
+	return fmt.Sprintf("%s %s (%d bytes)", a.Op, a.Key, a.Size)
+}
+
+type Options struct {
+	// SrcPrefix selects the source objects, it is replaced by DstPrefix in the target names.
+	SrcPrefix string
+	DstPrefix string
+	// Concurrency bounds the number of objects transferred at once, defaults to 4.
+	Concurrency int
+	// Delete removes target objects under DstPrefix that have no source counterpart.
+	Delete bool
+	// DryRun only computes the actions, nothing is copied or deleted.
+	DryRun bool
+	// OnAction is called after each action is applied, or for every planned action on a dry run.
+	// It may be called concurrently.
+	OnAction func(Action)
+}
+
+// Plan compares the listings of src and dst and returns the actions needed to make dst match src.
+// Objects are considered equal when their sizes and MD5 hashes match. Without an MD5 in a listing, objects of the
+// same size are equal when both buckets are of the same provider and report the same ETag, otherwise Stat is asked
+// for the MD5 and objects without one are copied.
+func Plan(ctx context.Context, src, dst bucket.Bucket, opts Options) ([]Action, error) {
+	srcObjects, err := src.List(ctx, opts.SrcPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing source: %w", err)
+	}
+	dstObjects, err := dst.List(ctx, opts.DstPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("listing target: %w", err)
+	}
+
+	existing := make(map[string]bucket.ObjectAttrs, len(dstObjects))
+	for _, o := range dstObjects {
+		existing[o.Name] = o
+	}
+
+	sameProvider := provider(src) == provider(dst)
+	var actions []Action
+	for _, o := range srcObjects {
+		key := opts.DstPrefix + strings.TrimPrefix(o.Name, opts.SrcPrefix)
+		d, ok := existing[key]
+		delete(existing, key)
+		if ok {
+			eq, err := equal(ctx, src, dst, o, d, sameProvider)
+			if err != nil {
+				return nil, err
+			}
+			if eq {
+				continue
+			}
+		}
+		actions = append(actions, Action{Op: OpCopy, Key: key, Size: o.Size})
+	}
+	if opts.Delete {
+		for _, o := range dstObjects {
+			if _, ok := existing[o.Name]; ok {
+				actions = append(actions, Action{Op: OpDelete, Key: o.Name, Size: o.Size})
+			}
+		}
+	}
+	return actions, nil
+}
+
+// equal compares the source object a with the target object b, Stat is only called for objects of the same size.
+func equal(ctx context.Context, src, dst bucket.Bucket, a, b bucket.ObjectAttrs, sameProvider bool) (bool, error) {
+	if a.Size != b.Size {
+		return false, nil
+	}
+	if len(a.MD5) > 0 && len(b.MD5) > 0 {
+		return bytes.Equal(a.MD5, b.MD5), nil
+	}
+	if sameProvider && a.ETag != "" && a.ETag == b.ETag {
+		return true, nil
+	}
+	srcMD5, dstMD5 := a.MD5, b.MD5
+	if len(srcMD5) == 0 {
+		attrs, err := src.Stat(ctx, a.Name)
+		if err != nil {
+			return false, fmt.Errorf("stat source %s: %w", a.Name, err)
+		}
+		srcMD5 = attrs.MD5
+	}
+	if len(dstMD5) == 0 {
+		attrs, err := dst.Stat(ctx, b.Name)
+		if err != nil {
+			return false, fmt.Errorf("stat target %s: %w", b.Name, err)
+		}
+		dstMD5 = attrs.MD5
+	}
+	return len(srcMD5) > 0 && bytes.Equal(srcMD5, dstMD5), nil
+}
+
+// provider returns the type of the bucket under the wrappers that have an Unwrap method.
+func provider(b bucket.Bucket) reflect.Type {
+	for {
+		w, ok := b.(interface{ Unwrap() bucket.Bucket })
+		if !ok {
+			return reflect.TypeOf(b)
+		}
+		b = w.Unwrap()
+	}
+}
+
+// Sync makes dst match src and returns the applied actions. On the first failure the remaining
+// transfers are cancelled and the error is returned together with the actions completed so far.
+func Sync(ctx context.Context, src, dst bucket.Bucket, opts Options) ([]Action, error) {
+	actions, err := Plan(ctx, src, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		if opts.OnAction != nil {
+			for _, a := range actions {
+				opts.OnAction(a)
+			}
+		}
+		return actions, nil
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		done     []Action
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, a := range actions {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(a Action) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := apply(ctx, src, dst, opts, a)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s %s: %w", a.Op, a.Key, err)
+					cancel()
+				}
+				return
+			}
+			done = append(done, a)
+			if opts.OnAction != nil {
+				opts.OnAction(a)
+			}
+		}(a)
+	}
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	return done, firstErr
+}
+
+func apply(ctx context.Context, src, dst bucket.Bucket, opts Options, a Action) error {
+	if a.Op == OpDelete {
+		return dst.Delete(ctx, a.Key)
+	}
+	srcKey := opts.SrcPrefix + strings.TrimPrefix(a.Key, opts.DstPrefix)
+	rc, err := src.DownloadByChunks(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return dst.UploadByChunks(ctx, rc, a.Key)
+}
@@ -0,0 +1,167 @@
+package bucketsync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/local"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestService(t *testing.T) {
+	suite.Run(t, new(Suite))
+}
+
+type Suite struct {
+	suite.Suite
+	src bucket.Bucket
+	dst bucket.Bucket
+}
+
+func (s *Suite) SetupTest() {
+	ctx := context.Background()
+	src, err := local.OpenBucket(ctx, s.T().TempDir())
+	s.Require().NoError(err)
+	dst, err := local.OpenBucket(ctx, s.T().TempDir())
+	s.Require().NoError(err)
+	s.src, s.dst = src, dst
+
+	s.upload(s.src, "data/same", "same")
+	s.upload(s.src, "data/changed", "new content")
+	s.upload(s.src, "data/new", "new")
+	s.upload(s.src, "other", "not synced")
+	s.upload(s.dst, "backup/same", "same")
+	s.upload(s.dst, "backup/changed", "old content")
+	s.upload(s.dst, "backup/extra", "extra")
+}
+
+func (s *Suite) upload(b bucket.Bucket, name, content string) {
+	s.Require().NoError(b.UploadBytes(context.Background(), []byte(content), name))
+}
+
+func (s *Suite) names(actions []Action) []string {
+	var names []string
+	for _, a := range actions {
+		names = append(names, string(a.Op)+" "+a.Key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Suite) TestPlan() {
+	actions, err := Plan(context.Background(), s.src, s.dst, Options{
+		SrcPrefix: "data/",
+		DstPrefix: "backup/",
+		Delete:    true,
+	})
+	s.NoError(err)
+	s.Equal([]string{"copy backup/changed", "copy backup/new", "delete backup/extra"}, s.names(actions))
+}
+
+// multipartBucket reports objects like S3 does for multipart uploads: without an MD5, with an ETag that is
+// not the MD5 of the content.
+type multipartBucket struct {
+	bucket.Bucket
+}
+
+func (b multipartBucket) attrs(o bucket.ObjectAttrs) bucket.ObjectAttrs {
+	o.ETag = fmt.Sprintf(`"%x-2"`, o.MD5)
+	o.MD5 = nil
+	return o
+}
+
+func (b multipartBucket) List(ctx context.Context, prefix string) ([]bucket.ObjectAttrs, error) {
+	objects, err := b.Bucket.List(ctx, prefix)
+	for i := range objects {
+		objects[i] = b.attrs(objects[i])
+	}
+	return objects, err
+}
+
+func (b multipartBucket) Stat(ctx context.Context, objName string, opts ...bucket.Option) (bucket.ObjectAttrs, error) {
+	attrs, err := b.Bucket.Stat(ctx, objName, opts...)
+	return b.attrs(attrs), err
+}
+
+// TestPlanWithoutMD5 changes the content of a source object without an MD5 at the same size, it is copied again.
+func (s *Suite) TestPlanWithoutMD5() {
+	ctx := context.Background()
+	src := multipartBucket{s.src}
+	opts := Options{SrcPrefix: "data/same", DstPrefix: "backup/same"}
+
+	actions, err := Plan(ctx, src, s.dst, opts)
+	s.NoError(err)
+	s.Equal([]string{"copy backup/same"}, s.names(actions), "the content can not be compared")
+
+	// both buckets are of the same provider, the ETags match
+	actions, err = Plan(ctx, src, multipartBucket{s.dst}, opts)
+	s.NoError(err)
+	s.Empty(actions)
+
+	s.upload(s.src, "data/same", "SAME")
+	actions, err = Plan(ctx, src, multipartBucket{s.dst}, opts)
+	s.NoError(err)
+	s.Equal([]string{"copy backup/same"}, s.names(actions))
+	actions, err = Plan(ctx, multipartBucket{s.src}, s.dst, opts)
+	s.NoError(err)
+	s.Equal([]string{"copy backup/same"}, s.names(actions))
+}
+
+func (s *Suite) TestDryRun() {
+	ctx := context.Background()
+	var reported []Action
+	actions, err := Sync(ctx, s.src, s.dst, Options{
+		SrcPrefix: "data/",
+		DstPrefix: "backup/",
+		DryRun:    true,
+		OnAction:  func(a Action) { reported = append(reported, a) },
+	})
+	s.NoError(err)
+	s.Equal([]string{"copy backup/changed", "copy backup/new"}, s.names(actions))
+	s.Equal(actions, reported)
+
+	content, err := s.dst.DownloadBytes(ctx, "backup/changed")
+	s.NoError(err)
+	s.Equal("old content", string(content))
+}
+
+func (s *Suite) TestSync() {
+	ctx := context.Background()
+	actions, err := Sync(ctx, s.src, s.dst, Options{
+		SrcPrefix:   "data/",
+		DstPrefix:   "backup/",
+		Concurrency: 2,
+		Delete:      true,
+	})
+	s.NoError(err)
+	s.Len(actions, 3)
+
+	list, err := s.dst.List(ctx, "")
+	s.NoError(err)
+	var names []string
+	for _, o := range list {
+		names = append(names, o.Name)
+	}
+	s.Equal([]string{"backup/changed", "backup/new", "backup/same"}, names)
+
+	content, err := s.dst.DownloadBytes(ctx, "backup/changed")
+	s.NoError(err)
+	s.Equal("new content", string(content))
+
+	actions, err = Plan(ctx, s.src, s.dst, Options{SrcPrefix: "data/", DstPrefix: "backup/", Delete: true})
+	s.NoError(err)
+	s.Empty(actions)
+}
+
+func (s *Suite) TestSyncKeepsExtraneousWithoutDelete() {
+	ctx := context.Background()
+	_, err := Sync(ctx, s.src, s.dst, Options{SrcPrefix: "data/", DstPrefix: "backup/"})
+	s.NoError(err)
+
+	_, err = s.dst.DownloadBytes(ctx, "backup/extra")
+	s.NoError(err)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/local"
+	"io"
+	"time"
+)
+
+const (
+	lsUsage         = "ls URL"
+	rmUsage         = "rm [-r] URL"
+	catUsage        = "cat URL"
+	statUsage       = "stat URL"
+	presignGetUsage = "presign-get [-ttl DURATION] URL"
+	presignPutUsage = "presign-put [-ttl DURATION] URL"
+	mbUsage         = "mb [-location LOCATION] [-storage-class CLASS] URL"
+)
+
+const defaultTTL = time.Hour
+
+// putSigner is implemented by buckets that can presign uploads.
+type putSigner interface {
+	GeneratePutObjectSignedURL(ctx context.Context, objName string, ttl time.Time) (string, error)
+}
+
+func runLs(ctx context.Context, args []string) error {
+	fs := newFlagSet("ls", lsUsage)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	loc, err := openLocation(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	objects, err := loc.bucket.List(ctx, loc.path)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		fmt.Fprintf(stdout, "%12d  %s  %s\n", o.Size, o.Updated.UTC().Format(time.RFC3339), o.Name)
+	}
+	return nil
+}
+
+func runRm(ctx context.Context, args []string) error {
+	fs := newFlagSet("rm", rmUsage)
+	recursive := fs.Bool("r", false, "delete every object under the URL prefix")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	if *recursive {
+		loc, err := openLocation(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return loc.bucket.DeletePrefix(ctx, loc.path)
+	}
+	loc, err := openNamedObject(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return loc.bucket.Delete(ctx, loc.path)
+}
+
+func runCat(ctx context.Context, args []string) error {
+	fs := newFlagSet("cat", catUsage)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	loc, err := openNamedObject(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	rc, err := loc.bucket.DownloadByChunks(ctx, loc.path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(stdout, rc)
+	return err
+}
+
+func runStat(ctx context.Context, args []string) error {
+	fs := newFlagSet("stat", statUsage)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	loc, err := openNamedObject(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	attrs, err := loc.bucket.Stat(ctx, loc.path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Name:         %s\n", attrs.Name)
+	fmt.Fprintf(stdout, "Size:         %d\n", attrs.Size)
+	fmt.Fprintf(stdout, "Content-Type: %s\n", attrs.ContentType)
+	fmt.Fprintf(stdout, "ETag:         %s\n", attrs.ETag)
+	fmt.Fprintf(stdout, "MD5:          %s\n", hex.EncodeToString(attrs.MD5))
+	fmt.Fprintf(stdout, "Updated:      %s\n", attrs.Updated.UTC().Format(time.RFC3339))
+	if attrs.StorageClass != "" {
+		fmt.Fprintf(stdout, "Class:        %s\n", attrs.StorageClass)
+	}
+	if r := attrs.Rehydration; r != nil {
+		fmt.Fprintf(stdout, "Rehydration:  %s\n", rehydrationState(r))
+	}
+	return nil
+}
+
+// rehydrationState describes the rehydration for stat, "pending to hot" or "done until <time>".
+func rehydrationState(r *bucket.Rehydration) string {
+	state := "done"
+	if r.Pending {
+		state = "pending"
+	}
+	if r.Class != "" {
+		state += " to " + string(r.Class)
+	}
+	if !r.Expires.IsZero() {
+		state += " until " + r.Expires.UTC().Format(time.RFC3339)
+	}
+	return state
+}
+
+func runPresignGet(ctx context.Context, args []string) error {
+	fs := newFlagSet("presign-get", presignGetUsage)
+	ttl := fs.Duration("ttl", defaultTTL, "how long the URL stays valid")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	loc, err := openNamedObject(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	u, err := loc.bucket.GenerateGetObjectSignedURL(ctx, loc.path, time.Now().Add(*ttl))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, u)
+	return nil
+}
+
+func runPresignPut(ctx context.Context, args []string) error {
+	fs := newFlagSet("presign-put", presignPutUsage)
+	ttl := fs.Duration("ttl", defaultTTL, "how long the URL stays valid")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	loc, err := openNamedObject(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	signer, ok := loc.bucket.(putSigner)
+	if !ok {
+		return fmt.Errorf("%s: presigned uploads are not supported by this provider", fs.Arg(0))
+	}
+	u, err := signer.GeneratePutObjectSignedURL(ctx, loc.path, time.Now().Add(*ttl))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, u)
+	return nil
+}
+
+// runMb creates the bucket with the admin API of the provider, a local directory is created by opening it.
+func runMb(ctx context.Context, args []string) error {
+	fs := newFlagSet("mb", mbUsage)
+	location := fs.String("location", "", "region or location of the bucket")
+	class := fs.String("storage-class", "", "default storage class: hot, cool, cold or archive")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	scheme, name, _, err := parseURL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if scheme == "file" {
+		_, err = local.OpenBucket(ctx, name)
+		return err
+	}
+	admin, err := openAdmin(ctx, scheme)
+	if err != nil {
+		return err
+	}
+	var opts []bucket.CreateOption
+	if *location != "" {
+		opts = append(opts, bucket.WithLocation(*location))
+	}
+	if *class != "" {
+		opts = append(opts, bucket.WithDefaultStorageClass(bucket.StorageClass(*class)))
+	}
+	return admin.CreateBucket(ctx, name, opts...)
+}
+
+// openNamedObject is openObject for commands that need an object name.
+func openNamedObject(ctx context.Context, raw string) (location, error) {
+	loc, err := openObject(ctx, raw)
+	if err != nil {
+		return location{}, err
+	}
+	if loc.path == "" {
+		return location{}, fmt.Errorf("%s: URL must name an object", raw)
+	}
+	return loc, nil
+}
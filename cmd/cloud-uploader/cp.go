@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const cpUsage = "cp [-quiet] [-n] [-storage-class CLASS] SRC_URL DST_URL"
+
+// runCp streams an object between any two locations. A destination naming a bucket, a prefix ending
+// with a slash or a local directory receives the object under its source base name.
+func runCp(ctx context.Context, args []string) error {
+	fs := newFlagSet("cp", cpUsage)
+	quiet := fs.Bool("quiet", false, "do not report progress")
+	noClobber := fs.Bool("n", false, "fail instead of overwriting an existing destination object")
+	class := fs.String("storage-class", "", "storage class of the destination object: hot, cool, cold or archive")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+	src, err := openNamedObject(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, err := openObject(ctx, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if dst.path == "" || strings.HasSuffix(dst.path, "/") {
+		dst.path += path.Base(src.path)
+	}
+
+	var opts []bucket.Option
+	var p *progressPrinter
+	if !*quiet && isTerminal(stderr) {
+		p = &progressPrinter{name: dst.path}
+		opts = append(opts, bucket.WithProgress(p.update))
+	}
+	rc, err := src.bucket.DownloadByChunks(ctx, src.path, opts...)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if p != nil {
+		defer p.done()
+	}
+
+	var dstOpts []bucket.Option
+	if *noClobber {
+		dstOpts = append(dstOpts, bucket.IfNoneMatch(bucket.ETagAny))
+	}
+	if *class != "" {
+		dstOpts = append(dstOpts, bucket.WithStorageClass(bucket.StorageClass(*class)))
+	}
+	return dst.bucket.UploadByChunks(ctx, rc, dst.path, dstOpts...)
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,122 @@
+// Command cloud-uploader works with objects in any bucket supported by this module.
+// Buckets are addressed by URL: s3://bucket/key, gs://bucket/key, azblob://container/key,
+// mem://bucket/key, file:///dir or a plain local directory path.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"ls":          {usage: lsUsage, run: runLs},
+	"cp":          {usage: cpUsage, run: runCp},
+	"rm":          {usage: rmUsage, run: runRm},
+	"cat":         {usage: catUsage, run: runCat},
+	"stat":        {usage: statUsage, run: runStat},
+	"presign-get": {usage: presignGetUsage, run: runPresignGet},
+	"presign-put": {usage: presignPutUsage, run: runPresignPut},
+	"mb":          {usage: mbUsage, run: runMb},
+	"sync":        {usage: syncUsage, run: runSync},
+}
+
+// stdout and stderr are replaced in tests.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// errUsage is returned by commands invoked with wrong arguments, after the usage has been printed.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:]))
+}
+
+// run returns the exit code of the command once the opened buckets are closed, a failed close of a command
+// that succeeded is an error.
+func run(ctx context.Context, args []string) (code int) {
+	defer func() {
+		if err := closeOpened(ctx); err != nil {
+			fmt.Fprintf(stderr, "closing: %v\n", err)
+			if code == exitOK {
+				code = exitError
+			}
+		}
+	}()
+	if len(args) == 0 {
+		printUsage()
+		return exitUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		printUsage()
+		return exitUsage
+	}
+	err := cmd.run(ctx, args[1:])
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	default:
+		fmt.Fprintf(stderr, "%s: %v\n", args[0], err)
+		return exitError
+	}
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(stderr, "usage: cloud-uploader COMMAND [ARGS]")
+	fmt.Fprintln(stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(stderr, "  %s\n", commands[name].usage)
+	}
+}
+
+// newFlagSet returns a flag set that reports parse errors to run instead of exiting.
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: cloud-uploader %s\n", usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the flags and checks that nargs positional arguments are left.
+func parseArgs(fs *flag.FlagSet, args []string, nargs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() != nargs {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/mem"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestService(t *testing.T) {
+	suite.Run(t, new(Suite))
+}
+
+type Suite struct {
+	suite.Suite
+	dir    string
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+}
+
+func (s *Suite) SetupTest() {
+	s.dir = s.T().TempDir()
+	s.stdout.Reset()
+	s.stderr.Reset()
+	stdout, stderr = &s.stdout, &s.stderr
+}
+
+func (s *Suite) TearDownTest() {
+	stdout, stderr = os.Stdout, os.Stderr
+}
+
+func (s *Suite) run(args ...string) int {
+	return run(context.Background(), args)
+}
+
+func (s *Suite) TestParseURL() {
+	tests := map[string]struct {
+		raw, scheme, name, path string
+		err                     bool
+	}{
+		"s3":         {raw: "s3://bucket/dir/key", scheme: "s3", name: "bucket", path: "dir/key"},
+		"gs":         {raw: "gs://bucket", scheme: "gs", name: "bucket"},
+		"azblob":     {raw: "azblob://container/key", scheme: "azblob", name: "container", path: "key"},
+		"mem":        {raw: "mem://bucket/key", scheme: "mem", name: "bucket", path: "key"},
+		"file":       {raw: "file:///tmp/dir", scheme: "file", name: "/tmp/dir"},
+		"plain path": {raw: "dir/file", scheme: "file", name: "dir/file"},
+		"no bucket":  {raw: "s3:///key", err: true},
+		"bad scheme": {raw: "ftp://host/key", err: true},
+	}
+	for name, test := range tests {
+		s.Run(name, func() {
+			scheme, bucketName, path, err := parseURL(test.raw)
+			if test.err {
+				s.Error(err)
+				return
+			}
+			s.NoError(err)
+			s.Equal(test.scheme, scheme)
+			s.Equal(test.name, bucketName)
+			s.Equal(test.path, path)
+		})
+	}
+}
+
+func (s *Suite) TestUsage() {
+	s.Equal(exitUsage, s.run())
+	s.Equal(exitUsage, s.run("unknown"))
+	s.Equal(exitUsage, s.run("cp", "only-one-arg"))
+	s.Equal(exitUsage, s.run("ls", "-unknown-flag", s.dir))
+	s.Equal(exitOK, s.run("ls", "-h"))
+}
+
+func (s *Suite) TestObjectCommands() {
+	local := filepath.Join(s.dir, "local.txt")
+	bucketDir := filepath.Join(s.dir, "bucket")
+	s.Require().NoError(os.WriteFile(local, []byte("hello"), 0644))
+
+	s.Equal(exitOK, s.run("mb", bucketDir))
+	s.Equal(exitOK, s.run("cp", local, "file://"+bucketDir+"/dir/"))
+	s.Equal(exitOK, s.run("cp", bucketDir+"/dir/local.txt", bucketDir+"/copy.txt"))
+	s.Equal(exitError, s.run("cp", "-n", local, bucketDir+"/copy.txt"))
+
+	s.Equal(exitOK, s.run("ls", bucketDir))
+	s.Contains(s.stdout.String(), "dir/local.txt")
+	s.Contains(s.stdout.String(), "copy.txt")
+
+	s.stdout.Reset()
+	s.Equal(exitOK, s.run("cat", bucketDir+"/copy.txt"))
+	s.Equal("hello", s.stdout.String())
+
+	s.stdout.Reset()
+	s.Equal(exitOK, s.run("stat", bucketDir+"/copy.txt"))
+	s.Contains(s.stdout.String(), "Size:         5")
+	s.Contains(s.stdout.String(), "MD5:          5d41402abc4b2a76b9719d911017c592")
+
+	s.stdout.Reset()
+	s.Equal(exitOK, s.run("presign-get", bucketDir+"/copy.txt"))
+	s.Contains(s.stdout.String(), "file://")
+
+	s.Equal(exitError, s.run("presign-put", bucketDir+"/copy.txt"))
+
+	s.Equal(exitOK, s.run("rm", bucketDir+"/copy.txt"))
+	s.Equal(exitError, s.run("cat", bucketDir+"/copy.txt"))
+	s.Equal(exitError, s.run("rm", bucketDir+"/"))
+
+	s.Equal(exitOK, s.run("rm", "-r", bucketDir))
+	s.stdout.Reset()
+	s.Equal(exitOK, s.run("ls", bucketDir))
+	s.Empty(s.stdout.String())
+}
+
+// TestMb runs the commands against mem buckets, the state outlives the commands in the snapshot file as it
+// outlives the processes of the tool.
+func (s *Suite) TestMb() {
+	ctx := context.Background()
+	snapshot := filepath.Join(s.dir, "snapshot.json")
+	s.T().Setenv(mem.HostName, "127.0.0.1")
+	s.T().Setenv(mem.Port, "0")
+	s.T().Setenv(mem.SnapshotFile, snapshot)
+	defer mem.Shutdown(ctx)
+
+	s.Equal(exitOK, s.run("mb", "-location", "EU", "mem://scratch"))
+	s.Equal(exitError, s.run("mb", "mem://scratch"))
+	exists, err := mem.BucketExists(ctx, "scratch")
+	s.NoError(err)
+	s.True(exists)
+	s.Equal(exitOK, s.run("ls", "mem://scratch"))
+
+	local := filepath.Join(s.dir, "archive.tar")
+	s.Require().NoError(os.WriteFile(local, []byte("archive"), 0644))
+	s.Equal(exitOK, s.run("cp", "-storage-class", "archive", local, "mem://scratch/"))
+	s.FileExists(snapshot, "the snapshot is saved when the command exits")
+	s.Equal(exitError, s.run("cp", "-storage-class", "frozen", local, "mem://scratch/"))
+	s.stdout.Reset()
+	s.Equal(exitOK, s.run("stat", "mem://scratch/archive.tar"))
+	s.Contains(s.stdout.String(), "Class:        archive")
+}
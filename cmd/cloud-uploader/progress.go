@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"time"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+// progressPrinter reports a transfer on stderr, at most once per progressInterval.
+type progressPrinter struct {
+	name    string
+	last    bucket.Progress
+	printed time.Time
+}
+
+func (p *progressPrinter) update(pr bucket.Progress) {
+	p.last = pr
+	if time.Since(p.printed) >= progressInterval {
+		p.print()
+	}
+}
+
+func (p *progressPrinter) print() {
+	p.printed = time.Now()
+	if p.last.Total > 0 {
+		fmt.Fprintf(stderr, "\r%s: %d/%d bytes (%d%%), %.0f B/s",
+			p.name, p.last.Transferred, p.last.Total, p.last.Transferred*100/p.last.Total, p.last.Rate)
+		return
+	}
+	fmt.Fprintf(stderr, "\r%s: %d bytes, %.0f B/s", p.name, p.last.Transferred, p.last.Rate)
+}
+
+// done prints the final state and ends the progress line.
+func (p *progressPrinter) done() {
+	p.print()
+	fmt.Fprintln(stderr)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucketsync"
+)
+
+const syncUsage = "sync [-delete] [-dry-run] [-concurrency N] SRC_URL DST_URL"
+
+func runSync(ctx context.Context, args []string) error {
+	fs := newFlagSet("sync", syncUsage)
+	deleteExtra := fs.Bool("delete", false, "delete target objects missing from the source")
+	dryRun := fs.Bool("dry-run", false, "print the changes without applying them")
+	concurrency := fs.Int("concurrency", 4, "number of objects transferred at once")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+
+	src, err := openLocation(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dst, err := openLocation(ctx, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	if *dryRun {
+		prefix = "(dry run) "
+	}
+	_, err = bucketsync.Sync(ctx, src.bucket, dst.bucket, bucketsync.Options{
+		SrcPrefix:   src.path,
+		DstPrefix:   dst.path,
+		Concurrency: *concurrency,
+		Delete:      *deleteExtra,
+		DryRun:      *dryRun,
+		OnAction: func(a bucketsync.Action) {
+			fmt.Fprintln(stdout, prefix+a.String())
+		},
+	})
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/aws"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/azure"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/gcp"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/local"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/mem"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// location is an object name, or a prefix of names, inside an opened bucket.
+type location struct {
+	bucket bucket.Bucket
+	path   string
+}
+
+// parseURL splits a bucket URL into its scheme, bucket name and path inside the bucket.
+// Anything that is not a URL with a known scheme is treated as a local path.
+func parseURL(raw string) (scheme, name, path string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// len(u.Scheme) == 1 catches Windows drive letters.
+		return "file", raw, "", nil
+	}
+	switch u.Scheme {
+	case "s3", "gs", "azblob", "mem":
+		if u.Host == "" {
+			return "", "", "", fmt.Errorf("%q: missing bucket name", raw)
+		}
+		return u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+	case "file":
+		return "file", u.Path, "", nil
+	default:
+		return "", "", "", fmt.Errorf("%q: unsupported scheme %q", raw, u.Scheme)
+	}
+}
+
+func openLocation(ctx context.Context, raw string) (location, error) {
+	scheme, name, path, err := parseURL(raw)
+	if err != nil {
+		return location{}, err
+	}
+	b, err := openBucket(ctx, scheme, name)
+	if err != nil {
+		return location{}, fmt.Errorf("opening %s: %w", raw, err)
+	}
+	return location{bucket: b, path: path}, nil
+}
+
+// openObject is like openLocation, but a local path names a file and its directory is opened as the bucket.
+// A local directory, or a path ending with a separator, is opened with an empty object name.
+func openObject(ctx context.Context, raw string) (location, error) {
+	scheme, name, path, err := parseURL(raw)
+	if err != nil {
+		return location{}, err
+	}
+	if scheme == "file" {
+		info, err := os.Stat(name)
+		if !(err == nil && info.IsDir()) && !strings.HasSuffix(name, string(filepath.Separator)) {
+			name, path = filepath.Dir(name), filepath.Base(name)
+		}
+	}
+	b, err := openBucket(ctx, scheme, name)
+	if err != nil {
+		return location{}, fmt.Errorf("opening %s: %w", raw, err)
+	}
+	return location{bucket: b, path: path}, nil
+}
+
+// closers are the buckets and admins opened by the command that hold a client, run closes them.
+var closers []io.Closer
+
+// track adds v to closers if it implements io.Closer.
+func track(v interface{}) {
+	if c, ok := v.(io.Closer); ok {
+		closers = append(closers, c)
+	}
+}
+
+// closeOpened closes the buckets and admins opened by the command and shuts the default mem server down,
+// which saves its snapshot. It returns the first error.
+func closeOpened(ctx context.Context) error {
+	var first error
+	for _, c := range closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	closers = nil
+	if err := mem.Shutdown(ctx); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
+func openBucket(ctx context.Context, scheme, name string) (bucket.Bucket, error) {
+	var b bucket.Bucket
+	var err error
+	switch scheme {
+	case "s3":
+		b, err = aws.OpenBucket(ctx, name)
+	case "gs":
+		b, err = gcp.OpenBucket(ctx, name)
+	case "azblob":
+		b, err = azure.OpenBucket(ctx, name)
+	case "mem":
+		b, err = mem.OpenBucket(ctx, name)
+	default:
+		b, err = local.OpenBucket(ctx, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	track(b)
+	return b, nil
+}
+
+// openAdmin returns the bucket admin of the provider of a cloud or mem scheme. The admin of mem is the
+// default server, closeOpened shuts it down.
+func openAdmin(ctx context.Context, scheme string) (bucket.Admin, error) {
+	var admin bucket.Admin
+	var err error
+	switch scheme {
+	case "s3":
+		admin, err = aws.NewAdmin(ctx)
+	case "gs":
+		admin, err = gcp.NewAdmin(ctx)
+	case "azblob":
+		admin, err = azure.NewAdmin(ctx)
+	case "mem":
+		return mem.DefaultServer()
+	default:
+		return nil, fmt.Errorf("%s: buckets can not be managed", scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	track(admin)
+	return admin, nil
+}
@@ -1,127 +1,284 @@
 package gcp
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"google.golang.org/api/iterator"
 	"io"
-	"io/ioutil"
 	"net/http"
-	"os"
-	"time"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"cloud.google.com/go/storage"
-	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 )
 
+// adapter runs the calls of one bucket method on the client of the bucket with the context of the call.
 type adapter struct {
-	client *storage.Client
-	ctx    context.Context
+	client     *storage.Client
+	httpClient *http.Client
+	endpoint   string
+	ctx        context.Context
 }
 
 // set ur project id
 const projectID = "test-obj-store"
 
-func isBucketExist(bucketName string) (bool, error) {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
-	if err != nil {
-		return false, fmt.Errorf("storage.NewClient: %v", err)
+type adapterInterface interface {
+	io.Closer
+	Delete(objName, bucketName string) error
+	NewWriter(objName, bucketName string, conds storage.Conditions, enc bucket.Encryption, class string) io.WriteCloser
+	NewReader(objName, bucketName string, conds storage.Conditions, enc bucket.Encryption) (io.ReadCloser, error)
+	Objects(bucketName, prefix string) ([]*storage.ObjectAttrs, error)
+	Attrs(objName, bucketName string) (*storage.ObjectAttrs, error)
+	Versions(objName, bucketName string) ([]*storage.ObjectAttrs, error)
+	NewVersionReader(objName, bucketName string, generation int64, enc bucket.Encryption) (io.ReadCloser, error)
+	DeleteVersion(objName, bucketName string, generation int64) error
+	SetMetadata(objName, bucketName string, metadata map[string]string) error
+	SetStorageClass(objName, bucketName string, class string) error
+	Lifecycle(bucketName string) ([]bucket.LifecycleRule, error)
+	SetLifecycle(bucketName string, rules []bucket.LifecycleRule) error
+	SignedURL(bucket string, object string, opts *storage.SignedURLOptions) (string, error)
+}
+
+func (a *adapter) SignedURL(bucket string, object string, opts *storage.SignedURLOptions) (string, error) {
+	return storage.SignedURL(bucket, object, opts)
+}
+
+// Close releases the adapter, the client is shared by the calls of the bucket and stays open until
+// bucketGCP.Close.
+func (a *adapter) Close() error {
+	return nil
+}
+
+func (a *adapter) Delete(objName, bucketName string) error {
+	o := a.client.Bucket(bucketName).Object(objName)
+	if err := o.Delete(a.ctx); err != nil {
+		return fmt.Errorf("Object(%q).Delete: %v", objName, err)
 	}
-	defer client.Close()
-	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
-	defer cancel()
-	var buckets []string
-	it := client.Buckets(ctx, projectID)
+	return nil
+}
+
+// object returns the handle of the object, conds are applied unless they are empty.
+func (a *adapter) object(objName, bucketName string, conds storage.Conditions) *storage.ObjectHandle {
+	o := a.client.Bucket(bucketName).Object(objName)
+	if conds != (storage.Conditions{}) {
+		o = o.If(conds)
+	}
+	return o
+}
+
+// NewWriter encrypts the object with the customer key, or the KMS key, of enc. An empty class is the default
+// class of the bucket.
+func (a *adapter) NewWriter(objName, bucketName string, conds storage.Conditions, enc bucket.Encryption, class string) io.WriteCloser {
+	w := withCustomerKey(a.object(objName, bucketName, conds), enc).NewWriter(a.ctx)
+	if enc.Type == bucket.EncryptionKMS {
+		w.KMSKeyName = enc.KMSKeyID
+	}
+	w.StorageClass = class
+	return w
+}
+
+func (a *adapter) NewReader(objName, bucketName string, conds storage.Conditions, enc bucket.Encryption) (io.ReadCloser, error) {
+	return withCustomerKey(a.object(objName, bucketName, conds), enc).NewReader(a.ctx)
+}
+
+// withCustomerKey returns the handle of an object encrypted with the customer key of enc, o for other types.
+func withCustomerKey(o *storage.ObjectHandle, enc bucket.Encryption) *storage.ObjectHandle {
+	if enc.Type != bucket.EncryptionCustomerKey {
+		return o
+	}
+	return o.Key(enc.CustomerKey)
+}
+
+func (a *adapter) Objects(bucketName, prefix string) ([]*storage.ObjectAttrs, error) {
+	var objects []*storage.ObjectAttrs
+	it := a.client.Bucket(bucketName).Objects(a.ctx, &storage.Query{Prefix: prefix})
 	for {
-		battrs, err := it.Next()
+		attrs, err := it.Next()
 		if err == iterator.Done {
-			break
+			return objects, nil
 		}
 		if err != nil {
-			return false, err
-		}
-		buckets = append(buckets, battrs.Name)
-	}
-	for _, bucket := range buckets {
-		if bucket == bucketName {
-			return true, nil
+			return nil, fmt.Errorf("Bucket(%q).Objects: %v", bucketName, err)
 		}
+		objects = append(objects, attrs)
 	}
-	return false, nil
 }
 
-func createBucket(bucketName string) error {
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+func (a *adapter) Attrs(objName, bucketName string) (*storage.ObjectAttrs, error) {
+	attrs, err := a.client.Bucket(bucketName).Object(objName).Attrs(a.ctx)
 	if err != nil {
-		return fmt.Errorf("storage.NewClient: %v", err)
+		return nil, fmt.Errorf("Object(%q).Attrs: %w", objName, err)
+	}
+	return attrs, nil
+}
+
+// Versions returns every generation of the object, noncurrent ones are kept by buckets with versioning enabled.
+func (a *adapter) Versions(objName, bucketName string) ([]*storage.ObjectAttrs, error) {
+	var versions []*storage.ObjectAttrs
+	it := a.client.Bucket(bucketName).Objects(a.ctx, &storage.Query{Prefix: objName, Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return versions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Bucket(%q).Objects: %v", bucketName, err)
+		}
+		if attrs.Name == objName {
+			versions = append(versions, attrs)
+		}
 	}
-	defer client.Close()
+}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
-	defer cancel()
-	bucket := client.Bucket(bucketName)
-	if err := bucket.Create(ctx, projectID, nil); err != nil {
-		return fmt.Errorf("Bucket(%q).Create: %v", bucketName, err)
+func (a *adapter) NewVersionReader(objName, bucketName string, generation int64, enc bucket.Encryption) (io.ReadCloser, error) {
+	return withCustomerKey(a.client.Bucket(bucketName).Object(objName).Generation(generation), enc).NewReader(a.ctx)
+}
+
+func (a *adapter) DeleteVersion(objName, bucketName string, generation int64) error {
+	o := a.client.Bucket(bucketName).Object(objName).Generation(generation)
+	if err := o.Delete(a.ctx); err != nil {
+		return fmt.Errorf("Object(%q).Generation(%d).Delete: %v", objName, generation, err)
 	}
 	return nil
 }
 
-func (a *adapter) OptsGen(ttl time.Time) (*storage.SignedURLOptions, error) {
-	serviceAccount := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	jsonKey, err := ioutil.ReadFile(serviceAccount)
+// SetStorageClass rewrites the object in the class. A rewrite takes the metadata of the request instead of the
+// source, so the attributes are copied and the rewrite is bound to the generation they were read from.
+// The KMS key is kept, objects encrypted with a customer key fail.
+func (a *adapter) SetStorageClass(objName, bucketName string, class string) error {
+	o := a.client.Bucket(bucketName).Object(objName)
+	attrs, err := o.Attrs(a.ctx)
 	if err != nil {
-		return &storage.SignedURLOptions{}, fmt.Errorf("ioutil.ReadFile: %v", err)
+		return fmt.Errorf("Object(%q).Attrs: %w", objName, err)
 	}
-	conf, err := google.JWTConfigFromJSON(jsonKey)
-	if err != nil {
-		return &storage.SignedURLOptions{}, fmt.Errorf("google.JWTConfigFromJSON: %v", err)
+	if attrs.StorageClass == class {
+		return nil
+	}
+	c := o.If(storage.Conditions{GenerationMatch: attrs.Generation}).CopierFrom(o.Generation(attrs.Generation))
+	c.ContentType = attrs.ContentType
+	c.ContentEncoding = attrs.ContentEncoding
+	c.ContentLanguage = attrs.ContentLanguage
+	c.ContentDisposition = attrs.ContentDisposition
+	c.CacheControl = attrs.CacheControl
+	c.Metadata = attrs.Metadata
+	c.StorageClass = class
+	// the object reports the key version, the destination takes the key
+	if i := strings.Index(attrs.KMSKeyName, "/cryptoKeyVersions/"); i >= 0 {
+		c.DestinationKMSKeyName = attrs.KMSKeyName[:i]
+	} else {
+		c.DestinationKMSKeyName = attrs.KMSKeyName
 	}
-	opts := &storage.SignedURLOptions{
-		Scheme:         storage.SigningSchemeV4,
-		Method:         http.MethodGet,
-		GoogleAccessID: conf.Email,
-		PrivateKey:     conf.PrivateKey,
-		Expires:        ttl,
+	if _, err := c.Run(a.ctx); err != nil {
+		return fmt.Errorf("Object(%q).CopierFrom.Run: %w", objName, err)
 	}
-	return opts, nil
+	return nil
 }
 
-type adapterInterface interface {
-	io.Closer
-	Delete(objName, bucketName string) error
-	NewWriter(objName, bucketName string) io.WriteCloser
-	NewReader(objName, bucketName string) (io.ReadCloser, error)
-	SignedURL(bucket string, object string, opts *storage.SignedURLOptions) (string, error)
-	OptsGen(ttl time.Time) (*storage.SignedURLOptions, error)
+// SetMetadata replaces the custom metadata. Updates merge metadata keys and only a null value removes one,
+// which the client library can not send, so the patch is a plain request bound to the metageneration read.
+func (a *adapter) SetMetadata(objName, bucketName string, metadata map[string]string) error {
+	attrs, err := a.client.Bucket(bucketName).Object(objName).Attrs(a.ctx)
+	if err != nil {
+		return fmt.Errorf("Object(%q).Attrs: %w", objName, err)
+	}
+	patch := make(map[string]interface{}, len(attrs.Metadata)+len(metadata))
+	for k := range attrs.Metadata {
+		patch[k] = nil
+	}
+	for k, v := range metadata {
+		patch[k] = v
+	}
+	body, err := json.Marshal(map[string]interface{}{"metadata": patch})
+	if err != nil {
+		return err
+	}
+	path := "b/" + url.PathEscape(bucketName) + "/o/" + url.PathEscape(objName) +
+		"?fields=metageneration&ifMetagenerationMatch=" + strconv.FormatInt(attrs.Metageneration, 10)
+	if err := a.jsonRequest(http.MethodPatch, path, bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("Object(%q).Update: %w", objName, err)
+	}
+	return nil
 }
 
-func (a *adapter) SignedURL(bucket string, object string, opts *storage.SignedURLOptions) (string, error) {
-	return storage.SignedURL(bucket, object, opts)
+// jsonRequest sends a request of the JSON API the client library has no call for, path is relative to the
+// endpoint and the response is decoded into out unless it is nil.
+func (a *adapter) jsonRequest(method, path string, body io.Reader, out interface{}) error {
+	if a.httpClient == nil {
+		return errors.New("the bucket has no HTTP client, it was not opened by OpenBucket")
+	}
+	req, err := http.NewRequestWithContext(a.ctx, method, a.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
 }
 
-func newAdapter(ctx context.Context) (adapterInterface, error) {
-	client, err := storage.NewClient(ctx)
-	return &adapter{client, ctx}, err
+// lifecycleRule is a bucket lifecycle rule of the JSON API. The client library has no matchesPrefix
+// condition, so the lifecycle is read and written with plain requests.
+type lifecycleRule struct {
+	Action struct {
+		Type         string `json:"type"`
+		StorageClass string `json:"storageClass,omitempty"`
+	} `json:"action"`
+	Condition struct {
+		Age           int      `json:"age,omitempty"`
+		MatchesPrefix []string `json:"matchesPrefix,omitempty"`
+	} `json:"condition"`
 }
 
-func (a *adapter) Close() error {
-	return a.client.Close()
+type bucketLifecycle struct {
+	Lifecycle struct {
+		Rule []lifecycleRule `json:"rule"`
+	} `json:"lifecycle"`
 }
 
-func (a *adapter) Delete(objName, bucketName string) error {
-	o := a.client.Bucket(bucketName).Object(objName)
-	if err := o.Delete(a.ctx); err != nil {
-		return fmt.Errorf("Object(%q).Delete: %v", objName, err)
+func (a *adapter) lifecycleRequest(method, bucketName string, body io.Reader) (*bucketLifecycle, error) {
+	var lc bucketLifecycle
+	if err := a.jsonRequest(method, "b/"+url.PathEscape(bucketName)+"?fields=lifecycle", body, &lc); err != nil {
+		return nil, fmt.Errorf("Bucket(%q).Lifecycle: %w", bucketName, err)
 	}
-	return nil
+	return &lc, nil
 }
 
-func (a *adapter) NewWriter(objName, bucketName string) io.WriteCloser {
-	return a.client.Bucket(bucketName).Object(objName).NewWriter(a.ctx)
+func (a *adapter) Lifecycle(bucketName string) ([]bucket.LifecycleRule, error) {
+	lc, err := a.lifecycleRequest(http.MethodGet, bucketName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bucketRules(lc.Lifecycle.Rule), nil
 }
 
-func (a *adapter) NewReader(objName, bucketName string) (io.ReadCloser, error) {
-	return a.client.Bucket(bucketName).Object(objName).NewReader(a.ctx)
+// SetLifecycle replaces the lifecycle rules of the bucket, no rules removes them.
+func (a *adapter) SetLifecycle(bucketName string, rules []bucket.LifecycleRule) error {
+	gcsRules, err := lifecycleRules(rules)
+	if err != nil {
+		return err
+	}
+	var lc bucketLifecycle
+	lc.Lifecycle.Rule = append([]lifecycleRule{}, gcsRules...)
+	body, err := json.Marshal(lc)
+	if err != nil {
+		return err
+	}
+	_, err = a.lifecycleRequest(http.MethodPatch, bucketName, bytes.NewReader(body))
+	return err
 }
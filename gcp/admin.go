@@ -0,0 +1,110 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// Admin manages the buckets of the project.
+type Admin struct {
+	client *storage.Client
+}
+
+var _ bucket.Admin = (*Admin)(nil)
+
+// NewAdmin creates a client for the project, Close releases it.
+func NewAdmin(ctx context.Context) (*Admin, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	return &Admin{client: client}, nil
+}
+
+func (a *Admin) Close() error {
+	return a.client.Close()
+}
+
+// BucketExists reads the attributes of the bucket only, it needs storage.buckets.get on it.
+func (a *Admin) BucketExists(ctx context.Context, name string) (bool, error) {
+	_, err := a.client.Bucket(name).Attrs(ctx)
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Bucket(%q).Attrs: %w", name, err)
+	}
+	return true, nil
+}
+
+// CreateBucket creates the bucket in the location, the multi-region US by default. A default KMS key is
+// the CMEK of the objects uploaded without a key. Public read access needs an IAM binding and is not supported.
+func (a *Admin) CreateBucket(ctx context.Context, name string, opts ...bucket.CreateOption) error {
+	o := bucket.NewCreateOptions(opts...)
+	if o.PublicRead {
+		return bucket.ErrUnsupportedPublicRead{Bucket: name}
+	}
+	attrs := &storage.BucketAttrs{Location: o.Location}
+	if o.StorageClass != "" {
+		class, ok := storageClasses[o.StorageClass]
+		if !ok {
+			return bucket.ErrUnsupportedStorageClass{Class: o.StorageClass}
+		}
+		attrs.StorageClass = class
+	}
+	switch err := o.Encryption.Validate(); {
+	case err != nil:
+		return err
+	case o.Encryption.Type == bucket.EncryptionKMS:
+		attrs.Encryption = &storage.BucketEncryption{DefaultKMSKeyName: o.Encryption.KMSKeyID}
+	case o.Encryption.Type == bucket.EncryptionCustomerKey:
+		return bucket.ErrUnsupportedEncryption{Type: o.Encryption.Type, Reason: "GCS buckets can not default to customer keys"}
+	}
+	err := a.client.Bucket(name).Create(ctx, projectID, attrs)
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) && gErr.Code == http.StatusConflict {
+		return bucket.ErrBucketExists{Bucket: name}
+	}
+	if err != nil {
+		return fmt.Errorf("Bucket(%q).Create: %w", name, err)
+	}
+	return nil
+}
+
+func (a *Admin) DeleteBucket(ctx context.Context, name string) error {
+	err := a.client.Bucket(name).Delete(ctx)
+	var gErr *googleapi.Error
+	if errors.Is(err, storage.ErrBucketNotExist) || errors.As(err, &gErr) && gErr.Code == http.StatusNotFound {
+		return bucket.ErrBucketNotFound{Bucket: name}
+	}
+	if err != nil {
+		return fmt.Errorf("Bucket(%q).Delete: %w", name, err)
+	}
+	return nil
+}
+
+func (a *Admin) ListBuckets(ctx context.Context) ([]string, error) {
+	var names []string
+	it := a.client.Buckets(ctx, projectID)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attrs.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -3,72 +3,192 @@ package gcp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 )
 
 const chunkSize = 32
 
 type bucketGCP struct {
 	bucketName string
+	// client is created by OpenBucket and shared by every call until Close.
+	client *storage.Client
+	// httpClient sends the JSON API requests the client library has no call for to endpoint, client is
+	// built on the same HTTP client.
+	httpClient *http.Client
+	endpoint   string
+	// signer signs URLs, signerErr tells why there is none.
+	signer     Signer
+	signerErr  error
 	newAdapter func(ctx context.Context) (adapterInterface, error)
 }
 
 var _ bucket.Bucket = (*bucketGCP)(nil)
+var _ bucket.Lifecycler = (*bucketGCP)(nil)
+var _ bucket.StorageClassSetter = (*bucketGCP)(nil)
 
-func OpenBucket(_ context.Context, bucketName string) (*bucketGCP, error) {
-	isExist, err := isBucketExist(bucketName)
+// OpenBucket creates the client of the bucket, it is reused by every call until Close. The client outlives
+// ctx, which only bounds the bucket lookup and creation. The bucket has to exist unless WithCreate is given.
+// The signer is set up once, a missing key file only fails the signing calls.
+func OpenBucket(ctx context.Context, bucketName string, opts ...Option) (*bucketGCP, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	httpClient, endpoint, err := htransport.NewClient(context.Background(), clientOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("transport.NewClient: %v", err)
+	}
+	client, err := storage.NewClient(context.Background(), option.WithHTTPClient(httpClient), option.WithEndpoint(endpoint))
 	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	admin := &Admin{client: client}
+	if err := bucket.EnsureBucket(ctx, admin, bucketName, o.create, o.createOpts...); err != nil {
+		client.Close()
 		return nil, err
 	}
-	if !isExist {
-		err = createBucket(bucketName)
-		if err != nil {
-			return nil, err
+	b := newBucket(client, bucketName)
+	b.httpClient, b.endpoint = httpClient, endpoint
+	b.signer = o.signer
+	if b.signer == nil {
+		b.signer, b.signerErr = defaultSigner()
+	}
+	return b, nil
+}
+
+// clientOptions are the scope and the endpoint of the JSON API, the one of STORAGE_EMULATOR_HOST without
+// authentication when it is set, as storage.NewClient chooses them by default.
+func clientOptions() []option.ClientOption {
+	host := os.Getenv("STORAGE_EMULATOR_HOST")
+	if host == "" {
+		return []option.ClientOption{
+			option.WithScopes(storage.ScopeFullControl),
+			option.WithEndpoint("https://storage.googleapis.com/storage/v1/"),
 		}
 	}
-	return &bucketGCP{bucketName: bucketName, newAdapter: newAdapter}, nil
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return []option.ClientOption{
+		option.WithoutAuthentication(),
+		option.WithEndpoint(strings.TrimSuffix(host, "/") + "/storage/v1/"),
+	}
 }
 
-func (bucket *bucketGCP) Delete(ctx context.Context, objName string) error {
-	a, err := bucket.newAdapter(ctx)
+func newBucket(client *storage.Client, bucketName string) *bucketGCP {
+	b := &bucketGCP{
+		bucketName: bucketName,
+		client:     client,
+	}
+	b.newAdapter = func(ctx context.Context) (adapterInterface, error) {
+		return &adapter{client: b.client, httpClient: b.httpClient, endpoint: b.endpoint, ctx: ctx}, nil
+	}
+	return b
+}
+
+// Close closes the client of the bucket, the bucket can not be used afterwards.
+func (b *bucketGCP) Close() error {
+	if b.client == nil {
+		return nil
+	}
+	return b.client.Close()
+}
+
+func (b *bucketGCP) Delete(ctx context.Context, objName string) error {
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return a.Delete(objName, b.bucketName)
+}
+
+// DeleteMany deletes the objects in parallel, GCS has no batch delete in the client library.
+func (b *bucketGCP) DeleteMany(ctx context.Context, objNames []string) error {
+	a, err := b.newAdapter(ctx)
 	if err != nil {
 		return err
 	}
 	defer a.Close()
-	return a.Delete(objName, bucket.bucketName)
+	return bucket.DeleteEach(ctx, objNames, func(_ context.Context, objName string) error {
+		return a.Delete(objName, b.bucketName)
+	})
 }
 
-func (bucket *bucketGCP) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string) error {
-	data := bytes.NewReader(fileAsBytes)
-	a, err := bucket.newAdapter(ctx)
+func (b *bucketGCP) DeletePrefix(ctx context.Context, prefix string) error {
+	objects, err := b.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return b.DeleteMany(ctx, bucket.Names(objects))
+}
+
+func (b *bucketGCP) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string, opts ...bucket.Option) error {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.ErrUnsupportedTTL{TTL: o.TTL}
+	}
+	if err := o.Encryption.Validate(); err != nil {
+		return err
+	}
+	class, err := gcsClass(o.StorageClass)
+	if err != nil {
+		return err
+	}
+	data := o.ProgressReader(bytes.NewReader(fileAsBytes), -1)
+	a, err := b.newAdapter(ctx)
 	if err != nil {
 		return err
 	}
 	defer a.Close()
-	wc := a.NewWriter(objName, bucket.bucketName)
+	conds, err := b.conditions(a, objName, o, false)
+	if err != nil {
+		return err
+	}
+	wc := a.NewWriter(objName, b.bucketName, conds, o.Encryption, class)
 	if _, err = io.Copy(wc, data); err != nil {
 		return fmt.Errorf("io.Copy: %w", err)
 	}
 	if err := wc.Close(); err != nil {
-		return fmt.Errorf("Writer.Close: %w", err)
+		return fmt.Errorf("Writer.Close: %w", preconditionErr(err))
 	}
 	return nil
 }
 
-func (bucket *bucketGCP) DownloadBytes(ctx context.Context, objName string) ([]byte, error) {
-	a, err := bucket.newAdapter(ctx)
+func (b *bucketGCP) DownloadBytes(ctx context.Context, objName string, opts ...bucket.Option) ([]byte, error) {
+	o := bucket.NewOptions(opts...)
+	if err := o.Encryption.Validate(); err != nil {
+		return nil, err
+	}
+	a, err := b.newAdapter(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer a.Close()
-	rc, err := a.NewReader(objName, bucket.bucketName)
+	conds, err := b.conditions(a, objName, o, true)
 	if err != nil {
-		return nil, fmt.Errorf("Object(%q).NewReader: %w", objName, err)
+		return nil, err
 	}
+	rc, err := a.NewReader(objName, b.bucketName, conds, o.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("Object(%q).NewReader: %w", objName, preconditionErr(err))
+	}
+	rc = o.ProgressReadCloser(rc, remain(rc))
 	defer rc.Close()
 	data, err := ioutil.ReadAll(rc)
 	if err != nil {
@@ -77,49 +197,504 @@ func (bucket *bucketGCP) DownloadBytes(ctx context.Context, objName string) ([]b
 	return data, nil
 }
 
-func (bucket *bucketGCP) GenerateGetObjectSignedURL(ctx context.Context, objName string, ttl time.Time) (string, error) {
-	a, err := bucket.newAdapter(ctx)
-	if err != nil {
-		return "", err
+func (b *bucketGCP) GenerateGetObjectSignedURL(ctx context.Context, objName string, ttl time.Time) (string, error) {
+	return b.GenerateSignedURL(ctx, objName, ttl, URLOptions{})
+}
+
+// GeneratePutObjectSignedURL returns a URL uploading objName with PUT until ttl.
+func (b *bucketGCP) GeneratePutObjectSignedURL(ctx context.Context, objName string, ttl time.Time) (string, error) {
+	return b.GenerateSignedURL(ctx, objName, ttl, URLOptions{Method: http.MethodPut})
+}
+
+// GenerateSignedURL returns a URL valid until ttl for the request described by opts, e.g. a DELETE or
+// a PUT with the content type and metadata headers the uploader has to send.
+func (b *bucketGCP) GenerateSignedURL(ctx context.Context, objName string, ttl time.Time, opts URLOptions) (string, error) {
+	if b.signer == nil {
+		if b.signerErr != nil {
+			return "", b.signerErr
+		}
+		return "", ErrNoSigner{Err: errors.New("no signer configured")}
 	}
-	defer a.Close()
-	opts, err := a.OptsGen(ttl)
+	a, err := b.newAdapter(ctx)
 	if err != nil {
 		return "", err
 	}
-	u, err := a.SignedURL(bucket.bucketName, objName, opts)
+	defer a.Close()
+	u, err := a.SignedURL(b.bucketName, objName, opts.signedURLOptions(b.signer, ttl))
 	if err != nil {
 		return "", fmt.Errorf("storage.SignedURL: %w", err)
 	}
 	return u, nil
 }
 
-func (bucket *bucketGCP) DownloadByChunks(ctx context.Context, objName string) (io.ReadCloser, error) {
-	a, err := bucket.newAdapter(ctx)
+func (b *bucketGCP) DownloadByChunks(ctx context.Context, objName string, opts ...bucket.Option) (io.ReadCloser, error) {
+	o := bucket.NewOptions(opts...)
+	if err := o.Encryption.Validate(); err != nil {
+		return nil, err
+	}
+	a, err := b.newAdapter(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer a.Close()
-	rc, err := a.NewReader(objName, bucket.bucketName)
+	conds, err := b.conditions(a, objName, o, true)
 	if err != nil {
-		return nil, fmt.Errorf("Object(%q).NewReader: %w", objName, err)
+		a.Close()
+		return nil, err
+	}
+	rc, err := a.NewReader(objName, b.bucketName, conds, o.Encryption)
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("Object(%q).NewReader: %w", objName, preconditionErr(err))
+	}
+	// the stream owns the adapter, it is closed with the stream
+	return bucket.Stream(o.ProgressReadCloser(rc, remain(rc)), remain(rc), a), nil
+}
+
+// conditions translates the preconditions of o into generation preconditions, GCS does not accept etags.
+// The etags are checked against the current attributes and the request is pinned to the checked generation,
+// so an object replaced in between fails the request as well.
+func (b *bucketGCP) conditions(a adapterInterface, objName string, o bucket.Options, download bool) (storage.Conditions, error) {
+	if !o.Conditional() {
+		return storage.Conditions{}, nil
+	}
+	if !download && o.IfMatch == "" && o.IfNoneMatch == bucket.ETagAny {
+		return storage.Conditions{DoesNotExist: true}, nil
+	}
+	var current *bucket.ObjectAttrs
+	attrs, err := a.Attrs(objName, b.bucketName)
+	switch {
+	case err == nil:
+		oa := objectAttrs(attrs)
+		current = &oa
+	case !errors.Is(err, storage.ErrObjectNotExist):
+		return storage.Conditions{}, err
 	}
-	return rc, nil
+	check := o.CheckUpload
+	if download {
+		check = o.CheckDownload
+	}
+	if err := check(current); err != nil {
+		return storage.Conditions{}, err
+	}
+	switch {
+	case current != nil:
+		return storage.Conditions{GenerationMatch: attrs.Generation}, nil
+	case !download:
+		return storage.Conditions{DoesNotExist: true}, nil
+	}
+	return storage.Conditions{}, nil
 }
 
-func (bucket *bucketGCP) UploadByChunks(ctx context.Context, fileAsReadCloser io.Reader, objName string) error {
-	a, err := bucket.newAdapter(ctx)
+// preconditionErr turns the responses to failed conditional requests into bucket.ErrPreconditionFailed.
+func preconditionErr(err error) error {
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && (gerr.Code == http.StatusPreconditionFailed || gerr.Code == http.StatusNotModified) {
+		return bucket.ErrPreconditionFailed{}
+	}
+	return err
+}
+
+// remain returns the size reported by a storage.Reader, or -1 for other readers.
+func remain(r io.Reader) int64 {
+	if sr, ok := r.(interface{ Remain() int64 }); ok {
+		return sr.Remain()
+	}
+	return -1
+}
+
+func (b *bucketGCP) UploadByChunks(ctx context.Context, fileAsReadCloser io.Reader, objName string, opts ...bucket.Option) error {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.ErrUnsupportedTTL{TTL: o.TTL}
+	}
+	if err := o.Encryption.Validate(); err != nil {
+		return err
+	}
+	class, err := gcsClass(o.StorageClass)
+	if err != nil {
+		return err
+	}
+	fileAsReadCloser = o.ProgressReader(fileAsReadCloser, -1)
+	a, err := b.newAdapter(ctx)
 	if err != nil {
 		return err
 	}
 	defer a.Close()
-	wc := a.NewWriter(objName, bucket.bucketName)
+	conds, err := b.conditions(a, objName, o, false)
+	if err != nil {
+		return err
+	}
+	wc := a.NewWriter(objName, b.bucketName, conds, o.Encryption, class)
 	buf := make([]byte, chunkSize)
 	if _, err = io.CopyBuffer(wc, fileAsReadCloser, buf); err != nil {
 		return fmt.Errorf("io.Copy: %w", err)
 	}
 	if err := wc.Close(); err != nil {
-		return fmt.Errorf("Writer.Close: %w", err)
+		return fmt.Errorf("Writer.Close: %w", preconditionErr(err))
+	}
+	return nil
+}
+
+// NewWriter uploads with a storage.Writer, the writes are sent in chunks and Close finalizes the object.
+// CloseWithError cancels the context of the writer, which abandons the resumable upload.
+func (b *bucketGCP) NewWriter(ctx context.Context, objName string, opts ...bucket.Option) bucket.Writer {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.FailedWriter(bucket.ErrUnsupportedTTL{TTL: o.TTL})
+	}
+	if err := o.Encryption.Validate(); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	class, err := gcsClass(o.StorageClass)
+	if err != nil {
+		return bucket.FailedWriter(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		cancel()
+		return bucket.FailedWriter(err)
+	}
+	conds, err := b.conditions(a, objName, o, false)
+	if err != nil {
+		a.Close()
+		cancel()
+		return bucket.FailedWriter(err)
+	}
+	wc := a.NewWriter(objName, b.bucketName, conds, o.Encryption, class)
+	return bucket.NewUploadWriter(&upload{wc: wc, a: a, cancel: cancel}, o)
+}
+
+// upload is the storage.Writer of NewWriter with the adapter and the context it owns.
+type upload struct {
+	wc     io.WriteCloser
+	a      adapterInterface
+	cancel context.CancelFunc
+}
+
+func (u *upload) Write(p []byte) (int, error) {
+	n, err := u.wc.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("Writer.Write: %w", err)
+	}
+	return n, nil
+}
+
+func (u *upload) Commit() error {
+	defer u.release()
+	if err := u.wc.Close(); err != nil {
+		return fmt.Errorf("Writer.Close: %w", preconditionErr(err))
 	}
 	return nil
 }
+
+func (u *upload) Abort() error {
+	// the writer fails once its context is done, nothing is finalized
+	u.cancel()
+	_ = u.wc.Close()
+	u.release()
+	return nil
+}
+
+func (u *upload) release() {
+	u.a.Close()
+	u.cancel()
+}
+
+func (b *bucketGCP) List(ctx context.Context, prefix string) ([]bucket.ObjectAttrs, error) {
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	objects, err := a.Objects(b.bucketName, prefix)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]bucket.ObjectAttrs, len(objects))
+	for i, o := range objects {
+		list[i] = objectAttrs(o)
+	}
+	return list, nil
+}
+
+// Stat reads the attributes of objects encrypted with a customer key without the key.
+func (b *bucketGCP) Stat(ctx context.Context, objName string, _ ...bucket.Option) (bucket.ObjectAttrs, error) {
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	defer a.Close()
+	attrs, err := a.Attrs(objName, b.bucketName)
+	if err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	return objectAttrs(attrs), nil
+}
+
+// ListVersions uses generations as version IDs, the live generation is the latest.
+func (b *bucketGCP) ListVersions(ctx context.Context, objName string) ([]bucket.ObjectVersion, error) {
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	objects, err := a.Versions(objName, b.bucketName)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Generation > objects[j].Generation })
+	versions := make([]bucket.ObjectVersion, len(objects))
+	for i, o := range objects {
+		versions[i] = bucket.ObjectVersion{
+			ObjectAttrs: objectAttrs(o),
+			VersionID:   strconv.FormatInt(o.Generation, 10),
+			IsLatest:    o.Deleted.IsZero(),
+		}
+	}
+	return versions, nil
+}
+
+func (b *bucketGCP) DownloadVersion(ctx context.Context, objName, versionID string, opts ...bucket.Option) (io.ReadCloser, error) {
+	o := bucket.NewOptions(opts...)
+	if err := o.Encryption.Validate(); err != nil {
+		return nil, err
+	}
+	generation, err := parseGeneration(versionID)
+	if err != nil {
+		return nil, err
+	}
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := a.NewVersionReader(objName, b.bucketName, generation, o.Encryption)
+	if err != nil {
+		a.Close()
+		return nil, fmt.Errorf("Object(%q).Generation(%d).NewReader: %w", objName, generation, err)
+	}
+	return bucket.Stream(o.ProgressReadCloser(rc, remain(rc)), remain(rc), a), nil
+}
+
+func (b *bucketGCP) DeleteVersion(ctx context.Context, objName, versionID string) error {
+	generation, err := parseGeneration(versionID)
+	if err != nil {
+		return err
+	}
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return a.DeleteVersion(objName, b.bucketName, generation)
+}
+
+// GetTags returns the custom metadata of the object, GCS keeps tags there.
+func (b *bucketGCP) GetTags(ctx context.Context, objName string) (map[string]string, error) {
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	attrs, err := a.Attrs(objName, b.bucketName)
+	if err != nil {
+		return nil, err
+	}
+	return attrs.Metadata, nil
+}
+
+func (b *bucketGCP) SetTags(ctx context.Context, objName string, tags map[string]string) error {
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return a.SetMetadata(objName, b.bucketName, tags)
+}
+
+// FindByTags filters the listing locally, listed objects carry their metadata so no more requests are needed.
+func (b *bucketGCP) FindByTags(ctx context.Context, filter map[string]string) ([]string, error) {
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	objects, err := a.Objects(b.bucketName, "")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, o := range objects {
+		if bucket.MatchTags(o.Metadata, filter) {
+			names = append(names, o.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// storageClasses maps the storage classes to the GCS classes.
+var storageClasses = map[bucket.StorageClass]string{
+	bucket.StorageClassHot:     "STANDARD",
+	bucket.StorageClassCool:    "NEARLINE",
+	bucket.StorageClassCold:    "COLDLINE",
+	bucket.StorageClassArchive: "ARCHIVE",
+}
+
+// gcsClass returns the GCS class of class, empty for the default class of the bucket.
+func gcsClass(class bucket.StorageClass) (string, error) {
+	if class == "" {
+		return "", nil
+	}
+	gcsClass, ok := storageClasses[class]
+	if !ok {
+		return "", bucket.ErrUnsupportedStorageClass{Class: class}
+	}
+	return gcsClass, nil
+}
+
+// storageClassOf returns the storage class of a GCS class, empty for the legacy classes.
+func storageClassOf(gcsClass string) bucket.StorageClass {
+	for class, c := range storageClasses {
+		if c == gcsClass {
+			return class
+		}
+	}
+	return ""
+}
+
+// SetStorageClass rewrites the object in the class. GCS reads archived objects directly, so there is
+// no rehydration and Stat never reports one.
+func (b *bucketGCP) SetStorageClass(ctx context.Context, objName string, class bucket.StorageClass) error {
+	gcsClass, ok := storageClasses[class]
+	if !ok {
+		return bucket.ErrUnsupportedStorageClass{Class: class}
+	}
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return a.SetStorageClass(objName, b.bucketName, gcsClass)
+}
+
+func (b *bucketGCP) GetLifecycle(ctx context.Context) ([]bucket.LifecycleRule, error) {
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer a.Close()
+	return a.Lifecycle(b.bucketName)
+}
+
+// SetLifecycle replaces the lifecycle rules of the bucket.
+func (b *bucketGCP) SetLifecycle(ctx context.Context, rules []bucket.LifecycleRule) error {
+	if err := bucket.ValidateLifecycle(rules); err != nil {
+		return err
+	}
+	a, err := b.newAdapter(ctx)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return a.SetLifecycle(b.bucketName, rules)
+}
+
+// lifecycleRules converts the rules to GCS rules, every action of a rule becomes a GCS rule.
+func lifecycleRules(rules []bucket.LifecycleRule) ([]lifecycleRule, error) {
+	var gcsRules []lifecycleRule
+	for _, r := range rules {
+		var rule lifecycleRule
+		if r.Prefix != "" {
+			rule.Condition.MatchesPrefix = []string{r.Prefix}
+		}
+		if r.TransitionAfterDays > 0 {
+			class, ok := storageClasses[r.TransitionStorageClass]
+			if !ok {
+				return nil, bucket.ErrUnsupportedStorageClass{Class: r.TransitionStorageClass}
+			}
+			transition := rule
+			transition.Action.Type = storage.SetStorageClassAction
+			transition.Action.StorageClass = class
+			transition.Condition.Age = r.TransitionAfterDays
+			gcsRules = append(gcsRules, transition)
+		}
+		if r.ExpireAfterDays > 0 {
+			expire := rule
+			expire.Action.Type = storage.DeleteAction
+			expire.Condition.Age = r.ExpireAfterDays
+			gcsRules = append(gcsRules, expire)
+		}
+	}
+	return gcsRules, nil
+}
+
+// bucketRules groups the GCS rules by prefix. GCS rules have no IDs, the prefix is used as the ID
+// and "*" for the rules without a prefix.
+func bucketRules(gcsRules []lifecycleRule) []bucket.LifecycleRule {
+	var rules []bucket.LifecycleRule
+	index := make(map[string]int)
+	for _, r := range gcsRules {
+		prefixes := r.Condition.MatchesPrefix
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+		for _, prefix := range prefixes {
+			i, ok := index[prefix]
+			if !ok {
+				id := prefix
+				if id == "" {
+					id = "*"
+				}
+				i = len(rules)
+				index[prefix] = i
+				rules = append(rules, bucket.LifecycleRule{ID: id, Prefix: prefix})
+			}
+			switch r.Action.Type {
+			case storage.DeleteAction:
+				rules[i].ExpireAfterDays = r.Condition.Age
+			case storage.SetStorageClassAction:
+				rules[i].TransitionAfterDays = r.Condition.Age
+				for class, gcsClass := range storageClasses {
+					if gcsClass == r.Action.StorageClass {
+						rules[i].TransitionStorageClass = class
+					}
+				}
+			}
+		}
+	}
+	return rules
+}
+
+func parseGeneration(versionID string) (int64, error) {
+	generation, err := strconv.ParseInt(versionID, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid generation %q: %w", versionID, err)
+	}
+	return generation, nil
+}
+
+func objectAttrs(o *storage.ObjectAttrs) bucket.ObjectAttrs {
+	return bucket.ObjectAttrs{
+		Name:         o.Name,
+		Size:         o.Size,
+		MD5:          o.MD5,
+		ETag:         o.Etag,
+		ContentType:  o.ContentType,
+		Updated:      o.Updated,
+		Encryption:   objectEncryption(o),
+		StorageClass: storageClassOf(o.StorageClass),
+	}
+}
+
+// objectEncryption reports the key of the object, GCS encrypts every object with a Google managed key otherwise.
+func objectEncryption(o *storage.ObjectAttrs) bucket.Encryption {
+	switch {
+	case o.CustomerKeySHA256 != "":
+		return bucket.Encryption{Type: bucket.EncryptionCustomerKey}
+	case o.KMSKeyName != "":
+		return bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: o.KMSKeyName}
+	default:
+		return bucket.Encryption{Type: bucket.EncryptionProviderKey}
+	}
+}
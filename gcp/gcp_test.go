@@ -2,15 +2,34 @@ package gcp
 
 import (
 	"bytes"
-	"cloud-uploader-lab/mocks/gcp"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/mocks/gcp"
 	"io"
+	"io/fs"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -62,7 +81,7 @@ func (s *Suite) TestUploadBytes() {
 	fileName := "fileName"
 	content := []byte("abc")
 	buf := &bytes.Buffer{}
-	s.adapter.On("NewWriter", fileName, s.bucket).Once().
+	s.adapter.On("NewWriter", fileName, s.bucket, storage.Conditions{}, bucket.Encryption{}, "").Once().
 		Return(NopCloser(buf), nil)
 	s.adapter.On("Close").Once().Return(nil)
 	err := s.gcp.UploadBytes(ctx, content, fileName)
@@ -75,7 +94,7 @@ func (s *Suite) TestDownloadBytes() {
 	ctx := context.Background()
 	fileName := "fileName"
 	content := []byte("abc")
-	s.adapter.On("NewReader", fileName, s.bucket).Once().
+	s.adapter.On("NewReader", fileName, s.bucket, storage.Conditions{}, bucket.Encryption{}).Once().
 		Return(io.NopCloser(bytes.NewReader(content)), nil)
 	s.adapter.On("Close").Once().Return(nil)
 	gotContent, err := s.gcp.DownloadBytes(ctx, fileName)
@@ -99,6 +118,7 @@ func (s *Suite) TestGenerateGetObjectSignedURL() {
 	fileName := "fileName"
 	url := "testurl"
 	ttl := time.Now().Add(15 * time.Minute)
+	s.gcp.signer = NewKeySigner("conf.Email", []byte("conf.PrivateKey"))
 	opts := &storage.SignedURLOptions{
 		Scheme:         storage.SigningSchemeV4,
 		Method:         http.MethodGet,
@@ -106,8 +126,6 @@ func (s *Suite) TestGenerateGetObjectSignedURL() {
 		PrivateKey:     []byte("conf.PrivateKey"),
 		Expires:        ttl,
 	}
-	s.adapter.On("OptsGen", ttl).Once().
-		Return(opts, nil)
 	s.adapter.On("SignedURL", s.bucket, fileName, opts).Once().
 		Return(url, nil)
 	s.adapter.On("Close").Once().Return(nil)
@@ -116,17 +134,122 @@ func (s *Suite) TestGenerateGetObjectSignedURL() {
 	s.NoError(err)
 }
 
+func (s *Suite) TestGenerateSignedURLWithoutSigner() {
+	_, err := s.gcp.GenerateGetObjectSignedURL(context.Background(), "fileName", time.Now().Add(time.Minute))
+	s.ErrorAs(err, &ErrNoSigner{})
+
+	s.T().Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(s.T().TempDir(), "missing.json"))
+	s.gcp.signer, s.gcp.signerErr = defaultSigner()
+	_, err = s.gcp.GeneratePutObjectSignedURL(context.Background(), "fileName", time.Now().Add(time.Minute))
+	s.ErrorAs(err, &ErrNoSigner{})
+	s.ErrorIs(err, fs.ErrNotExist)
+}
+
+func (s *Suite) TestSigners() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	s.Require().NoError(err)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	jsonKey, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "uploader@test-obj-store.iam.gserviceaccount.com",
+		"private_key":  string(pemKey),
+	})
+	s.Require().NoError(err)
+	keyFile := filepath.Join(s.T().TempDir(), "key.json")
+	s.Require().NoError(os.WriteFile(keyFile, jsonKey, 0o600))
+	fileSigner, err := NewKeyFileSigner(keyFile)
+	s.Require().NoError(err)
+
+	var signed [][]byte
+	iamSigner := NewSignBytesSigner("uploader@test-obj-store.iam.gserviceaccount.com", func(b []byte) ([]byte, error) {
+		signed = append(signed, b)
+		digest := sha256.Sum256(b)
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	})
+
+	ttl := time.Now().Add(time.Hour)
+	for name, signer := range map[string]Signer{"file": fileSigner, "sign bytes": iamSigner} {
+		b := newBucket(nil, s.bucket)
+		b.signer = signer
+		u, err := b.GenerateSignedURL(context.Background(), "dir/file name", ttl, URLOptions{
+			Method:          http.MethodPut,
+			ContentType:     "text/plain",
+			Headers:         []string{"x-goog-meta-tenant:acme"},
+			QueryParameters: url.Values{"uploadType": {"media"}},
+		})
+		s.Require().NoError(err, name)
+		parsed, err := url.Parse(u)
+		s.Require().NoError(err, name)
+		s.Equal("/bucket/dir/file%20name", parsed.EscapedPath(), name)
+		q := parsed.Query()
+		s.Equal("media", q.Get("uploadType"), name)
+		s.Equal("GOOG4-RSA-SHA256", q.Get("X-Goog-Algorithm"), name)
+		s.Contains(q.Get("X-Goog-Credential"), "uploader@test-obj-store.iam.gserviceaccount.com", name)
+		s.Equal("content-type;host;x-goog-meta-tenant", q.Get("X-Goog-SignedHeaders"), name)
+		s.NotEmpty(q.Get("X-Goog-Signature"), name)
+	}
+	s.Require().Len(signed, 1)
+	s.True(strings.HasPrefix(string(signed[0]), "GOOG4-RSA-SHA256\n"))
+}
+
 func (s *Suite) TestDownloadByChunks() {
 	ctx := context.Background()
 	fileName := "fileName"
-	stringReader := strings.NewReader("abc")
-	stringReadCloser := io.NopCloser(stringReader)
-	s.adapter.On("NewReader", fileName, s.bucket).Once().
-		Return(stringReadCloser, nil)
+	s.adapter.On("NewReader", fileName, s.bucket, storage.Conditions{}, bucket.Encryption{}).Once().
+		Return(io.NopCloser(strings.NewReader("abc")), nil)
 	s.adapter.On("Close").Once().Return(nil)
 	gotContent, err := s.gcp.DownloadByChunks(ctx, fileName)
-	s.Equal(stringReadCloser, gotContent)
+	s.Require().NoError(err)
+
+	data, err := io.ReadAll(gotContent)
 	s.NoError(err)
+	s.Equal("abc", string(data))
+	s.adapter.AssertNotCalled(s.T(), "Close")
+
+	s.NoError(gotContent.Close())
+	s.adapter.AssertNumberOfCalls(s.T(), "Close", 1)
+}
+
+// TestDownloadByChunksAfterReturn reads a large object slowly from a server once DownloadByChunks returned,
+// the stream keeps the client of the call alive. A body cut short fails the read.
+func (s *Suite) TestDownloadByChunksAfterReturn() {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4<<20/16)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if strings.HasSuffix(r.URL.Path, "/truncated") {
+			_, _ = w.Write(data[:len(data)/2])
+			panic(http.ErrAbortHandler)
+		}
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	s.Require().NoError(err)
+	g := newBucket(client, s.bucket)
+	defer g.Close()
+
+	rc, err := g.DownloadByChunks(ctx, "object")
+	s.Require().NoError(err)
+	var got []byte
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := rc.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		s.Require().NoError(err)
+		time.Sleep(100 * time.Microsecond)
+	}
+	s.NoError(rc.Close())
+	s.Equal(data, got)
+
+	rc, err = g.DownloadByChunks(ctx, "truncated")
+	s.Require().NoError(err)
+	_, err = io.ReadAll(rc)
+	s.Error(err)
+	s.NoError(rc.Close())
 }
 
 func (s *Suite) TestUploadByChunks() {
@@ -134,9 +257,492 @@ func (s *Suite) TestUploadByChunks() {
 	fileName := "fileName"
 	content := strings.NewReader("abc")
 	buf := &bytes.Buffer{}
-	s.adapter.On("NewWriter", fileName, s.bucket).Once().
+	s.adapter.On("NewWriter", fileName, s.bucket, storage.Conditions{}, bucket.Encryption{}, "").Once().
 		Return(NopWriteCloser(buf), nil)
 	s.adapter.On("Close").Once().Return(nil)
 	err := s.gcp.UploadByChunks(ctx, content, fileName)
 	s.NoError(err)
 }
+
+func (s *Suite) TestNewWriter() {
+	ctx := context.Background()
+	fileName := "fileName"
+	buf := &bytes.Buffer{}
+	s.adapter.On("NewWriter", fileName, s.bucket, storage.Conditions{}, bucket.Encryption{}, "").Once().
+		Return(NopWriteCloser(buf))
+	s.adapter.On("Close").Once().Return(nil)
+	w := s.gcp.NewWriter(ctx, fileName)
+	_, err := io.WriteString(w, "abc")
+	s.NoError(err)
+	s.NoError(w.Close())
+	s.Equal("abc", buf.String())
+	s.adapter.AssertExpectations(s.T())
+
+	rejected := &googleapi.Error{Code: http.StatusPreconditionFailed}
+	s.adapter.On("NewWriter", fileName, s.bucket, storage.Conditions{DoesNotExist: true}, bucket.Encryption{}, "").Once().
+		Return(errWriteCloser{Writer: io.Discard, err: rejected})
+	s.adapter.On("Close").Once().Return(nil)
+	w = s.gcp.NewWriter(ctx, fileName, bucket.IfNoneMatch(bucket.ETagAny))
+	s.ErrorIs(w.Close(), bucket.ErrPreconditionFailed{})
+
+	w = s.gcp.NewWriter(ctx, fileName, bucket.WithStorageClass("frozen"))
+	s.ErrorAs(w.Close(), &bucket.ErrUnsupportedStorageClass{})
+	s.ErrorAs(s.gcp.NewWriter(ctx, fileName, bucket.WithTTL(time.Hour)).Close(), &bucket.ErrUnsupportedTTL{})
+	s.ErrorAs(s.gcp.UploadBytes(ctx, []byte("abc"), fileName, bucket.WithTTL(time.Hour)), &bucket.ErrUnsupportedTTL{})
+}
+
+// TestNewWriterAbort checks that CloseWithError cancels the context the upload runs with.
+func (s *Suite) TestNewWriterAbort() {
+	ctx := context.Background()
+	fileName := "fileName"
+	var uploadCtx context.Context
+	s.gcp.newAdapter = func(ctx context.Context) (adapterInterface, error) {
+		uploadCtx = ctx
+		return &s.adapter, nil
+	}
+	s.adapter.On("NewWriter", fileName, s.bucket, storage.Conditions{}, bucket.Encryption{}, "").Once().
+		Return(NopWriteCloser(io.Discard))
+	s.adapter.On("Close").Once().Return(nil)
+	w := s.gcp.NewWriter(ctx, fileName)
+	_, err := io.WriteString(w, "abc")
+	s.NoError(err)
+	s.NoError(uploadCtx.Err())
+	s.NoError(w.CloseWithError(errors.New("encoder failed")))
+	s.ErrorIs(uploadCtx.Err(), context.Canceled)
+	s.EqualError(w.Close(), "encoder failed")
+	s.adapter.AssertExpectations(s.T())
+}
+
+func (s *Suite) TestList() {
+	ctx := context.Background()
+	prefix := "dir/"
+	updated := time.Now()
+	s.adapter.On("Objects", s.bucket, prefix).Once().
+		Return([]*storage.ObjectAttrs{{Name: "dir/a", Size: 3, MD5: []byte("md5"), Etag: "etag", Updated: updated}}, nil)
+	s.adapter.On("Close").Once().Return(nil)
+	list, err := s.gcp.List(ctx, prefix)
+	s.Equal([]bucket.ObjectAttrs{{Name: "dir/a", Size: 3, MD5: []byte("md5"), ETag: "etag", Updated: updated,
+		Encryption: bucket.Encryption{Type: bucket.EncryptionProviderKey}}}, list)
+	s.NoError(err)
+}
+
+func (s *Suite) TestStat() {
+	ctx := context.Background()
+	fileName := "fileName"
+	updated := time.Now()
+	s.adapter.On("Attrs", fileName, s.bucket).Once().
+		Return(&storage.ObjectAttrs{Name: fileName, Size: 3, ContentType: "text/plain", Updated: updated, KMSKeyName: "projects/p/locations/eu/keyRings/r/cryptoKeys/k"}, nil)
+	s.adapter.On("Close").Once().Return(nil)
+	attrs, err := s.gcp.Stat(ctx, fileName)
+	s.Equal(bucket.ObjectAttrs{Name: fileName, Size: 3, ContentType: "text/plain", Updated: updated,
+		Encryption: bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: "projects/p/locations/eu/keyRings/r/cryptoKeys/k"}}, attrs)
+	s.NoError(err)
+}
+
+// TestEncryption checks the headers of customer keys and the KMS key of uploads against a stand-in server.
+func (s *Suite) TestEncryption() {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte("k"), 32)
+	keySHA256 := sha256.Sum256(key)
+	var headers []http.Header
+	var uploads []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		if r.Method == http.MethodPost {
+			uploads = append(uploads, r.URL.Query().Get("kmsKeyName"))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"bucket":"bucket","name":"object","size":"3"}`)
+			return
+		}
+		w.Header().Set("Content-Length", "3")
+		_, _ = io.WriteString(w, "abc")
+	}))
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	s.Require().NoError(err)
+	g := newBucket(client, s.bucket)
+	defer g.Close()
+
+	s.Require().NoError(g.UploadBytes(ctx, []byte("abc"), "object", bucket.WithCustomerKey(key)))
+	s.Equal("AES256", headers[0].Get("X-Goog-Encryption-Algorithm"))
+	s.Equal(base64.StdEncoding.EncodeToString(key), headers[0].Get("X-Goog-Encryption-Key"))
+	s.Equal(base64.StdEncoding.EncodeToString(keySHA256[:]), headers[0].Get("X-Goog-Encryption-Key-Sha256"))
+
+	data, err := g.DownloadBytes(ctx, "object", bucket.WithCustomerKey(key))
+	s.Require().NoError(err)
+	s.Equal("abc", string(data))
+	s.Equal(base64.StdEncoding.EncodeToString(key), headers[1].Get("X-Goog-Encryption-Key"))
+
+	s.Require().NoError(g.UploadBytes(ctx, []byte("abc"), "object", bucket.WithKMSKey("projects/p/locations/eu/keyRings/r/cryptoKeys/k")))
+	s.Equal([]string{"", "projects/p/locations/eu/keyRings/r/cryptoKeys/k"}, uploads)
+	s.Empty(headers[2].Get("X-Goog-Encryption-Key"))
+
+	s.ErrorAs(g.UploadBytes(ctx, []byte("abc"), "object", bucket.WithCustomerKey(key[:16])), &bucket.ErrUnsupportedEncryption{})
+	s.Len(headers, 3)
+}
+
+func (s *Suite) TestStorageClass() {
+	ctx := context.Background()
+	var bodies []string
+	var rewrite url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(r.URL.Path, "/rewriteTo/"):
+			rewrite = r.URL.Query()
+			_, _ = io.WriteString(w, `{"done":true,"resource":{"bucket":"bucket","name":"object","storageClass":"COLDLINE"}}`)
+		case r.Method == http.MethodGet:
+			_, _ = io.WriteString(w, `{"bucket":"bucket","name":"object","generation":"7","contentType":"text/csv",`+
+				`"metadata":{"tenant":"acme"},"storageClass":"STANDARD",`+
+				`"kmsKeyName":"projects/p/locations/eu/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"}`)
+		default:
+			_, _ = io.WriteString(w, `{"bucket":"bucket","name":"object","size":"3"}`)
+		}
+	}))
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	s.Require().NoError(err)
+	g := newBucket(client, s.bucket)
+	defer g.Close()
+
+	s.Require().NoError(g.UploadBytes(ctx, []byte("abc"), "object", bucket.WithStorageClass(bucket.StorageClassArchive)))
+	s.Contains(bodies[0], `"storageClass":"ARCHIVE"`)
+
+	attrs, err := g.Stat(ctx, "object")
+	s.Require().NoError(err)
+	s.Equal(bucket.StorageClassHot, attrs.StorageClass)
+	s.Nil(attrs.Rehydration)
+
+	s.Require().NoError(g.SetStorageClass(ctx, "object", bucket.StorageClassCold))
+	s.Equal("7", rewrite.Get("ifGenerationMatch"))
+	s.Equal("7", rewrite.Get("sourceGeneration"))
+	s.Equal("projects/p/locations/eu/keyRings/r/cryptoKeys/k", rewrite.Get("destinationKmsKeyName"))
+	s.Contains(bodies[len(bodies)-1], `"storageClass":"COLDLINE"`)
+	s.Contains(bodies[len(bodies)-1], `"contentType":"text/csv"`)
+	s.Contains(bodies[len(bodies)-1], `"tenant":"acme"`)
+
+	// the object is already in the class
+	requests := len(bodies)
+	s.NoError(g.SetStorageClass(ctx, "object", bucket.StorageClassHot))
+	s.Len(bodies, requests+1)
+
+	s.ErrorAs(g.SetStorageClass(ctx, "object", ""), &bucket.ErrUnsupportedStorageClass{})
+	s.ErrorAs(g.UploadBytes(ctx, []byte("abc"), "object", bucket.WithStorageClass("frozen")), &bucket.ErrUnsupportedStorageClass{})
+	s.Len(bodies, requests+1)
+}
+
+func (s *Suite) TestDeleteMany() {
+	ctx := context.Background()
+	e := errors.New("error")
+	s.adapter.On("Delete", "a", s.bucket).Once().Return(nil)
+	s.adapter.On("Delete", "b", s.bucket).Once().Return(e)
+	s.adapter.On("Close").Once().Return(nil)
+
+	err := s.gcp.DeleteMany(ctx, []string{"a", "b"})
+	s.Equal(bucket.ErrDeleteFailed{Errors: map[string]error{"b": e}}, err)
+}
+
+// errWriteCloser fails Close like storage.Writer does when the upload is rejected.
+type errWriteCloser struct {
+	io.Writer
+	err error
+}
+
+func (w errWriteCloser) Close() error { return w.err }
+
+func (s *Suite) TestUploadIfNoneMatchAny() {
+	ctx := context.Background()
+	fileName := "fileName"
+	rejected := &googleapi.Error{Code: http.StatusPreconditionFailed}
+
+	s.adapter.On("NewWriter", fileName, s.bucket, storage.Conditions{DoesNotExist: true}, bucket.Encryption{}, "").Once().
+		Return(errWriteCloser{Writer: io.Discard, err: rejected})
+	s.adapter.On("Close").Once().Return(nil)
+
+	err := s.gcp.UploadBytes(ctx, []byte("abc"), fileName, bucket.IfNoneMatch(bucket.ETagAny))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+}
+
+func (s *Suite) TestUploadIfMatch() {
+	ctx := context.Background()
+	fileName := "fileName"
+	var buf bytes.Buffer
+
+	s.adapter.On("Attrs", fileName, s.bucket).Twice().
+		Return(&storage.ObjectAttrs{Name: fileName, Etag: "CLjw", Generation: 42}, nil)
+	s.adapter.On("NewWriter", fileName, s.bucket, storage.Conditions{GenerationMatch: 42}, bucket.Encryption{}, "").Once().
+		Return(NopWriteCloser(&buf))
+	s.adapter.On("Close").Twice().Return(nil)
+
+	s.NoError(s.gcp.UploadBytes(ctx, []byte("abc"), fileName, bucket.IfMatch("CLjw")))
+	s.Equal("abc", buf.String())
+
+	err := s.gcp.UploadBytes(ctx, []byte("abc"), fileName, bucket.IfMatch("CKjw"))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+	s.adapter.AssertExpectations(s.T())
+}
+
+func (s *Suite) TestDownloadIfModifiedSince() {
+	ctx := context.Background()
+	fileName := "fileName"
+	updated := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	s.adapter.On("Attrs", fileName, s.bucket).Once().
+		Return(&storage.ObjectAttrs{Name: fileName, Updated: updated, Generation: 42}, nil)
+	s.adapter.On("Close").Once().Return(nil)
+
+	_, err := s.gcp.DownloadBytes(ctx, fileName, bucket.IfModifiedSince(updated))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+}
+
+func (s *Suite) TestListVersions() {
+	ctx := context.Background()
+	fileName := "fileName"
+
+	s.adapter.On("Versions", fileName, s.bucket).Once().Return([]*storage.ObjectAttrs{
+		{Name: fileName, Generation: 1, Deleted: time.Now()},
+		{Name: fileName, Generation: 3},
+		{Name: fileName, Generation: 2, Deleted: time.Now()},
+	}, nil)
+	s.adapter.On("Close").Once().Return(nil)
+
+	versions, err := s.gcp.ListVersions(ctx, fileName)
+	s.NoError(err)
+	s.Require().Len(versions, 3)
+	s.Equal("3", versions[0].VersionID)
+	s.True(versions[0].IsLatest)
+	s.Equal("2", versions[1].VersionID)
+	s.False(versions[1].IsLatest)
+	s.Equal("1", versions[2].VersionID)
+}
+
+func (s *Suite) TestDownloadVersion() {
+	ctx := context.Background()
+	fileName := "fileName"
+
+	s.adapter.On("NewVersionReader", fileName, s.bucket, int64(2), bucket.Encryption{}).Once().
+		Return(io.NopCloser(strings.NewReader("abc")), nil)
+	s.adapter.On("Close").Once().Return(nil)
+
+	rc, err := s.gcp.DownloadVersion(ctx, fileName, "2")
+	s.Require().NoError(err)
+	data, err := io.ReadAll(rc)
+	s.NoError(err)
+	s.Equal("abc", string(data))
+
+	_, err = s.gcp.DownloadVersion(ctx, fileName, "latest")
+	s.Error(err)
+}
+
+func (s *Suite) TestFindByTags() {
+	ctx := context.Background()
+
+	s.adapter.On("Objects", s.bucket, "").Once().Return([]*storage.ObjectAttrs{
+		{Name: "b", Metadata: map[string]string{"tenant": "acme", "class": "gold"}},
+		{Name: "a", Metadata: map[string]string{"tenant": "acme"}},
+		{Name: "c"},
+	}, nil)
+	s.adapter.On("Close").Once().Return(nil)
+
+	names, err := s.gcp.FindByTags(ctx, map[string]string{"tenant": "acme"})
+	s.NoError(err)
+	s.Equal([]string{"a", "b"}, names)
+}
+
+// TestSetMetadata reads the metadata once and replaces it by one patch conditioned on the metageneration,
+// the removed keys are null as the JSON API keeps keys with empty values.
+func (s *Suite) TestSetMetadata() {
+	ctx := context.Background()
+	var patches []map[string]map[string]*string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPatch {
+			s.Equal("/storage/v1/b/bucket/o/dir%2Fobject", r.URL.EscapedPath())
+			s.Equal("3", r.URL.Query().Get("ifMetagenerationMatch"))
+			var patch map[string]map[string]*string
+			s.Require().NoError(json.NewDecoder(r.Body).Decode(&patch))
+			patches = append(patches, patch)
+		}
+		_, _ = io.WriteString(w, `{"bucket":"bucket","name":"dir/object","metageneration":"3","metadata":{"a":"1","b":"2"}}`)
+	}))
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	s.Require().NoError(err)
+	defer client.Close()
+	a := &adapter{client: client, httpClient: srv.Client(), endpoint: srv.URL + "/storage/v1/", ctx: ctx}
+
+	s.Require().NoError(a.SetMetadata("dir/object", "bucket", map[string]string{"b": "3", "c": "4"}))
+	s.Require().NoError(a.SetMetadata("dir/object", "bucket", nil))
+	s.Require().Len(patches, 2)
+	three, four := "3", "4"
+	s.Equal(map[string]*string{"a": nil, "b": &three, "c": &four}, patches[0]["metadata"])
+	s.Equal(map[string]*string{"a": nil, "b": nil}, patches[1]["metadata"])
+}
+
+func (s *Suite) TestSetLifecycle() {
+	ctx := context.Background()
+	rules := []bucket.LifecycleRule{{ID: "tmp", Prefix: "tmp/", ExpireAfterDays: 7}}
+
+	s.adapter.On("SetLifecycle", s.bucket, rules).Once().Return(nil)
+	s.adapter.On("Close").Once().Return(nil)
+
+	s.NoError(s.gcp.SetLifecycle(ctx, rules))
+	s.Error(s.gcp.SetLifecycle(ctx, []bucket.LifecycleRule{{ID: "none", Prefix: "tmp/"}}))
+}
+
+// TestLifecycleRequests sends the lifecycle requests with the HTTP client of the bucket to its endpoint.
+func (s *Suite) TestLifecycleRequests() {
+	ctx := context.Background()
+	var patched bucketLifecycle
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Equal("/storage/v1/b/"+s.bucket, r.URL.Path)
+		s.Equal("lifecycle", r.URL.Query().Get("fields"))
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPatch {
+			s.Require().NoError(json.NewDecoder(r.Body).Decode(&patched))
+		}
+		_, _ = io.WriteString(w, `{"lifecycle":{"rule":[{"action":{"type":"Delete"},"condition":{"age":7,"matchesPrefix":["tmp/"]}}]}}`)
+	}))
+	defer srv.Close()
+	a := &adapter{httpClient: srv.Client(), endpoint: srv.URL + "/storage/v1/", ctx: ctx}
+
+	rules, err := a.Lifecycle(s.bucket)
+	s.Require().NoError(err)
+	s.Equal([]bucket.LifecycleRule{{ID: "tmp/", Prefix: "tmp/", ExpireAfterDays: 7}}, rules)
+
+	s.Require().NoError(a.SetLifecycle(s.bucket, rules))
+	s.Require().Len(patched.Lifecycle.Rule, 1)
+	s.Equal(storage.DeleteAction, patched.Lifecycle.Rule[0].Action.Type)
+
+	_, err = (&adapter{ctx: ctx}).Lifecycle(s.bucket)
+	s.Error(err)
+}
+
+func (s *Suite) TestLifecycleRules() {
+	rules := []bucket.LifecycleRule{
+		{ID: "tmp/", Prefix: "tmp/", ExpireAfterDays: 30, TransitionAfterDays: 7, TransitionStorageClass: bucket.StorageClassCold},
+		{ID: "*", ExpireAfterDays: 365},
+	}
+
+	gcsRules, err := lifecycleRules(rules)
+	s.Require().NoError(err)
+	s.Require().Len(gcsRules, 3)
+	s.Equal(storage.SetStorageClassAction, gcsRules[0].Action.Type)
+	s.Equal("COLDLINE", gcsRules[0].Action.StorageClass)
+	s.Equal(7, gcsRules[0].Condition.Age)
+	s.Equal([]string{"tmp/"}, gcsRules[0].Condition.MatchesPrefix)
+	s.Equal(storage.DeleteAction, gcsRules[1].Action.Type)
+	s.Equal(30, gcsRules[1].Condition.Age)
+	s.Empty(gcsRules[2].Condition.MatchesPrefix)
+
+	s.Equal(rules, bucketRules(gcsRules))
+}
+
+func (s *Suite) TestAdmin() {
+	ctx := context.Background()
+	var created []storage.BucketAttrs
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b":
+			s.Equal(projectID, r.URL.Query().Get("project"))
+			_, _ = io.WriteString(w, `{"items":[{"name":"b"},{"name":"a"}]}`)
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/existing":
+			_, _ = io.WriteString(w, `{"name":"existing"}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/storage/v1/b":
+			var attrs struct{ Name, Location, StorageClass string }
+			s.Require().NoError(json.NewDecoder(r.Body).Decode(&attrs))
+			if attrs.Name == "existing" {
+				w.WriteHeader(http.StatusConflict)
+				_, _ = io.WriteString(w, `{"error":{"code":409,"message":"conflict"}}`)
+				return
+			}
+			created = append(created, storage.BucketAttrs{Name: attrs.Name, Location: attrs.Location, StorageClass: attrs.StorageClass})
+			_, _ = io.WriteString(w, `{"name":"`+attrs.Name+`"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error":{"code":404,"message":"not found"}}`)
+		}
+	}))
+	defer srv.Close()
+	client, err := storage.NewClient(ctx, option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	s.Require().NoError(err)
+	admin := &Admin{client: client}
+	defer admin.Close()
+
+	exists, err := admin.BucketExists(ctx, "existing")
+	s.NoError(err)
+	s.True(exists)
+	exists, err = admin.BucketExists(ctx, "missing")
+	s.NoError(err)
+	s.False(exists)
+
+	s.NoError(admin.CreateBucket(ctx, "missing", bucket.WithLocation("EU"), bucket.WithDefaultStorageClass(bucket.StorageClassCold)))
+	s.Equal([]storage.BucketAttrs{{Name: "missing", Location: "EU", StorageClass: "COLDLINE"}}, created)
+	s.Equal(bucket.ErrBucketExists{Bucket: "existing"}, admin.CreateBucket(ctx, "existing"))
+	s.Equal(bucket.ErrUnsupportedStorageClass{Class: "warm"}, admin.CreateBucket(ctx, "missing", bucket.WithDefaultStorageClass("warm")))
+	s.Equal(bucket.ErrUnsupportedPublicRead{Bucket: "missing"}, admin.CreateBucket(ctx, "missing", bucket.WithPublicRead()))
+	s.Equal(bucket.ErrBucketNotFound{Bucket: "missing"}, admin.DeleteBucket(ctx, "missing"))
+
+	names, err := admin.ListBuckets(ctx)
+	s.NoError(err)
+	s.Equal([]string{"a", "b"}, names)
+
+	s.Equal(bucket.ErrBucketNotFound{Bucket: "missing"}, bucket.EnsureBucket(ctx, admin, "missing", false))
+	s.NoError(bucket.EnsureBucket(ctx, admin, "missing", true))
+	s.Len(created, 2)
+}
+
+// BenchmarkStat compares a client created for every call, as buckets did before they shared one, with the
+// shared client of the bucket. The server speaks TLS and checks the token, so every new client sets up its
+// transport, authorizes and dials a new TLS connection. The lookup of the default credentials is left out,
+// the clients get a static token source. The server answers every request with the same object.
+func BenchmarkStat(b *testing.B) {
+	ctx := context.Background()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"bucket":"bucket","name":"object","size":"3"}`)
+	}))
+	defer srv.Close()
+	// the transports of the clients are cloned from the default one, which has to trust the server
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = srv.Client().Transport
+	defer func() { http.DefaultTransport = defaultTransport }()
+	opts := []option.ClientOption{
+		option.WithEndpoint(srv.URL + "/storage/v1/"),
+		option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})),
+	}
+
+	b.Run("client per call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			client, err := storage.NewClient(ctx, opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := newBucket(client, "bucket").Stat(ctx, "object"); err != nil {
+				b.Fatal(err)
+			}
+			client.Close()
+		}
+	})
+
+	b.Run("shared client", func(b *testing.B) {
+		client, err := storage.NewClient(ctx, opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		g := newBucket(client, "bucket")
+		defer g.Close()
+		for i := 0; i < b.N; i++ {
+			if _, err := g.Stat(ctx, "object"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
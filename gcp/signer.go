@@ -0,0 +1,139 @@
+package gcp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2/google"
+)
+
+type ErrNoSigner struct {
+	Err error
+}
+
+func (e ErrNoSigner) Error() string {
+	return fmt.Sprintf("signed URLs need a signer, pass WithSigner or set GOOGLE_APPLICATION_CREDENTIALS to a service account key: %v", e.Err)
+}
+
+func (e ErrNoSigner) Unwrap() error {
+	return e.Err
+}
+
+// Option configures OpenBucket, by default URLs are signed with the key file of GOOGLE_APPLICATION_CREDENTIALS.
+type Option func(*options)
+
+type options struct {
+	signer     Signer
+	create     bool
+	createOpts []bucket.CreateOption
+}
+
+// WithCreate makes OpenBucket create the bucket with opts when it does not exist.
+func WithCreate(opts ...bucket.CreateOption) Option {
+	return func(o *options) {
+		o.create = true
+		o.createOpts = opts
+	}
+}
+
+// WithSigner signs URLs of the bucket with signer instead of the key file of GOOGLE_APPLICATION_CREDENTIALS.
+func WithSigner(signer Signer) Option {
+	return func(o *options) {
+		o.signer = signer
+	}
+}
+
+// Signer provides the identity signed URLs are issued for and the means to sign them.
+type Signer interface {
+	// SigningOptions returns options with GoogleAccessID and either PrivateKey or SignBytes set.
+	SigningOptions() storage.SignedURLOptions
+}
+
+type keySigner struct {
+	googleAccessID string
+	privateKey     []byte
+}
+
+// NewKeySigner signs with the PEM private key of the service account googleAccessID.
+func NewKeySigner(googleAccessID string, privateKey []byte) Signer {
+	return keySigner{googleAccessID: googleAccessID, privateKey: privateKey}
+}
+
+// NewKeyFileSigner reads the JSON key of a service account once.
+func NewKeyFileSigner(path string) (Signer, error) {
+	jsonKey, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile: %w", err)
+	}
+	conf, err := google.JWTConfigFromJSON(jsonKey)
+	if err != nil {
+		return nil, fmt.Errorf("google.JWTConfigFromJSON: %w", err)
+	}
+	return NewKeySigner(conf.Email, conf.PrivateKey), nil
+}
+
+func (s keySigner) SigningOptions() storage.SignedURLOptions {
+	return storage.SignedURLOptions{GoogleAccessID: s.googleAccessID, PrivateKey: s.privateKey}
+}
+
+type signBytesSigner struct {
+	googleAccessID string
+	signBytes      func([]byte) ([]byte, error)
+}
+
+// NewSignBytesSigner signs through a callback, e.g. the signBlob method of the IAM credentials API, so no key
+// of googleAccessID has to be available.
+func NewSignBytesSigner(googleAccessID string, signBytes func([]byte) ([]byte, error)) Signer {
+	return signBytesSigner{googleAccessID: googleAccessID, signBytes: signBytes}
+}
+
+func (s signBytesSigner) SigningOptions() storage.SignedURLOptions {
+	return storage.SignedURLOptions{GoogleAccessID: s.googleAccessID, SignBytes: s.signBytes}
+}
+
+// defaultSigner reads the key file of GOOGLE_APPLICATION_CREDENTIALS, the error is returned by the signing
+// calls so buckets authorized otherwise still open.
+func defaultSigner() (Signer, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		return nil, ErrNoSigner{Err: fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS is not set")}
+	}
+	signer, err := NewKeyFileSigner(path)
+	if err != nil {
+		return nil, ErrNoSigner{Err: err}
+	}
+	return signer, nil
+}
+
+// URLOptions describe the request a signed URL is valid for, the zero value is a GET of the object.
+type URLOptions struct {
+	// Method is the HTTP method, GET by default.
+	Method string
+	// ContentType has to be sent by the request when it is set.
+	ContentType string
+	// Headers are canonical headers the request has to send, "x-goog-meta-tenant:acme" for example.
+	Headers []string
+	// QueryParameters are signed and added to the URL.
+	QueryParameters url.Values
+}
+
+func (o URLOptions) signedURLOptions(signer Signer, expires time.Time) *storage.SignedURLOptions {
+	opts := signer.SigningOptions()
+	opts.Scheme = storage.SigningSchemeV4
+	opts.Method = o.Method
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+	opts.Expires = expires
+	opts.ContentType = o.ContentType
+	opts.Headers = o.Headers
+	opts.QueryParameters = o.QueryParameters
+	return &opts
+}
@@ -0,0 +1,466 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tmpPrefix marks files that are still being written, they are skipped by List.
+const tmpPrefix = ".upload-"
+
+// tagsPrefix marks the files holding the tags of the object named by the rest of the file name,
+// they are skipped by List.
+const tagsPrefix = ".tags-"
+
+// renameMu makes the precondition check and the rename of a conditional upload atomic within the process,
+// other processes writing to the directory are not excluded.
+var renameMu sync.Mutex
+
+type ErrInvalidName struct {
+	Name string
+}
+
+func (e ErrInvalidName) Error() string {
+	return fmt.Sprintf("object name %q points outside of the bucket directory", e.Name)
+}
+
+type bucketLocal struct {
+	dir string
+}
+
+var _ bucket.Bucket = (*bucketLocal)(nil)
+
+// OpenBucket uses dir as the bucket, object names are slash separated paths relative to it.
+// The directory is created if it does not exist.
+func OpenBucket(_ context.Context, dir string) (*bucketLocal, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("filepath.Abs: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %w", err)
+	}
+	return &bucketLocal{dir: dir}, nil
+}
+
+func (b *bucketLocal) path(objName string) (string, error) {
+	p := filepath.Join(b.dir, filepath.FromSlash(objName))
+	if !strings.HasPrefix(p, b.dir+string(filepath.Separator)) {
+		return "", ErrInvalidName{Name: objName}
+	}
+	return p, nil
+}
+
+func (b *bucketLocal) Delete(_ context.Context, objName string) error {
+	p, err := b.path(objName)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil {
+		return fmt.Errorf("os.Remove: %w", err)
+	}
+	os.Remove(tagsPath(p))
+	return nil
+}
+
+func (b *bucketLocal) DeleteMany(ctx context.Context, objNames []string) error {
+	return bucket.DeleteEach(ctx, objNames, b.Delete)
+}
+
+func (b *bucketLocal) DeletePrefix(ctx context.Context, prefix string) error {
+	var names []string
+	err := b.walk(ctx, prefix, func(name, _ string) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return b.DeleteMany(ctx, names)
+}
+
+func (b *bucketLocal) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string, opts ...bucket.Option) error {
+	return b.UploadByChunks(ctx, bytes.NewReader(fileAsBytes), objName, opts...)
+}
+
+// UploadByChunks writes into a temporary file next to the target and renames it on success,
+// so readers never observe a partially written object. Encryption and storage class options are ignored.
+func (b *bucketLocal) UploadByChunks(_ context.Context, fileAsRead io.Reader, objName string, opts ...bucket.Option) error {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.ErrUnsupportedTTL{TTL: o.TTL}
+	}
+	p, err := b.path(objName)
+	if err != nil {
+		return err
+	}
+	f, err := createTemp(p)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, o.ProgressReader(fileAsRead, -1)); err != nil {
+		f.Close()
+		return fmt.Errorf("io.Copy: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("File.Close: %w", err)
+	}
+	return rename(f.Name(), p, o)
+}
+
+// NewWriter writes to a temporary file next to the object, Close renames it to the object and CloseWithError
+// removes it.
+func (b *bucketLocal) NewWriter(_ context.Context, objName string, opts ...bucket.Option) bucket.Writer {
+	o := bucket.NewOptions(opts...)
+	if o.TTL > 0 {
+		return bucket.FailedWriter(bucket.ErrUnsupportedTTL{TTL: o.TTL})
+	}
+	p, err := b.path(objName)
+	if err != nil {
+		return bucket.FailedWriter(err)
+	}
+	f, err := createTemp(p)
+	if err != nil {
+		return bucket.FailedWriter(err)
+	}
+	return bucket.NewUploadWriter(&fileUpload{f: f, p: p, o: o}, o)
+}
+
+// fileUpload is the temporary file of NewWriter.
+type fileUpload struct {
+	f *os.File
+	p string
+	o bucket.Options
+}
+
+func (u *fileUpload) Write(p []byte) (int, error) {
+	return u.f.Write(p)
+}
+
+func (u *fileUpload) Commit() error {
+	defer os.Remove(u.f.Name())
+	if err := u.f.Close(); err != nil {
+		return fmt.Errorf("File.Close: %w", err)
+	}
+	return rename(u.f.Name(), u.p, u.o)
+}
+
+func (u *fileUpload) Abort() error {
+	u.f.Close()
+	return os.Remove(u.f.Name())
+}
+
+// createTemp creates the temporary file of an upload to p in the directory of p.
+func createTemp(p string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), tmpPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("os.CreateTemp: %w", err)
+	}
+	return f, nil
+}
+
+// rename replaces the object at p with the uploaded file if the preconditions of o are satisfied.
+func rename(tmp, p string, o bucket.Options) error {
+	renameMu.Lock()
+	defer renameMu.Unlock()
+	if o.Conditional() {
+		current, err := currentAttrs(p)
+		if err != nil {
+			return err
+		}
+		if err := o.CheckUpload(current); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		return fmt.Errorf("os.Rename: %w", err)
+	}
+	return nil
+}
+
+func (b *bucketLocal) DownloadBytes(ctx context.Context, objName string, opts ...bucket.Option) ([]byte, error) {
+	rc, err := b.DownloadByChunks(ctx, objName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll: %w", err)
+	}
+	return data, nil
+}
+
+func (b *bucketLocal) DownloadByChunks(_ context.Context, objName string, opts ...bucket.Option) (io.ReadCloser, error) {
+	o := bucket.NewOptions(opts...)
+	p, err := b.path(objName)
+	if err != nil {
+		return nil, err
+	}
+	if o.Conditional() {
+		current, err := currentAttrs(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := o.CheckDownload(current); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("File.Stat: %w", err)
+	}
+	return bucket.Stream(o.ProgressReadCloser(f, info.Size()), info.Size(), nil), nil
+}
+
+// GenerateGetObjectSignedURL returns a file URL, local files can not expire so ttl is ignored.
+func (b *bucketLocal) GenerateGetObjectSignedURL(_ context.Context, objName string, _ time.Time) (string, error) {
+	p, err := b.path(objName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(p); err != nil {
+		return "", fmt.Errorf("os.Stat: %w", err)
+	}
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(p)}
+	return u.String(), nil
+}
+
+func (b *bucketLocal) List(ctx context.Context, prefix string) ([]bucket.ObjectAttrs, error) {
+	var list []bucket.ObjectAttrs
+	err := b.walk(ctx, prefix, func(name, p string) error {
+		attrs, err := fileAttrs(p)
+		if err != nil {
+			return err
+		}
+		attrs.Name = name
+		list = append(list, attrs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+// walk calls fn with the name and the path of every object whose name starts with prefix.
+func (b *bucketLocal) walk(ctx context.Context, prefix string, fn func(name, p string) error) error {
+	err := filepath.WalkDir(b.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tmpPrefix) || strings.HasPrefix(d.Name(), tagsPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		if err := fn(name, p); err != nil {
+			return err
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return fmt.Errorf("filepath.WalkDir: %w", err)
+	}
+	return nil
+}
+
+func (b *bucketLocal) Stat(_ context.Context, objName string, _ ...bucket.Option) (bucket.ObjectAttrs, error) {
+	p, err := b.path(objName)
+	if err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	attrs, err := fileAttrs(p)
+	if err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	attrs.Name = objName
+	attrs.ContentType = mime.TypeByExtension(path.Ext(objName))
+	return attrs, nil
+}
+
+// currentAttrs returns the attributes of the file for the precondition checks, nil when it does not exist.
+func currentAttrs(p string) (*bucket.ObjectAttrs, error) {
+	attrs, err := fileAttrs(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attrs, nil
+}
+
+// fileAttrs hashes the file, the ETag is the quoted hex MD5 like S3 reports it for single-part uploads.
+func fileAttrs(p string) (bucket.ObjectAttrs, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	sum := h.Sum(nil)
+	return bucket.ObjectAttrs{
+		Size:    info.Size(),
+		MD5:     sum,
+		ETag:    fmt.Sprintf(`"%x"`, sum),
+		Updated: info.ModTime(),
+	}, nil
+}
+
+// ListVersions returns the file as the only version, local files keep no history. The version ID is the ETag,
+// so it stops matching once the file is replaced.
+func (b *bucketLocal) ListVersions(ctx context.Context, objName string) ([]bucket.ObjectVersion, error) {
+	attrs, err := b.Stat(ctx, objName)
+	if err != nil {
+		return nil, err
+	}
+	return []bucket.ObjectVersion{{ObjectAttrs: attrs, VersionID: attrs.ETag, IsLatest: true}}, nil
+}
+
+func (b *bucketLocal) DownloadVersion(ctx context.Context, objName, versionID string, opts ...bucket.Option) (io.ReadCloser, error) {
+	if versionID == bucket.ETagAny {
+		return nil, errNoSuchVersion(versionID)
+	}
+	rc, err := b.DownloadByChunks(ctx, objName, append(opts, bucket.IfMatch(versionID))...)
+	if errors.Is(err, bucket.ErrPreconditionFailed{}) {
+		return nil, errNoSuchVersion(versionID)
+	}
+	return rc, err
+}
+
+func (b *bucketLocal) DeleteVersion(_ context.Context, objName, versionID string) error {
+	p, err := b.path(objName)
+	if err != nil {
+		return err
+	}
+	renameMu.Lock()
+	defer renameMu.Unlock()
+	current, err := currentAttrs(p)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.ETag != versionID {
+		return errNoSuchVersion(versionID)
+	}
+	if err := os.Remove(p); err != nil {
+		return fmt.Errorf("os.Remove: %w", err)
+	}
+	os.Remove(tagsPath(p))
+	return nil
+}
+
+func errNoSuchVersion(versionID string) error {
+	return fmt.Errorf("version %s: %w", versionID, fs.ErrNotExist)
+}
+
+// tagsFile is the content of a tags file. The tags belong to the content with the ETag,
+// so replacing the file drops them like uploads do on the cloud providers.
+type tagsFile struct {
+	ETag string            `json:"etag"`
+	Tags map[string]string `json:"tags"`
+}
+
+func tagsPath(p string) string {
+	return filepath.Join(filepath.Dir(p), tagsPrefix+filepath.Base(p))
+}
+
+func (b *bucketLocal) GetTags(_ context.Context, objName string) (map[string]string, error) {
+	p, err := b.path(objName)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := fileAttrs(p)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	data, err := os.ReadFile(tagsPath(p))
+	if errors.Is(err, fs.ErrNotExist) {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %w", err)
+	}
+	var f tagsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	if f.ETag != attrs.ETag {
+		return tags, nil
+	}
+	for k, v := range f.Tags {
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+func (b *bucketLocal) SetTags(_ context.Context, objName string, tags map[string]string) error {
+	p, err := b.path(objName)
+	if err != nil {
+		return err
+	}
+	renameMu.Lock()
+	defer renameMu.Unlock()
+	attrs, err := fileAttrs(p)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(tagsFile{ETag: attrs.ETag, Tags: tags})
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+	if err := os.WriteFile(tagsPath(p), data, 0644); err != nil {
+		return fmt.Errorf("os.WriteFile: %w", err)
+	}
+	return nil
+}
+
+func (b *bucketLocal) FindByTags(ctx context.Context, filter map[string]string) ([]string, error) {
+	list, err := b.List(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return bucket.FilterByTags(ctx, bucket.Names(list), filter, b.GetTags)
+}
@@ -0,0 +1,246 @@
+package local
+
+import (
+	"context"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestService(t *testing.T) {
+	suite.Run(t, new(Suite))
+}
+
+type Suite struct {
+	suite.Suite
+	storage *bucketLocal
+}
+
+func (s *Suite) SetupTest() {
+	b, err := OpenBucket(context.Background(), s.T().TempDir())
+	s.Require().NoError(err)
+	s.storage = b
+}
+
+func (s *Suite) TestUploadAndDownloadBytes() {
+	ctx := context.Background()
+	fileName := "dir/fileName"
+	content := []byte("abc")
+
+	err := s.storage.UploadBytes(ctx, content, fileName)
+	s.NoError(err)
+
+	gotContent, err := s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal(content, gotContent)
+}
+
+func (s *Suite) TestUploadAndDownloadByChunks() {
+	ctx := context.Background()
+	fileName := "fileName"
+
+	err := s.storage.UploadByChunks(ctx, strings.NewReader("abc"), fileName)
+	s.NoError(err)
+
+	rc, err := s.storage.DownloadByChunks(ctx, fileName)
+	s.Require().NoError(err)
+	defer rc.Close()
+	gotContent, err := io.ReadAll(rc)
+	s.NoError(err)
+	s.Equal("abc", string(gotContent))
+}
+
+func (s *Suite) TestNewWriter() {
+	ctx := context.Background()
+	fileName := "dir/fileName"
+
+	w := s.storage.NewWriter(ctx, fileName)
+	_, err := io.WriteString(w, "abc")
+	s.NoError(err)
+	_, err = s.storage.Stat(ctx, fileName)
+	s.ErrorIs(err, os.ErrNotExist, "nothing is stored before Close")
+	s.NoError(w.Close())
+	data, err := s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal("abc", string(data))
+
+	w = s.storage.NewWriter(ctx, fileName)
+	_, err = io.WriteString(w, "def")
+	s.NoError(err)
+	s.NoError(w.CloseWithError(nil))
+	data, err = s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal("abc", string(data))
+
+	w = s.storage.NewWriter(ctx, fileName, bucket.IfNoneMatch(bucket.ETagAny))
+	s.ErrorIs(w.Close(), bucket.ErrPreconditionFailed{})
+	entries, err := os.ReadDir(filepath.Join(s.storage.dir, "dir"))
+	s.NoError(err)
+	s.Len(entries, 1, "temporary files are removed")
+
+	s.ErrorAs(s.storage.NewWriter(ctx, "../fileName").Close(), &ErrInvalidName{})
+}
+
+func (s *Suite) TestDelete() {
+	ctx := context.Background()
+	fileName := "fileName"
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abc"), fileName))
+
+	err := s.storage.Delete(ctx, fileName)
+	s.NoError(err)
+
+	_, err = os.Stat(filepath.Join(s.storage.dir, fileName))
+	s.True(os.IsNotExist(err))
+}
+
+func (s *Suite) TestInvalidName() {
+	ctx := context.Background()
+
+	err := s.storage.UploadBytes(ctx, []byte("abc"), "../fileName")
+	s.ErrorIs(err, ErrInvalidName{Name: "../fileName"})
+}
+
+func (s *Suite) TestList() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abc"), "a/2"))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abcd"), "a/1"))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abcde"), "b"))
+
+	list, err := s.storage.List(ctx, "a/")
+	s.NoError(err)
+	s.Require().Len(list, 2)
+	s.Equal("a/1", list[0].Name)
+	s.Equal(int64(4), list[0].Size)
+	s.NotEmpty(list[0].MD5)
+	s.Equal("a/2", list[1].Name)
+}
+
+func (s *Suite) TestGenerateGetObjectSignedURL() {
+	ctx := context.Background()
+	fileName := "fileName"
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abc"), fileName))
+
+	link, err := s.storage.GenerateGetObjectSignedURL(ctx, fileName, time.Now().Add(time.Minute))
+	s.NoError(err)
+	s.Equal("file://"+filepath.ToSlash(filepath.Join(s.storage.dir, fileName)), link)
+}
+
+func (s *Suite) TestStat() {
+	ctx := context.Background()
+	fileName := "dir/file.txt"
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abc"), fileName))
+
+	attrs, err := s.storage.Stat(ctx, fileName)
+	s.NoError(err)
+	s.Equal(fileName, attrs.Name)
+	s.Equal(int64(3), attrs.Size)
+	s.Equal([]byte{0x90, 0x01, 0x50, 0x98, 0x3c, 0xd2, 0x4f, 0xb0, 0xd6, 0x96, 0x3f, 0x7d, 0x28, 0xe1, 0x7f, 0x72}, attrs.MD5)
+	s.Contains(attrs.ContentType, "text/plain")
+
+	_, err = s.storage.Stat(ctx, "missing")
+	s.True(os.IsNotExist(err))
+}
+
+func (s *Suite) TestDeleteManyAndPrefix() {
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "dir/a", "dir/b", "other"} {
+		s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abc"), name))
+	}
+
+	err := s.storage.DeleteMany(ctx, []string{"a", "b", "missing"})
+	var deleteErr bucket.ErrDeleteFailed
+	s.Require().ErrorAs(err, &deleteErr)
+	s.Len(deleteErr.Errors, 1)
+	s.ErrorIs(deleteErr.Errors["missing"], os.ErrNotExist)
+
+	s.NoError(s.storage.DeletePrefix(ctx, "dir/"))
+
+	list, err := s.storage.List(ctx, "")
+	s.NoError(err)
+	s.Equal([]string{"other"}, bucket.Names(list))
+}
+
+func (s *Suite) TestConditionalUpload() {
+	ctx := context.Background()
+	fileName := "manifest"
+
+	s.NoError(s.storage.UploadBytes(ctx, []byte("v1"), fileName, bucket.IfNoneMatch(bucket.ETagAny)))
+	err := s.storage.UploadBytes(ctx, []byte("v2"), fileName, bucket.IfNoneMatch(bucket.ETagAny))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+
+	attrs, err := s.storage.Stat(ctx, fileName)
+	s.Require().NoError(err)
+	s.NoError(s.storage.UploadBytes(ctx, []byte("v2"), fileName, bucket.IfMatch(attrs.ETag)))
+	err = s.storage.UploadBytes(ctx, []byte("v3"), fileName, bucket.IfMatch(attrs.ETag))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+
+	_, err = s.storage.DownloadBytes(ctx, fileName, bucket.IfNoneMatch(`"other"`))
+	s.NoError(err)
+	data, err := s.storage.DownloadBytes(ctx, fileName, bucket.IfMatch(attrs.ETag))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+	s.Nil(data)
+}
+
+func (s *Suite) TestVersions() {
+	ctx := context.Background()
+	fileName := "fileName"
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abc"), fileName))
+
+	versions, err := s.storage.ListVersions(ctx, fileName)
+	s.NoError(err)
+	s.Require().Len(versions, 1)
+	s.True(versions[0].IsLatest)
+
+	rc, err := s.storage.DownloadVersion(ctx, fileName, versions[0].VersionID)
+	s.Require().NoError(err)
+	rc.Close()
+
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abcd"), fileName))
+	_, err = s.storage.DownloadVersion(ctx, fileName, versions[0].VersionID)
+	s.ErrorIs(err, os.ErrNotExist)
+	s.ErrorIs(s.storage.DeleteVersion(ctx, fileName, versions[0].VersionID), os.ErrNotExist)
+}
+
+func (s *Suite) TestTTL() {
+	ctx := context.Background()
+	s.Equal(bucket.ErrUnsupportedTTL{TTL: time.Hour}, s.storage.UploadBytes(ctx, []byte("a"), "a", bucket.WithTTL(time.Hour)))
+	s.ErrorAs(s.storage.NewWriter(ctx, "a", bucket.WithTTL(time.Hour)).Close(), &bucket.ErrUnsupportedTTL{})
+	_, err := s.storage.Stat(ctx, "a")
+	s.Error(err)
+}
+
+func (s *Suite) TestTags() {
+	ctx := context.Background()
+	for _, name := range []string{"a", "dir/b", "c"} {
+		s.Require().NoError(s.storage.UploadBytes(ctx, []byte(name), name))
+	}
+	s.NoError(s.storage.SetTags(ctx, "a", map[string]string{"tenant": "acme"}))
+	s.NoError(s.storage.SetTags(ctx, "dir/b", map[string]string{"tenant": "acme", "class": "gold"}))
+
+	tags, err := s.storage.GetTags(ctx, "dir/b")
+	s.NoError(err)
+	s.Equal(map[string]string{"tenant": "acme", "class": "gold"}, tags)
+
+	names, err := s.storage.FindByTags(ctx, map[string]string{"tenant": "acme"})
+	s.NoError(err)
+	s.Equal([]string{"a", "dir/b"}, names)
+
+	list, err := s.storage.List(ctx, "")
+	s.NoError(err)
+	s.Equal([]string{"a", "c", "dir/b"}, bucket.Names(list))
+
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("a2"), "a"))
+	tags, err = s.storage.GetTags(ctx, "a")
+	s.NoError(err)
+	s.Empty(tags)
+
+	s.NoError(s.storage.Delete(ctx, "dir/b"))
+	_, err = os.Stat(tagsPath(filepath.Join(s.storage.dir, "dir", "b")))
+	s.True(os.IsNotExist(err))
+}
@@ -0,0 +1,237 @@
+package mem
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+)
+
+type ErrNoSuchBucket struct{}
+
+func (e ErrNoSuchBucket) Error() string {
+	return "No bucket exist with such name"
+}
+
+type ErrBucketExists struct{}
+
+func (e ErrBucketExists) Error() string {
+	return "Bucket with such name already exists"
+}
+
+type ErrBucketNotEmpty struct{}
+
+func (e ErrBucketNotEmpty) Error() string {
+	return "Bucket is not empty"
+}
+
+// namespace holds the objects of one bucket with their tag index, lifecycle rules and expiry.
+type namespace struct {
+	data      sync.Map
+	tags      tagIndex
+	lifecycle lifecycle
+	sweeper   sweeper
+
+	// mu orders the changes of data, so put and remove account for the size of the unit they replace.
+	mu sync.Mutex
+	// used is the usage of the store the namespace belongs to.
+	used *int64
+}
+
+// put stores the unit of the object and adds the change of the size to the usage of the store.
+func (ns *namespace) put(name string, unit dataUnit) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	delta := unit.size()
+	if prev, ok := ns.data.Load(name); ok {
+		delta -= prev.(dataUnit).size()
+	}
+	ns.data.Store(name, unit)
+	atomic.AddInt64(ns.used, delta)
+}
+
+// remove deletes the object and subtracts its size from the usage of the store.
+func (ns *namespace) remove(name string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if prev, ok := ns.data.LoadAndDelete(name); ok {
+		atomic.AddInt64(ns.used, -prev.(dataUnit).size())
+	}
+}
+
+// empty reports whether the namespace holds no objects, expired ones included.
+func (ns *namespace) empty() bool {
+	empty := true
+	ns.data.Range(func(_, _ interface{}) bool {
+		empty = false
+		return false
+	})
+	return empty
+}
+
+// stop stops the goroutines of the namespace.
+func (ns *namespace) stop() {
+	ns.sweeper.stopSweeper()
+
+	ns.lifecycle.mu.Lock()
+	defer ns.lifecycle.mu.Unlock()
+	ns.lifecycle.stopJanitor()
+}
+
+// store is the state of a memory storage: its buckets and the persistence of their snapshots.
+type store struct {
+	// used is the size of every version of the objects of all buckets, expired ones included. It is kept
+	// first for the alignment atomic needs.
+	used int64
+
+	mu          sync.RWMutex
+	namespaces  map[string]*namespace
+	persistence persistence
+	// limit is the number of bytes the objects of all buckets may take with their versions, 0 is no limit.
+	limit int64
+}
+
+func (s *store) lookup(name string) (*namespace, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ns, ok := s.namespaces[name]
+	return ns, ok
+}
+
+// open returns the namespace of the bucket, creating it when it does not exist.
+func (s *store) open(name string) *namespace {
+	if ns, ok := s.lookup(name); ok {
+		return ns
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.namespaces == nil {
+		s.namespaces = make(map[string]*namespace)
+	}
+	ns, ok := s.namespaces[name]
+	if !ok {
+		ns = &namespace{used: &s.used}
+		s.namespaces[name] = ns
+	}
+	return ns
+}
+
+func (s *store) create(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.namespaces[name]; ok {
+		return ErrBucketExists{}
+	}
+	if s.namespaces == nil {
+		s.namespaces = make(map[string]*namespace)
+	}
+	s.namespaces[name] = &namespace{used: &s.used}
+	return nil
+}
+
+// remove deletes the bucket, it has to be empty.
+func (s *store) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ns, ok := s.namespaces[name]
+	if !ok {
+		return ErrNoSuchBucket{}
+	}
+	if !ns.empty() {
+		return ErrBucketNotEmpty{}
+	}
+	ns.stop()
+	delete(s.namespaces, name)
+	return nil
+}
+
+// stop stops the goroutines of every bucket.
+func (s *store) stop() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, ns := range s.namespaces {
+		ns.stop()
+	}
+}
+
+// available returns the number of bytes that can still be stored, -1 when there is no limit.
+func (s *store) available() int64 {
+	if s.limit <= 0 {
+		return -1
+	}
+
+	available := s.limit - atomic.LoadInt64(&s.used)
+	if available < 0 {
+		available = 0
+	}
+	return available
+}
+
+func (s *store) names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.namespaces))
+	for name := range s.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BucketExists reports whether the default server has the bucket.
+func BucketExists(ctx context.Context, name string) (bool, error) {
+	s, err := startDefaultServer()
+	if err != nil {
+		return false, err
+	}
+	return s.BucketExists(ctx, name)
+}
+
+// CreateBucket creates an empty bucket of the default server, OpenBucket creates missing buckets as well.
+func CreateBucket(ctx context.Context, name string, opts ...bucket.CreateOption) error {
+	s, err := startDefaultServer()
+	if err != nil {
+		return err
+	}
+	return s.CreateBucket(ctx, name, opts...)
+}
+
+// DeleteBucket deletes an empty bucket of the default server.
+func DeleteBucket(ctx context.Context, name string) error {
+	s, err := startDefaultServer()
+	if err != nil {
+		return err
+	}
+	return s.DeleteBucket(ctx, name)
+}
+
+// ListBuckets returns the sorted names of the buckets of the default server.
+func ListBuckets(ctx context.Context) ([]string, error) {
+	s, err := startDefaultServer()
+	if err != nil {
+		return nil, err
+	}
+	return s.ListBuckets(ctx)
+}
+
+// Reset deletes the objects of the bucket with their tags and lifecycle rules, the other buckets are not affected.
+func (m *memoryStorage) Reset() {
+	m.lifecycle.mu.Lock()
+	m.lifecycle.stopJanitor()
+	m.lifecycle.rules = nil
+	m.lifecycle.mu.Unlock()
+
+	m.sweeper.stopSweeper()
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	m.data.Range(func(key, _ interface{}) bool {
+		m.remove(key.(string))
+		return true
+	})
+	m.tags.reset()
+}
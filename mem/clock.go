@@ -0,0 +1,73 @@
+package mem
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the storage: modification times, lifecycle rules and the janitor use it.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FakeClock is a Clock for tests that only moves when it is advanced.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the time once the clock is advanced by d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{deadline: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Advance moves the clock forward and fires the After channels whose time has come.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = waiting
+}
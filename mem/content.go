@@ -0,0 +1,134 @@
+package mem
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const MemoryLimit = "MEMORY_LIMIT_MEM_SRV"
+
+type ErrQuotaExceeded struct {
+	Limit int64
+}
+
+func (e ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("memory limit of %d bytes exceeded", e.Limit)
+}
+
+// content is the data of an object split into segments of chunkSize bytes, the last one may be shorter.
+// The segments are never modified once stored, readers share them and DownloadBytes returns a copy.
+type content struct {
+	segments [][]byte
+	size     int64
+	md5      []byte
+}
+
+// newContent splits data into segments without copying it, data must not be modified afterwards.
+func newContent(data []byte) content {
+	c := content{size: int64(len(data))}
+	for len(data) > 0 {
+		n := chunkSize
+		if n > len(data) {
+			n = len(data)
+		}
+		c.segments = append(c.segments, data[:n:n])
+		data = data[n:]
+	}
+	sum := md5.Sum(c.bytes())
+	c.md5 = sum[:]
+	return c
+}
+
+// readContent reads r into new segments, it fails with ErrQuotaExceeded as soon as the data does not fit
+// in the memory limit of the store.
+func (s *store) readContent(r io.Reader) (content, error) {
+	var c content
+	h := md5.New()
+	available := s.available()
+
+	for {
+		segment := make([]byte, chunkSize)
+		n, err := io.ReadFull(r, segment)
+		if n > 0 {
+			if n < chunkSize {
+				short := make([]byte, n)
+				copy(short, segment)
+				segment = short
+			}
+			c.segments = append(c.segments, segment)
+			c.size += int64(n)
+			h.Write(segment)
+		}
+		if available >= 0 && c.size > available {
+			return content{}, ErrQuotaExceeded{Limit: s.limit}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return content{}, err
+		}
+	}
+
+	c.md5 = h.Sum(nil)
+	return c, nil
+}
+
+// head returns the first segment, it holds the bytes content type detection looks at.
+func (c content) head() []byte {
+	if len(c.segments) == 0 {
+		return nil
+	}
+	return c.segments[0]
+}
+
+// bytes returns a copy of the data.
+func (c content) bytes() []byte {
+	b := make([]byte, 0, c.size)
+	for _, segment := range c.segments {
+		b = append(b, segment...)
+	}
+	return b
+}
+
+func (c content) reader() *contentReader {
+	return &contentReader{content: c}
+}
+
+// contentReader reads the segments of a content in place.
+type contentReader struct {
+	content content
+	off     int64
+}
+
+func (r *contentReader) Read(p []byte) (int, error) {
+	if r.off >= r.content.size {
+		return 0, io.EOF
+	}
+
+	segment := r.content.segments[r.off/chunkSize][r.off%chunkSize:]
+	n := copy(p, segment)
+	r.off += int64(n)
+
+	return n, nil
+}
+
+func (r *contentReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.content.size
+	default:
+		return 0, errors.New("contentReader.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("contentReader.Seek: negative position")
+	}
+	r.off = offset
+
+	return offset, nil
+}
@@ -0,0 +1,158 @@
+package mem
+
+import (
+	"context"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"strings"
+	"sync"
+	"time"
+)
+
+// janitorInterval is the period of the janitor that enforces the lifecycle rules.
+const janitorInterval = time.Minute
+
+const day = 24 * time.Hour
+
+// lifecycle holds the rules of the storage and the janitor enforcing them, the janitor runs while there are rules.
+type lifecycle struct {
+	mu    sync.Mutex
+	rules []bucket.LifecycleRule
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+var _ bucket.Lifecycler = (*memoryStorage)(nil)
+var _ bucket.StorageClassSetter = (*memoryStorage)(nil)
+
+func (m *memoryStorage) GetLifecycle(_ context.Context) ([]bucket.LifecycleRule, error) {
+	m.lifecycle.mu.Lock()
+	defer m.lifecycle.mu.Unlock()
+
+	return append([]bucket.LifecycleRule(nil), m.lifecycle.rules...), nil
+}
+
+// SetLifecycle replaces the rules and restarts the janitor with the clock of m, no rules stop it.
+func (m *memoryStorage) SetLifecycle(_ context.Context, rules []bucket.LifecycleRule) error {
+	if err := bucket.ValidateLifecycle(rules); err != nil {
+		return err
+	}
+
+	l := &m.lifecycle
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stopJanitor()
+
+	l.rules = append([]bucket.LifecycleRule(nil), rules...)
+	if len(rules) == 0 {
+		return nil
+	}
+
+	l.stop, l.done = make(chan struct{}), make(chan struct{})
+	go m.janitor(l.rules, l.stop, l.done)
+
+	return nil
+}
+
+// stopJanitor stops the janitor and waits for it to return, the caller holds mu.
+func (l *lifecycle) stopJanitor() {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop, l.done = nil, nil
+	}
+}
+
+func (m *memoryStorage) janitor(rules []bucket.LifecycleRule, stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-m.timeSource().After(janitorInterval):
+			m.applyLifecycle(rules)
+		}
+	}
+}
+
+// applyLifecycle deletes and transitions the objects due by the rules. Expiry wins over a transition,
+// the first matching rule with the action applies.
+func (m *memoryStorage) applyLifecycle(rules []bucket.LifecycleRule) {
+	now := m.timeSource().Now()
+	m.data.Range(func(key, value interface{}) bool {
+		name, ok := key.(string)
+		if !ok {
+			return true
+		}
+		unit, ok := value.(dataUnit)
+		if !ok || unit.expired(now) {
+			return true
+		}
+
+		age := now.Sub(unit.updated)
+		var class bucket.StorageClass
+		for _, r := range rules {
+			if !strings.HasPrefix(name, r.Prefix) {
+				continue
+			}
+			if r.ExpireAfterDays > 0 && age >= time.Duration(r.ExpireAfterDays)*day {
+				m.expire(name, unit.generation)
+				return true
+			}
+			if class == "" && r.TransitionAfterDays > 0 && age >= time.Duration(r.TransitionAfterDays)*day {
+				class = r.TransitionStorageClass
+			}
+		}
+		if class != "" && class != unit.storageClass {
+			m.transition(name, unit.generation, class)
+		}
+		return true
+	})
+}
+
+// expire deletes the object unless it was replaced since it was read by the janitor or the sweeper.
+func (m *memoryStorage) expire(objName string, generation int64) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	if unit, err := m.stored(objName); err == nil && unit.generation == generation {
+		m.remove(objName)
+	}
+}
+
+// SetStorageClass moves the object to the class at once, mem reads objects of every class alike so there is
+// no rehydration.
+func (m *memoryStorage) SetStorageClass(_ context.Context, objName string, class bucket.StorageClass) error {
+	if class == "" {
+		return bucket.ErrUnsupportedStorageClass{Class: class}
+	}
+	if err := checkStorageClass(class); err != nil {
+		return err
+	}
+	unit, err := m.load(objName)
+	if err != nil {
+		return err
+	}
+	m.transition(objName, unit.generation, class)
+	return nil
+}
+
+// checkStorageClass accepts the classes of the bucket package and the empty one.
+func checkStorageClass(class bucket.StorageClass) error {
+	switch class {
+	case "", bucket.StorageClassHot, bucket.StorageClassCool, bucket.StorageClassCold, bucket.StorageClassArchive:
+		return nil
+	}
+	return bucket.ErrUnsupportedStorageClass{Class: class}
+}
+
+// transition moves the object to the class unless it was replaced since the janitor read it.
+func (m *memoryStorage) transition(objName string, generation int64, class bucket.StorageClass) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	if unit, err := m.load(objName); err == nil && unit.generation == generation {
+		unit.storageClass = class
+		m.put(objName, unit)
+	}
+}
@@ -3,21 +3,30 @@ package mem
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"io"
-	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-const chunkSize = 32
+// chunkSize is the size of the segments objects are stored in.
+const chunkSize = 64 << 10
 const pattern = "/files"
 const urlValue = "filename"
 const HostName = "HOSTNAME_MEM_SRV"
 const Port = "PORT_MEM_SRV"
 
+// versionsLimit is the number of versions kept per key, the current one included.
+const versionsLimit = 10
+
 type ErrNoSetEnvVars struct{}
 
 func (e ErrNoSetEnvVars) Error() string {
@@ -30,124 +39,592 @@ func (e ErrNoSuchObject) Error() string {
 	return "No file exist with such name"
 }
 
+type ErrNoSuchVersion struct{}
+
+func (e ErrNoSuchVersion) Error() string {
+	return "No version exist with such id"
+}
+
+// ErrCustomerKey is returned by downloads of an object encrypted with a customer key when the key is
+// missing or not the one of the upload.
+type ErrCustomerKey struct{}
+
+func (e ErrCustomerKey) Error() string {
+	return "the object needs the customer key it was uploaded with"
+}
+
 type ErrTypeAssertion struct{}
 
 func (e ErrTypeAssertion) Error() string {
 	return "type assertion error"
 }
 
+// generation numbers every stored object, it is the ETag of the object.
+var generation int64
+
+// writeMu makes the precondition check and the store of a conditional upload atomic.
+var writeMu sync.Mutex
+
 type dataUnit struct {
-	bytes []byte
+	content    content
+	generation int64
+	updated    time.Time
+	tags       map[string]string
+	// contentType is sent with PUT requests to the server or detected from the bytes.
+	contentType string
+	// storageClass is set by uploads, SetStorageClass and lifecycle transitions, empty is the default class.
+	storageClass bucket.StorageClass
+	// expires is set by uploads with a TTL, the zero time never expires.
+	expires time.Time
+	// encryption is the encryption of the upload without the customer key, only its hash is kept.
+	encryption bucket.Encryption
+	keySHA256  [sha256.Size]byte
+	// history holds the previous versions, newest first.
+	history []dataUnit
 }
 
-type memoryStorage struct {
-	data *sync.Map
-	srv  http.Server
+func newDataUnit(c content, contentType string, now time.Time) dataUnit {
+	if contentType == "" {
+		contentType = http.DetectContentType(c.head())
+	}
+	return dataUnit{
+		content:     c,
+		generation:  atomic.AddInt64(&generation, 1),
+		updated:     now,
+		contentType: contentType,
+	}
+}
+
+// expired reports whether the TTL of the unit has passed at now.
+func (d dataUnit) expired(now time.Time) bool {
+	return !d.expires.IsZero() && !now.Before(d.expires)
 }
 
-func OpenBucket(_ context.Context, _ string) (*memoryStorage, error) {
-	if len(os.Getenv(HostName)) == 0 || len(os.Getenv(Port)) == 0 {
-		return nil, ErrNoSetEnvVars{}
+// size is the size of the unit and its history.
+func (d dataUnit) size() int64 {
+	size := d.content.size
+	for _, v := range d.history {
+		size += v.content.size
 	}
+	return size
+}
 
-	i := GetMemInstance()
+// versions returns the unit and its history, newest first.
+func (d dataUnit) versions() []dataUnit {
+	current := d
+	current.history = nil
+	return append([]dataUnit{current}, d.history...)
+}
 
-	if !i.isStart() {
-		i.initData()
+// withHistory returns the unit with versions as its history, dropping the versions over the limit.
+func (d dataUnit) withHistory(versions []dataUnit) dataUnit {
+	if len(versions) > versionsLimit-1 {
+		versions = versions[:versionsLimit-1]
 	}
+	d.history = versions
+	return d
+}
 
-	m := &memoryStorage{
-		data: i.getData(),
-		srv: http.Server{
-			Addr: ":" + os.Getenv(Port),
-		},
+// decrypt checks that o has the customer key of an object encrypted with one, the data is not encrypted.
+func (d dataUnit) decrypt(o bucket.Options) error {
+	if d.encryption.Type != bucket.EncryptionCustomerKey {
+		return nil
+	}
+	if o.Encryption.Type != bucket.EncryptionCustomerKey || sha256.Sum256(o.Encryption.CustomerKey) != d.keySHA256 {
+		return ErrCustomerKey{}
 	}
+	return nil
+}
 
-	mux := http.NewServeMux()
-	mux.Handle(pattern, http.StripPrefix(pattern, m))
+func (d dataUnit) attrs(name string) bucket.ObjectAttrs {
+	contentType := d.contentType
+	if contentType == "" {
+		contentType = http.DetectContentType(d.content.head())
+	}
+	return bucket.ObjectAttrs{
+		Name:         name,
+		Size:         d.content.size,
+		MD5:          d.content.md5,
+		ETag:         fmt.Sprintf(`"%d"`, d.generation),
+		ContentType:  contentType,
+		Updated:      d.updated,
+		Encryption:   d.encryption,
+		StorageClass: d.storageClass,
+	}
+}
 
-	if !i.isStart() {
-		go func() {
-			err := http.ListenAndServe(m.srv.Addr, mux)
-			if err != nil {
-				log.Println("http Listen err", err)
-				panic("http Listen panic")
-			}
-		}()
-		i.setStart()
+// memoryStorage is a bucket of a store, its objects live in the namespace of the bucket name.
+type memoryStorage struct {
+	*namespace
+	name string
+	st   *store
+	// clock is read by the sweeper and the janitor while SetClock may replace it, clockMu guards it.
+	clockMu sync.Mutex
+	clock   Clock
+	// baseURL is the address of the server in signed URLs.
+	baseURL string
+}
+
+func newMemoryStorage(st *store, name string) *memoryStorage {
+	return &memoryStorage{
+		namespace: st.open(name),
+		name:      name,
+		st:        st,
+		clock:     realClock{},
+	}
+}
+
+var _ bucket.Bucket = (*memoryStorage)(nil)
+
+// OpenBucket returns the bucket with the name of the default server, creating it when it does not exist.
+// The default server listens on PORT_MEM_SRV and is started by the first call.
+func OpenBucket(ctx context.Context, bucketName string) (*memoryStorage, error) {
+	s, err := startDefaultServer()
+	if err != nil {
+		return nil, err
 	}
 
-	return m, nil
+	return s.OpenBucket(ctx, bucketName)
+}
+
+// SetClock replaces the time source of m, the running sweeper and janitor use it from their next wait on.
+func (m *memoryStorage) SetClock(clock Clock) {
+	m.clockMu.Lock()
+	defer m.clockMu.Unlock()
+	m.clock = clock
+}
+
+// timeSource returns the clock set by SetClock.
+func (m *memoryStorage) timeSource() Clock {
+	m.clockMu.Lock()
+	defer m.clockMu.Unlock()
+	return m.clock
 }
 
 func (m *memoryStorage) Delete(_ context.Context, objName string) error {
-	m.data.Delete(objName)
+	m.remove(objName)
+
+	return nil
+}
+
+func (m *memoryStorage) DeleteMany(_ context.Context, objNames []string) error {
+	for _, objName := range objNames {
+		m.remove(objName)
+	}
 
 	return nil
 }
 
-func (m *memoryStorage) UploadBytes(_ context.Context, fileAsBytes []byte, objName string) error {
-	m.data.Store(objName, dataUnit{
-		bytes: fileAsBytes,
+func (m *memoryStorage) DeletePrefix(_ context.Context, prefix string) error {
+	m.data.Range(func(key, _ interface{}) bool {
+		if name, ok := key.(string); ok && strings.HasPrefix(name, prefix) {
+			m.remove(name)
+		}
+		return true
 	})
 
 	return nil
 }
 
-func (m *memoryStorage) UploadByChunks(_ context.Context, fileAsRead io.Reader, objName string) error {
-	buf := make([]byte, chunkSize)
+// UploadBytes copies fileAsBytes, the caller may reuse it once UploadBytes returns.
+func (m *memoryStorage) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string, opts ...bucket.Option) error {
+	return m.UploadByChunks(ctx, bytes.NewReader(fileAsBytes), objName, opts...)
+}
 
-	wc := bytes.NewBuffer(make([]byte, 0))
+// UploadByChunks reads the object into segments of chunkSize bytes, it fails with ErrQuotaExceeded as soon
+// as the object does not fit in the memory limit.
+func (m *memoryStorage) UploadByChunks(_ context.Context, fileAsRead io.Reader, objName string, opts ...bucket.Option) error {
+	o := bucket.NewOptions(opts...)
+	if err := o.Encryption.Validate(); err != nil {
+		return err
+	}
+	if err := checkStorageClass(o.StorageClass); err != nil {
+		return err
+	}
 
-	if _, err := io.CopyBuffer(wc, fileAsRead, buf); err != nil {
-		return fmt.Errorf("io.Copy: %w", err)
+	c, err := m.st.readContent(o.ProgressReader(fileAsRead, -1))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", objName, err)
 	}
 
-	m.data.Store(objName, dataUnit{
-		bytes: wc.Bytes(),
-	})
+	_, err = m.store(objName, c, "", o)
+	return err
+}
+
+// NewWriter keeps the writes in memory until Close stores the object, the writes fail with ErrQuotaExceeded
+// as soon as the object does not fit in the memory limit.
+func (m *memoryStorage) NewWriter(_ context.Context, objName string, opts ...bucket.Option) bucket.Writer {
+	o := bucket.NewOptions(opts...)
+	if err := o.Encryption.Validate(); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	if err := checkStorageClass(o.StorageClass); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	return bucket.NewUploadWriter(&bufferedUpload{m: m, objName: objName, o: o}, o)
+}
+
+// bufferedUpload is the upload of NewWriter.
+type bufferedUpload struct {
+	m       *memoryStorage
+	objName string
+	o       bucket.Options
+	buf     []byte
+}
 
+func (u *bufferedUpload) Write(p []byte) (int, error) {
+	if available := u.m.st.available(); available >= 0 && int64(len(u.buf)+len(p)) > available {
+		return 0, ErrQuotaExceeded{Limit: u.m.st.limit}
+	}
+	u.buf = append(u.buf, p...)
+	return len(p), nil
+}
+
+func (u *bufferedUpload) Commit() error {
+	_, err := u.m.store(u.objName, newContent(u.buf), "", u.o)
+	u.buf = nil
+	return err
+}
+
+func (u *bufferedUpload) Abort() error {
+	u.buf = nil
 	return nil
 }
 
-func (m *memoryStorage) DownloadBytes(_ context.Context, objName string) ([]byte, error) {
-	data, ok := m.data.Load(objName)
+// store saves a new generation of the object if it satisfies the preconditions of o and fits in the memory
+// limit, an empty content type is detected from the data. The encryption and the class of o are recorded,
+// the encryption is not applied.
+func (m *memoryStorage) store(objName string, c content, contentType string, o bucket.Options) (dataUnit, error) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
 
-	if !ok {
-		return nil, ErrNoSuchObject{}
+	if available := m.st.available(); available >= 0 && c.size > available {
+		return dataUnit{}, ErrQuotaExceeded{Limit: m.st.limit}
 	}
 
-	dataUnit, ok := data.(dataUnit)
+	previous, err := m.load(objName)
 
-	if !ok {
-		return nil, ErrTypeAssertion{}
+	if o.Conditional() {
+		current, err := current(previous, err)
+		if err != nil {
+			return dataUnit{}, err
+		}
+		if err := o.CheckUpload(current); err != nil {
+			return dataUnit{}, err
+		}
+	}
+
+	unit := newDataUnit(c, contentType, m.timeSource().Now())
+	if err == nil {
+		unit = unit.withHistory(previous.versions())
+	}
+	if o.TTL > 0 {
+		unit.expires = unit.updated.Add(o.TTL)
+		m.startSweeper()
+	}
+	unit.storageClass = o.StorageClass
+	unit.encryption = bucket.Encryption{Type: o.Encryption.Type, KMSKeyID: o.Encryption.KMSKeyID}
+	if o.Encryption.Type == bucket.EncryptionCustomerKey {
+		unit.keySHA256 = sha256.Sum256(o.Encryption.CustomerKey)
+	}
+
+	m.put(objName, unit)
+
+	return unit, nil
+}
+
+// load returns the stored object, an expired one is reported as missing until the sweeper deletes it.
+func (m *memoryStorage) load(objName string) (dataUnit, error) {
+	dataUnit, err := m.stored(objName)
+
+	if err == nil && dataUnit.expired(m.timeSource().Now()) {
+		return dataUnit, ErrNoSuchObject{}
 	}
 
-	return dataUnit.bytes, nil
+	return dataUnit, err
 }
 
-func (m *memoryStorage) DownloadByChunks(_ context.Context, objName string) (io.ReadCloser, error) {
+// stored is load that also returns expired objects.
+func (m *memoryStorage) stored(objName string) (dataUnit, error) {
 	data, ok := m.data.Load(objName)
 
 	if !ok {
-		return nil, ErrNoSuchObject{}
+		return dataUnit{}, ErrNoSuchObject{}
 	}
 
 	dataUnit, ok := data.(dataUnit)
 
 	if !ok {
-		return nil, ErrTypeAssertion{}
+		return dataUnit, ErrTypeAssertion{}
+	}
+
+	return dataUnit, nil
+}
+
+// loadIf is load that checks the download preconditions and the customer key of o against the loaded object.
+func (m *memoryStorage) loadIf(objName string, o bucket.Options) (dataUnit, error) {
+	dataUnit, err := m.load(objName)
+
+	if err == nil {
+		if err := dataUnit.decrypt(o); err != nil {
+			return dataUnit, err
+		}
+	}
+
+	if !o.Conditional() {
+		return dataUnit, err
 	}
 
-	return io.NopCloser(bytes.NewReader(dataUnit.bytes)), nil
+	current, cerr := current(dataUnit, err)
+	if cerr != nil {
+		return dataUnit, cerr
+	}
+	if cerr := o.CheckDownload(current); cerr != nil {
+		return dataUnit, cerr
+	}
+
+	return dataUnit, err
+}
+
+// current converts the result of load for the precondition checks, a missing object is nil.
+func current(dataUnit dataUnit, err error) (*bucket.ObjectAttrs, error) {
+	switch err.(type) {
+	case nil:
+	case ErrNoSuchObject:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	attrs := dataUnit.attrs("")
+
+	return &attrs, nil
+}
+
+func (m *memoryStorage) DownloadBytes(ctx context.Context, objName string, opts ...bucket.Option) ([]byte, error) {
+	o := bucket.NewOptions(opts...)
+	if o.Progress != nil {
+		rc, err := m.DownloadByChunks(ctx, objName, opts...)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+
+	dataUnit, err := m.loadIf(objName, o)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return dataUnit.content.bytes(), nil
+}
+
+func (m *memoryStorage) DownloadByChunks(_ context.Context, objName string, opts ...bucket.Option) (io.ReadCloser, error) {
+	o := bucket.NewOptions(opts...)
+
+	dataUnit, err := m.loadIf(objName, o)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bucket.Stream(o.ProgressReadCloser(io.NopCloser(dataUnit.content.reader()), dataUnit.content.size), dataUnit.content.size, nil), nil
 }
 
 func (m *memoryStorage) GenerateGetObjectSignedURL(_ context.Context, objName string, _ time.Time) (string, error) {
-	_, ok := m.data.Load(objName)
+	if _, err := m.load(objName); err != nil {
+		return "", err
+	}
 
-	if !ok {
-		return "", ErrNoSuchObject{}
+	return fmt.Sprintf("%v%v/%v?%v=%v", m.baseURL, pattern, url.PathEscape(m.name), urlValue, url.QueryEscape(objName)), nil
+}
+
+func (m *memoryStorage) List(_ context.Context, prefix string) ([]bucket.ObjectAttrs, error) {
+	var list []bucket.ObjectAttrs
+	var err error
+	now := m.timeSource().Now()
+
+	m.data.Range(func(key, value interface{}) bool {
+		name, ok := key.(string)
+		if !ok || !strings.HasPrefix(name, prefix) {
+			return true
+		}
+		dataUnit, ok := value.(dataUnit)
+		if !ok {
+			err = ErrTypeAssertion{}
+			return false
+		}
+		if dataUnit.expired(now) {
+			return true
+		}
+		list = append(list, dataUnit.attrs(name))
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+// Stat reports the encryption of objects encrypted with a customer key without the key.
+func (m *memoryStorage) Stat(_ context.Context, objName string, _ ...bucket.Option) (bucket.ObjectAttrs, error) {
+	dataUnit, err := m.load(objName)
+
+	if err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+
+	return dataUnit.attrs(objName), nil
+}
+
+func (m *memoryStorage) ListVersions(_ context.Context, objName string) ([]bucket.ObjectVersion, error) {
+	dataUnit, err := m.load(objName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []bucket.ObjectVersion
+	for i, v := range dataUnit.versions() {
+		versions = append(versions, bucket.ObjectVersion{
+			ObjectAttrs: v.attrs(objName),
+			VersionID:   strconv.FormatInt(v.generation, 10),
+			IsLatest:    i == 0,
+		})
+	}
+
+	return versions, nil
+}
+
+func (m *memoryStorage) DownloadVersion(_ context.Context, objName, versionID string, opts ...bucket.Option) (io.ReadCloser, error) {
+	o := bucket.NewOptions(opts...)
+
+	dataUnit, err := m.load(objName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range dataUnit.versions() {
+		if strconv.FormatInt(v.generation, 10) == versionID {
+			if err := v.decrypt(o); err != nil {
+				return nil, err
+			}
+			return bucket.Stream(o.ProgressReadCloser(io.NopCloser(v.content.reader()), v.content.size), v.content.size, nil), nil
+		}
+	}
+
+	return nil, ErrNoSuchVersion{}
+}
+
+// DeleteVersion removes the version from the history, deleting the current version makes the previous one current.
+func (m *memoryStorage) DeleteVersion(_ context.Context, objName, versionID string) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	dataUnit, err := m.load(objName)
+
+	if err != nil {
+		return err
+	}
+
+	versions := dataUnit.versions()
+	for i, v := range versions {
+		if strconv.FormatInt(v.generation, 10) != versionID {
+			continue
+		}
+		versions = append(versions[:i:i], versions[i+1:]...)
+		if len(versions) == 0 {
+			m.remove(objName)
+		} else {
+			m.put(objName, versions[0].withHistory(versions[1:]))
+			m.tags.add(objName, versions[0].tags)
+		}
+		return nil
+	}
+
+	return ErrNoSuchVersion{}
+}
+
+func (m *memoryStorage) GetTags(_ context.Context, objName string) (map[string]string, error) {
+	dataUnit, err := m.load(objName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return copyTags(dataUnit.tags), nil
+}
+
+func (m *memoryStorage) SetTags(_ context.Context, objName string, tags map[string]string) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	dataUnit, err := m.load(objName)
+
+	if err != nil {
+		return err
+	}
+
+	dataUnit.tags = copyTags(tags)
+	m.put(objName, dataUnit)
+	m.tags.add(objName, dataUnit.tags)
+
+	return nil
+}
+
+// FindByTags looks the filter up in the tag index and checks the candidates against their current tags.
+func (m *memoryStorage) FindByTags(ctx context.Context, filter map[string]string) ([]string, error) {
+	if len(filter) == 0 {
+		list, err := m.List(ctx, "")
+		if err != nil {
+			return nil, err
+		}
+		return bucket.Names(list), nil
+	}
+
+	var names []string
+	for _, name := range m.tags.candidates(filter) {
+		dataUnit, err := m.load(name)
+		if err == nil && bucket.MatchTags(dataUnit.tags, filter) {
+			names = append(names, name)
+			continue
+		}
+		if _, ok := err.(ErrTypeAssertion); ok {
+			return nil, err
+		}
+		m.pruneTags(name, filter)
+	}
+
+	return names, nil
+}
+
+// pruneTags drops the index entries of filter that the object no longer has.
+func (m *memoryStorage) pruneTags(objName string, filter map[string]string) {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	dataUnit, _ := m.load(objName)
+
+	stale := make(map[string]string)
+	for k, v := range filter {
+		if got, ok := dataUnit.tags[k]; !ok || got != v {
+			stale[k] = v
+		}
 	}
 
-	return fmt.Sprintf("http://%v%v%v?%v=%v", os.Getenv(HostName), m.srv.Addr, pattern, urlValue, objName), nil
+	m.tags.remove(objName, stale)
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags))
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
 }
@@ -1,89 +1,110 @@
 package mem
 
 import (
-	"bytes"
 	"encoding/json"
-	"fmt"
-	"io"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"log"
+	"mime"
 	"net/http"
-	"sync"
+	"path"
+	"strings"
 )
 
-type SingletonMemStorage interface {
-	setStart()
-	isStart() bool
-	initData()
-	getData() *sync.Map
-}
-
-type singletonMemStorage struct {
-	sync.RWMutex
-	data       sync.Map
-	isSrvStart bool
-}
+// allowedMethods is the Allow header of 405 responses.
+var allowedMethods = strings.Join([]string{http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete}, ", ")
 
-var instance *singletonMemStorage
-var once sync.Once
-
-func GetMemInstance() SingletonMemStorage {
-	once.Do(func() {
-		instance = new(singletonMemStorage)
-	})
-
-	return instance
-}
+// ServeHTTP serves the object named by the filename query parameter. GET and HEAD support ranges and
+// conditional requests, PUT stores the body with its Content-Type and honours If-Match and If-None-Match,
+// DELETE removes the object.
+func (m *memoryStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filename := r.URL.Query().Get(urlValue)
+	if filename == "" {
+		writeResponse(w, http.StatusBadRequest, "Missing "+urlValue)
+		return
+	}
 
-func (s *singletonMemStorage) setStart() {
-	s.Lock()
-	defer s.Unlock()
-	s.isSrvStart = true
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		m.serveObject(w, r, filename)
+	case http.MethodPut:
+		m.putObject(w, r, filename)
+	case http.MethodDelete:
+		m.deleteObject(w, r, filename)
+	default:
+		w.Header().Set("Allow", allowedMethods)
+		writeResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
 }
 
-func (s *singletonMemStorage) isStart() bool {
-	s.RLock()
-	defer s.RUnlock()
-	return s.isSrvStart
-}
+func (m *memoryStorage) serveObject(w http.ResponseWriter, r *http.Request, filename string) {
+	dataUnit, err := m.load(filename)
+	if err == nil {
+		err = dataUnit.decrypt(bucket.Options{})
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-func (s *singletonMemStorage) initData() {
-	s.Lock()
-	defer s.Unlock()
-	s.data = sync.Map{}
-}
+	attrs := dataUnit.attrs(filename)
+	w.Header().Set("ETag", attrs.ETag)
+	w.Header().Set("Content-Type", attrs.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(filename)}))
 
-func (s *singletonMemStorage) getData() *sync.Map {
-	s.RLock()
-	defer s.RUnlock()
-	return &(s.data)
+	// ServeContent answers ranges, If-None-Match, If-Match and If-Modified-Since, and sets Last-Modified
+	// and Content-Length.
+	http.ServeContent(w, r, filename, attrs.Updated, dataUnit.content.reader())
 }
 
-func (m *memoryStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-
-	switch r.Method {
-	case http.MethodGet:
-
-		filename := r.FormValue(urlValue)
+func (m *memoryStorage) putObject(w http.ResponseWriter, r *http.Request, filename string) {
+	c, err := m.st.readContent(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-		data, ok := m.data.Load(filename)
+	o := bucket.Options{
+		IfMatch:     r.Header.Get("If-Match"),
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+	}
+	dataUnit, err := m.store(filename, c, r.Header.Get("Content-Type"), o)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-		if !ok {
-			writeResponse(w, http.StatusBadRequest, http.StatusText(500))
-		}
+	w.Header().Set("ETag", dataUnit.attrs(filename).ETag)
+	w.WriteHeader(http.StatusOK)
+}
 
-		dataUnit, ok := data.(dataUnit)
+func (m *memoryStorage) deleteObject(w http.ResponseWriter, r *http.Request, filename string) {
+	if _, err := m.load(filename); err != nil {
+		writeError(w, err)
+		return
+	}
 
-		//copy the relevant headers. If you want to preserve the downloaded file name, extract it with go's url parser.
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%v", filename))
+	if err := m.Delete(r.Context(), filename); err != nil {
+		writeError(w, err)
+		return
+	}
 
-		//stream the body to the client without fully loading it into memory
-		_, err := io.Copy(w, bytes.NewReader(dataUnit.bytes))
-		if err != nil {
-			log.Println(err)
-		}
+	w.WriteHeader(http.StatusNoContent)
+}
 
+// writeError answers with the status of err, 403 for objects encrypted with a customer key, 404 for missing
+// objects, 412 for failed preconditions and 507 when the memory limit is exceeded.
+func writeError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case ErrQuotaExceeded:
+		writeResponse(w, http.StatusInsufficientStorage, err.Error())
+	case ErrCustomerKey:
+		writeResponse(w, http.StatusForbidden, err.Error())
+	case ErrNoSuchObject:
+		writeResponse(w, http.StatusNotFound, err.Error())
+	case bucket.ErrPreconditionFailed:
+		writeResponse(w, http.StatusPreconditionFailed, err.Error())
 	default:
-		writeResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		writeResponse(w, http.StatusInternalServerError, err.Error())
 	}
 }
 
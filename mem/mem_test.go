@@ -3,10 +3,18 @@ package mem
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"errors"
 	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -26,20 +34,15 @@ type Suite struct {
 
 func (s *Suite) SetupTest() {
 	s.bucket = "bucket"
-	s.storage = &memoryStorage{
-		data: &sync.Map{},
-		srv: http.Server{
-			Addr: ":" + os.Getenv("PORT"),
-		},
-	}
+	s.storage = newMemoryStorage(&store{}, s.bucket)
 }
 
 func (s *Suite) TestDelete() {
 	ctx := context.Background()
 	fileName := "fileName"
 	content := []byte("abc")
-	s.storage.data.Store(fileName, dataUnit{
-		bytes: content,
+	s.storage.put(fileName, dataUnit{
+		content: newContent(content),
 	})
 
 	err := s.storage.Delete(ctx, fileName)
@@ -54,8 +57,8 @@ func (s *Suite) TestDownloadBytes() {
 	fileName := "fileName"
 	content := []byte("abc")
 
-	s.storage.data.Store(fileName, dataUnit{
-		bytes: content,
+	s.storage.put(fileName, dataUnit{
+		content: newContent(content),
 	})
 
 	gotContent, err := s.storage.DownloadBytes(ctx, fileName)
@@ -75,16 +78,17 @@ func (s *Suite) TestDownloadByChunksWithExistFile() {
 	ctx := context.Background()
 	fileName := "fileName"
 	data := []byte("abc")
-	bytesReader := bytes.NewReader(data)
-	bytesReadCloser := io.NopCloser(bytesReader)
 
-	s.storage.data.Store(fileName, dataUnit{
-		bytes: data,
+	s.storage.put(fileName, dataUnit{
+		content: newContent(data),
 	})
 
 	gotContent, err := s.storage.DownloadByChunks(ctx, fileName)
-	s.Equal(bytesReadCloser, gotContent)
+	s.Require().NoError(err)
+	defer gotContent.Close()
+	got, err := io.ReadAll(gotContent)
 	s.NoError(err)
+	s.Equal(data, got)
 }
 
 func (s *Suite) TestUploadBytes() {
@@ -109,6 +113,49 @@ func (s *Suite) TestUploadByChunks() {
 	s.Equal(ok, true)
 }
 
+func (s *Suite) TestNewWriter() {
+	ctx := context.Background()
+	fileName := "fileName"
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("v1"), fileName))
+
+	w := s.storage.NewWriter(ctx, fileName, bucket.WithStorageClass(bucket.StorageClassCool))
+	_, err := io.WriteString(w, "v2,")
+	s.NoError(err)
+	_, err = s.storage.Stat(ctx, fileName)
+	s.NoError(err)
+	data, err := s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal("v1", string(data), "nothing is stored before Close")
+	_, err = io.WriteString(w, "csv")
+	s.NoError(err)
+	s.NoError(w.Close())
+	data, err = s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal("v2,csv", string(data))
+	attrs, err := s.storage.Stat(ctx, fileName)
+	s.NoError(err)
+	s.Equal(bucket.StorageClassCool, attrs.StorageClass)
+
+	w = s.storage.NewWriter(ctx, fileName)
+	_, err = io.WriteString(w, "v3")
+	s.NoError(err)
+	s.NoError(w.CloseWithError(errors.New("encoder failed")))
+	data, err = s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal("v2,csv", string(data))
+
+	w = s.storage.NewWriter(ctx, fileName, bucket.IfNoneMatch(bucket.ETagAny))
+	s.ErrorIs(w.Close(), bucket.ErrPreconditionFailed{})
+
+	s.storage.st.limit = 20
+	w = s.storage.NewWriter(ctx, "big")
+	_, err = w.Write(make([]byte, 20))
+	s.ErrorAs(err, &ErrQuotaExceeded{})
+	s.ErrorAs(w.Close(), &ErrQuotaExceeded{})
+	_, err = s.storage.Stat(ctx, "big")
+	s.ErrorIs(err, ErrNoSuchObject{})
+}
+
 func (s *Suite) TestGenerateGetObjectSignedURL() {
 	ctx := context.Background()
 	fileName := "fileName"
@@ -120,3 +167,767 @@ func (s *Suite) TestGenerateGetObjectSignedURL() {
 	s.NoError(err)
 	fmt.Println(link)
 }
+
+func (s *Suite) TestList() {
+	ctx := context.Background()
+	s.storage.put("dir/b", dataUnit{content: newContent([]byte("abcd"))})
+	s.storage.put("dir/a", dataUnit{content: newContent([]byte("abc"))})
+	s.storage.put("other", dataUnit{content: newContent([]byte("abc"))})
+
+	list, err := s.storage.List(ctx, "dir/")
+	s.NoError(err)
+	s.Require().Len(list, 2)
+	s.Equal("dir/a", list[0].Name)
+	s.Equal(int64(3), list[0].Size)
+	s.Equal([]byte{0x90, 0x01, 0x50, 0x98, 0x3c, 0xd2, 0x4f, 0xb0, 0xd6, 0x96, 0x3f, 0x7d, 0x28, 0xe1, 0x7f, 0x72}, list[0].MD5)
+	s.Equal("dir/b", list[1].Name)
+}
+
+func (s *Suite) TestStat() {
+	ctx := context.Background()
+	fileName := "fileName"
+	s.storage.put(fileName, dataUnit{content: newContent([]byte("abc"))})
+
+	attrs, err := s.storage.Stat(ctx, fileName)
+	s.NoError(err)
+	s.Equal(fileName, attrs.Name)
+	s.Equal(int64(3), attrs.Size)
+	s.Equal("text/plain; charset=utf-8", attrs.ContentType)
+
+	_, err = s.storage.Stat(ctx, "missing")
+	s.ErrorIs(err, ErrNoSuchObject{})
+}
+
+func (s *Suite) TestProgress() {
+	ctx := context.Background()
+	fileName := "fileName"
+	var uploaded, downloaded bucket.Progress
+
+	err := s.storage.UploadBytes(ctx, []byte("abc"), fileName, bucket.WithProgress(func(p bucket.Progress) { uploaded = p }))
+	s.NoError(err)
+	s.Equal(int64(3), uploaded.Transferred)
+	s.Equal(int64(3), uploaded.Total)
+
+	content, err := s.storage.DownloadBytes(ctx, fileName, bucket.WithProgress(func(p bucket.Progress) { downloaded = p }))
+	s.NoError(err)
+	s.Equal([]byte("abc"), content)
+	s.Equal(int64(3), downloaded.Transferred)
+	s.Equal(int64(3), downloaded.Total)
+}
+
+func (s *Suite) TestDeleteManyAndPrefix() {
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "dir/a", "dir/b", "other"} {
+		s.storage.put(name, dataUnit{content: newContent([]byte("abc"))})
+	}
+
+	s.NoError(s.storage.DeleteMany(ctx, []string{"a", "b", "missing"}))
+	s.NoError(s.storage.DeletePrefix(ctx, "dir/"))
+
+	list, err := s.storage.List(ctx, "")
+	s.NoError(err)
+	s.Equal([]string{"other"}, bucket.Names(list))
+}
+
+func (s *Suite) TestConditionalUpload() {
+	ctx := context.Background()
+	fileName := "manifest"
+
+	s.NoError(s.storage.UploadBytes(ctx, []byte("v1"), fileName, bucket.IfNoneMatch(bucket.ETagAny)))
+	err := s.storage.UploadBytes(ctx, []byte("v2"), fileName, bucket.IfNoneMatch(bucket.ETagAny))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+
+	attrs, err := s.storage.Stat(ctx, fileName)
+	s.Require().NoError(err)
+	s.NoError(s.storage.UploadBytes(ctx, []byte("v2"), fileName, bucket.IfMatch(attrs.ETag)))
+	err = s.storage.UploadBytes(ctx, []byte("v3"), fileName, bucket.IfMatch(attrs.ETag))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+
+	err = s.storage.UploadBytes(ctx, []byte("v1"), "missing", bucket.IfMatch(attrs.ETag))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+
+	data, err := s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal("v2", string(data))
+}
+
+func (s *Suite) TestConditionalDownload() {
+	ctx := context.Background()
+	fileName := "fileName"
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abc"), fileName))
+	attrs, err := s.storage.Stat(ctx, fileName)
+	s.Require().NoError(err)
+
+	_, err = s.storage.DownloadBytes(ctx, fileName, bucket.IfNoneMatch(attrs.ETag))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+	_, err = s.storage.DownloadByChunks(ctx, fileName, bucket.IfModifiedSince(attrs.Updated))
+	s.ErrorIs(err, bucket.ErrPreconditionFailed{})
+
+	data, err := s.storage.DownloadBytes(ctx, fileName, bucket.IfMatch(attrs.ETag), bucket.IfModifiedSince(attrs.Updated.Add(-time.Second)))
+	s.NoError(err)
+	s.Equal("abc", string(data))
+
+	_, err = s.storage.DownloadBytes(ctx, "missing", bucket.IfNoneMatch(attrs.ETag))
+	s.ErrorIs(err, ErrNoSuchObject{})
+}
+
+func (s *Suite) TestVersions() {
+	ctx := context.Background()
+	fileName := "fileName"
+	for i := 0; i < versionsLimit+2; i++ {
+		s.Require().NoError(s.storage.UploadBytes(ctx, []byte(fmt.Sprint(i)), fileName))
+	}
+
+	versions, err := s.storage.ListVersions(ctx, fileName)
+	s.NoError(err)
+	s.Require().Len(versions, versionsLimit)
+	s.True(versions[0].IsLatest)
+	s.False(versions[1].IsLatest)
+
+	rc, err := s.storage.DownloadVersion(ctx, fileName, versions[1].VersionID)
+	s.Require().NoError(err)
+	data, err := io.ReadAll(rc)
+	s.NoError(err)
+	s.Equal(fmt.Sprint(versionsLimit), string(data))
+
+	s.NoError(s.storage.DeleteVersion(ctx, fileName, versions[0].VersionID))
+	data, err = s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal(fmt.Sprint(versionsLimit), string(data))
+
+	s.NoError(bucket.RestoreVersion(ctx, s.storage, fileName, versions[versionsLimit-1].VersionID))
+	data, err = s.storage.DownloadBytes(ctx, fileName)
+	s.NoError(err)
+	s.Equal("2", string(data))
+
+	s.ErrorIs(s.storage.DeleteVersion(ctx, fileName, versions[0].VersionID), ErrNoSuchVersion{})
+	_, err = s.storage.DownloadVersion(ctx, fileName, "0")
+	s.ErrorIs(err, ErrNoSuchVersion{})
+}
+
+func (s *Suite) TestTags() {
+	ctx := context.Background()
+	for _, name := range []string{"a", "b", "c"} {
+		s.Require().NoError(s.storage.UploadBytes(ctx, []byte(name), name))
+	}
+	s.NoError(s.storage.SetTags(ctx, "a", map[string]string{"tenant": "acme", "class": "gold"}))
+	s.NoError(s.storage.SetTags(ctx, "b", map[string]string{"tenant": "acme", "class": "bronze"}))
+	s.NoError(s.storage.SetTags(ctx, "c", map[string]string{"tenant": "other", "class": "gold"}))
+
+	tags, err := s.storage.GetTags(ctx, "a")
+	s.NoError(err)
+	s.Equal(map[string]string{"tenant": "acme", "class": "gold"}, tags)
+
+	names, err := s.storage.FindByTags(ctx, map[string]string{"tenant": "acme"})
+	s.NoError(err)
+	s.Equal([]string{"a", "b"}, names)
+	names, err = s.storage.FindByTags(ctx, map[string]string{"tenant": "acme", "class": "gold"})
+	s.NoError(err)
+	s.Equal([]string{"a"}, names)
+
+	s.NoError(s.storage.SetTags(ctx, "b", map[string]string{"tenant": "acme", "class": "gold"}))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("a2"), "a"))
+	s.NoError(s.storage.Delete(ctx, "c"))
+	names, err = s.storage.FindByTags(ctx, map[string]string{"class": "gold"})
+	s.NoError(err)
+	s.Equal([]string{"b"}, names)
+	s.Equal([]string{"b"}, s.storage.tags.candidates(map[string]string{"class": "gold"}))
+	// Entries are dropped lazily, only the looked up tags have been pruned.
+	s.Equal([]string{"a", "b"}, s.storage.tags.candidates(map[string]string{"tenant": "acme"}))
+
+	tags, err = s.storage.GetTags(ctx, "a")
+	s.NoError(err)
+	s.Empty(tags)
+
+	_, err = s.storage.GetTags(ctx, "missing")
+	s.ErrorIs(err, ErrNoSuchObject{})
+}
+
+func (s *Suite) TestLifecycle() {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC))
+	s.storage.SetClock(clock)
+	rules := []bucket.LifecycleRule{
+		{ID: "tmp", Prefix: "tmp/", ExpireAfterDays: 7},
+		{ID: "logs", Prefix: "logs/", TransitionAfterDays: 30, TransitionStorageClass: bucket.StorageClassCold},
+	}
+
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("a"), "tmp/a"))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("b"), "keep/b"))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("c"), "logs/c"))
+	s.Require().NoError(s.storage.SetLifecycle(ctx, rules))
+	defer s.storage.SetLifecycle(ctx, nil)
+
+	got, err := s.storage.GetLifecycle(ctx)
+	s.NoError(err)
+	s.Equal(rules, got)
+
+	clock.Advance(6 * day)
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("d"), "tmp/d"))
+	clock.Advance(day)
+
+	// the janitor may not wait on the clock yet, advance until it has run
+	s.Eventually(func() bool {
+		clock.Advance(janitorInterval)
+		_, err := s.storage.Stat(ctx, "tmp/a")
+		return err != nil
+	}, time.Second, time.Millisecond)
+	_, err = s.storage.Stat(ctx, "tmp/d")
+	s.NoError(err)
+	_, err = s.storage.Stat(ctx, "keep/b")
+	s.NoError(err)
+
+	clock.Advance(23 * day)
+	s.Eventually(func() bool {
+		clock.Advance(janitorInterval)
+		unit, err := s.storage.load("logs/c")
+		return err == nil && unit.storageClass == bucket.StorageClassCold
+	}, time.Second, time.Millisecond)
+
+	s.Error(s.storage.SetLifecycle(ctx, []bucket.LifecycleRule{{ID: "tmp", Prefix: "tmp/"}}))
+	s.NoError(s.storage.SetLifecycle(ctx, nil))
+	s.Nil(s.storage.lifecycle.stop)
+}
+
+func (s *Suite) TestStorageClass() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("a"), "a", bucket.WithStorageClass(bucket.StorageClassArchive)))
+	attrs, err := s.storage.Stat(ctx, "a")
+	s.NoError(err)
+	s.Equal(bucket.StorageClassArchive, attrs.StorageClass)
+	s.Nil(attrs.Rehydration)
+	data, err := s.storage.DownloadBytes(ctx, "a")
+	s.NoError(err)
+	s.Equal([]byte("a"), data)
+
+	s.NoError(s.storage.SetStorageClass(ctx, "a", bucket.StorageClassCool))
+	list, err := s.storage.List(ctx, "")
+	s.NoError(err)
+	s.Equal(bucket.StorageClassCool, list[0].StorageClass)
+	s.Equal(attrs.ETag, list[0].ETag)
+
+	s.ErrorAs(s.storage.SetStorageClass(ctx, "a", ""), &bucket.ErrUnsupportedStorageClass{})
+	s.ErrorIs(s.storage.SetStorageClass(ctx, "missing", bucket.StorageClassHot), ErrNoSuchObject{})
+	s.ErrorAs(s.storage.UploadBytes(ctx, []byte("b"), "b", bucket.WithStorageClass("frozen")), &bucket.ErrUnsupportedStorageClass{})
+}
+
+func (s *Suite) TestFakeClock() {
+	start := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	c := clock.After(time.Hour)
+	clock.Advance(time.Minute)
+	s.Empty(c)
+	clock.Advance(time.Hour)
+	s.Equal(start.Add(time.Hour+time.Minute), <-c)
+	s.Equal(start.Add(time.Hour+time.Minute), clock.Now())
+}
+
+func (s *Suite) TestTTL() {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC))
+	s.storage.SetClock(clock)
+	defer s.storage.Close()
+
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("a"), "cache/a", bucket.WithTTL(30*time.Second)))
+	s.Require().NoError(s.storage.UploadByChunks(ctx, bytes.NewReader([]byte("b")), "cache/b", bucket.WithTTL(time.Hour)))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("c"), "cache/c"))
+
+	clock.Advance(30 * time.Second)
+	_, err := s.storage.DownloadBytes(ctx, "cache/a")
+	s.Equal(ErrNoSuchObject{}, err)
+	_, err = s.storage.Stat(ctx, "cache/a")
+	s.Equal(ErrNoSuchObject{}, err)
+	list, err := s.storage.List(ctx, "cache/")
+	s.NoError(err)
+	s.Equal([]string{"cache/b", "cache/c"}, bucket.Names(list))
+
+	// read lazily, the entry is still stored until the sweeper runs
+	_, ok := s.storage.data.Load("cache/a")
+	s.True(ok)
+	s.Eventually(func() bool {
+		clock.Advance(sweepInterval)
+		_, ok := s.storage.data.Load("cache/a")
+		return !ok
+	}, time.Second, time.Millisecond)
+
+	clock.Advance(time.Hour)
+	_, err = s.storage.DownloadBytes(ctx, "cache/b")
+	s.Equal(ErrNoSuchObject{}, err)
+	_, err = s.storage.DownloadBytes(ctx, "cache/c")
+	s.NoError(err)
+
+	// uploading again replaces the expired object without keeping it as a version
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("b2"), "cache/b"))
+	versions, err := s.storage.ListVersions(ctx, "cache/b")
+	s.NoError(err)
+	s.Len(versions, 1)
+
+	s.NoError(s.storage.Close())
+	s.Nil(s.storage.sweeper.stop)
+}
+
+// TestSetClockWhileSweeping replaces the clock of a running sweeper, which uses it from its next wait on.
+func (s *Suite) TestSetClockWhileSweeping() {
+	ctx := context.Background()
+	start := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	first, second := NewFakeClock(start), NewFakeClock(start)
+	s.storage.SetClock(first)
+	defer s.storage.Close()
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("a"), "cache/a", bucket.WithTTL(time.Hour)))
+
+	s.storage.SetClock(second)
+	first.Advance(sweepInterval)
+	s.Eventually(func() bool {
+		second.Advance(time.Hour)
+		_, ok := s.storage.data.Load("cache/a")
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+// TestResetDuringTTLUploads stops the sweeper while uploads with a TTL start it and the sweeper deletes
+// expired objects, neither may wait for the other forever.
+func (s *Suite) TestResetDuringTTLUploads() {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC))
+	s.storage.SetClock(clock)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					_ = s.storage.UploadBytes(ctx, []byte("a"), fmt.Sprintf("cache/%d/%d", w, i), bucket.WithTTL(time.Nanosecond))
+					clock.Advance(sweepInterval)
+				}
+			}(w)
+		}
+		for i := 0; i < 100; i++ {
+			time.Sleep(time.Millisecond)
+			s.storage.Reset()
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+		s.NoError(s.storage.Close())
+	case <-time.After(10 * time.Second):
+		// the deadlocked goroutines keep writeMu, the other tests would hang on it
+		panic("Reset and the uploads with a TTL deadlocked")
+	}
+}
+
+func (s *Suite) TestSnapshot() {
+	ctx := context.Background()
+	// the fake clock has no monotonic readings, which are not part of a snapshot
+	clock := NewFakeClock(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC))
+	s.storage.SetClock(clock)
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("v1"), "a"))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("v2"), "a"))
+	s.Require().NoError(s.storage.SetTags(ctx, "a", map[string]string{"tenant": "acme"}))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("b"), "dir/b", bucket.WithTTL(time.Hour)))
+	key := bytes.Repeat([]byte{1}, 32)
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("k"), "k", bucket.WithCustomerKey(key)))
+	want, err := s.storage.List(ctx, "")
+	s.Require().NoError(err)
+
+	var buf bytes.Buffer
+	s.Require().NoError(s.storage.Snapshot(&buf))
+
+	restored := newMemoryStorage(&store{}, "restored")
+	restored.SetClock(clock)
+	s.Require().NoError(restored.UploadBytes(ctx, []byte("gone"), "c"))
+	s.Require().NoError(restored.Restore(bytes.NewReader(buf.Bytes())))
+
+	got, err := restored.List(ctx, "")
+	s.NoError(err)
+	s.Equal(want, got)
+	versions, err := restored.ListVersions(ctx, "a")
+	s.NoError(err)
+	s.Len(versions, 2)
+	names, err := restored.FindByTags(ctx, map[string]string{"tenant": "acme"})
+	s.NoError(err)
+	s.Equal([]string{"a"}, names)
+	unit, err := restored.load("dir/b")
+	s.NoError(err)
+	s.False(unit.expires.IsZero())
+	_, err = restored.DownloadBytes(ctx, "k")
+	s.ErrorIs(err, ErrCustomerKey{})
+	data, err := restored.DownloadBytes(ctx, "k", bucket.WithCustomerKey(key))
+	s.NoError(err)
+	s.Equal([]byte("k"), data)
+
+	s.Require().NoError(restored.UploadBytes(ctx, []byte("v3"), "a"))
+	versions, err = restored.ListVersions(ctx, "a")
+	s.NoError(err)
+	s.Greater(versions[0].VersionID, versions[1].VersionID)
+
+	s.Error(restored.Restore(strings.NewReader("not a snapshot")))
+	_, err = restored.Stat(ctx, "a")
+	s.NoError(err)
+}
+
+func (s *Suite) TestEncryption() {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{1}, 32)
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("a"), "a", bucket.WithKMSKey("key")))
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("b"), "b", bucket.WithCustomerKey(key)))
+
+	attrs, err := s.storage.Stat(ctx, "a")
+	s.NoError(err)
+	s.Equal(bucket.Encryption{Type: bucket.EncryptionKMS, KMSKeyID: "key"}, attrs.Encryption)
+	attrs, err = s.storage.Stat(ctx, "b")
+	s.NoError(err)
+	s.Equal(bucket.Encryption{Type: bucket.EncryptionCustomerKey}, attrs.Encryption)
+
+	_, err = s.storage.DownloadBytes(ctx, "a")
+	s.NoError(err)
+	_, err = s.storage.DownloadBytes(ctx, "b")
+	s.ErrorIs(err, ErrCustomerKey{})
+	_, err = s.storage.DownloadByChunks(ctx, "b", bucket.WithCustomerKey(bytes.Repeat([]byte{2}, 32)))
+	s.ErrorIs(err, ErrCustomerKey{})
+	data, err := s.storage.DownloadBytes(ctx, "b", bucket.WithCustomerKey(key))
+	s.NoError(err)
+	s.Equal([]byte("b"), data)
+
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("b2"), "b"))
+	versions, err := s.storage.ListVersions(ctx, "b")
+	s.Require().NoError(err)
+	_, err = s.storage.DownloadVersion(ctx, "b", versions[0].VersionID)
+	s.NoError(err)
+	_, err = s.storage.DownloadVersion(ctx, "b", versions[1].VersionID)
+	s.ErrorIs(err, ErrCustomerKey{})
+
+	err = s.storage.UploadBytes(ctx, []byte("c"), "c", bucket.WithCustomerKey(key[:16]))
+	s.ErrorAs(err, &bucket.ErrUnsupportedEncryption{})
+}
+
+func (s *Suite) TestPersistence() {
+	ctx := context.Background()
+	path := filepath.Join(s.T().TempDir(), "mem.snapshot")
+	clock := NewFakeClock(time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC))
+
+	srv, err := NewServer(ServerOptions{Clock: clock, SnapshotFile: path})
+	s.Require().NoError(err)
+	b, err := srv.OpenBucket(ctx, s.bucket)
+	s.Require().NoError(err)
+	s.Require().NoError(b.UploadBytes(ctx, []byte("a"), "a"))
+	s.Eventually(func() bool {
+		clock.Advance(defaultSnapshotInterval)
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	s.Require().NoError(b.UploadBytes(ctx, []byte("b"), "b"))
+	s.Require().NoError(srv.Close())
+
+	restored, err := NewServer(ServerOptions{SnapshotFile: path})
+	s.Require().NoError(err)
+	defer restored.Close()
+	names, err := restored.ListBuckets(ctx)
+	s.NoError(err)
+	s.Equal([]string{s.bucket}, names)
+	b, err = restored.OpenBucket(ctx, s.bucket)
+	s.Require().NoError(err)
+	list, err := b.List(ctx, "")
+	s.NoError(err)
+	s.Equal([]string{"a", "b"}, bucket.Names(list))
+}
+
+func (s *Suite) TestBuckets() {
+	ctx := context.Background()
+	st := s.storage.st
+	other := newMemoryStorage(st, "other")
+
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("a"), "a"))
+	s.Require().NoError(s.storage.SetTags(ctx, "a", map[string]string{"tenant": "acme"}))
+	_, err := other.Stat(ctx, "a")
+	s.Equal(ErrNoSuchObject{}, err)
+	names, err := other.FindByTags(ctx, map[string]string{"tenant": "acme"})
+	s.NoError(err)
+	s.Empty(names)
+
+	s.Equal([]string{"bucket", "other"}, st.names())
+	s.Equal(ErrBucketExists{}, st.create("other"))
+	s.NoError(st.create("new"))
+	s.Equal(ErrBucketNotEmpty{}, st.remove("bucket"))
+	s.NoError(st.remove("new"))
+	s.Equal(ErrNoSuchBucket{}, st.remove("new"))
+
+	s.Require().NoError(other.UploadBytes(ctx, []byte("b"), "b"))
+	s.storage.Reset()
+	list, err := s.storage.List(ctx, "")
+	s.NoError(err)
+	s.Empty(list)
+	_, err = other.Stat(ctx, "b")
+	s.NoError(err)
+	s.NoError(st.remove("bucket"))
+	s.Equal([]string{"other"}, st.names())
+}
+
+func (s *Suite) TestServerAdmin() {
+	ctx := context.Background()
+	srv, err := NewServer(ServerOptions{})
+	s.Require().NoError(err)
+	defer srv.Close()
+
+	exists, err := srv.BucketExists(ctx, "new")
+	s.NoError(err)
+	s.False(exists)
+	s.NoError(bucket.EnsureBucket(ctx, srv, "new", true, bucket.WithLocation("EU")))
+	exists, err = srv.BucketExists(ctx, "new")
+	s.NoError(err)
+	s.True(exists)
+	s.Equal(bucket.ErrBucketExists{Bucket: "new"}, srv.CreateBucket(ctx, "new"))
+
+	s.NoError(srv.DeleteBucket(ctx, "new"))
+	s.Equal(bucket.ErrBucketNotFound{Bucket: "new"}, srv.DeleteBucket(ctx, "new"))
+	s.Equal(bucket.ErrBucketNotFound{Bucket: "new"}, bucket.EnsureBucket(ctx, srv, "new", false))
+}
+
+func (s *Suite) TestServeBuckets() {
+	ctx := context.Background()
+	other := newMemoryStorage(s.storage.st, "other")
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abc"), "dir/a b"))
+	s.Require().NoError(other.UploadBytes(ctx, []byte("other"), "dir/a b"))
+
+	srv := httptest.NewServer(http.StripPrefix(pattern+"/", serveBuckets(s.storage.st, realClock{})))
+	defer srv.Close()
+
+	link, err := s.storage.GenerateGetObjectSignedURL(ctx, "dir/a b", time.Time{})
+	s.Require().NoError(err)
+	u, err := url.Parse(link)
+	s.Require().NoError(err)
+	s.Equal(pattern+"/bucket", u.Path)
+
+	for name, want := range map[string]string{"bucket": "abc", "other": "other"} {
+		resp, err := http.Get(srv.URL + pattern + "/" + name + "?" + u.RawQuery)
+		s.Require().NoError(err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		s.NoError(err)
+		s.Equal(want, string(body))
+	}
+
+	resp, err := http.Get(srv.URL + pattern + "/missing?" + u.RawQuery)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *Suite) TestServeHTTP() {
+	ctx := context.Background()
+	srv := httptest.NewServer(s.storage)
+	defer srv.Close()
+	link := srv.URL + "?" + urlValue + "=" + url.QueryEscape("dir/page.html")
+
+	do := func(method string, body io.Reader, header map[string]string) (*http.Response, string) {
+		req, err := http.NewRequest(method, link, body)
+		s.Require().NoError(err)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		s.Require().NoError(err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		s.Require().NoError(err)
+		return resp, string(b)
+	}
+
+	resp, _ := do(http.MethodGet, nil, nil)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do(http.MethodPut, strings.NewReader("0123456789"), map[string]string{"Content-Type": "text/html"})
+	s.Require().Equal(http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	attrs, err := s.storage.Stat(ctx, "dir/page.html")
+	s.Require().NoError(err)
+	s.Equal(attrs.ETag, etag)
+	s.Equal("text/html", attrs.ContentType)
+
+	resp, _ = do(http.MethodPut, strings.NewReader("other"), map[string]string{"If-None-Match": "*"})
+	s.Equal(http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, body := do(http.MethodGet, nil, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("0123456789", body)
+	s.Equal("text/html", resp.Header.Get("Content-Type"))
+	s.Equal("10", resp.Header.Get("Content-Length"))
+	s.Equal(etag, resp.Header.Get("ETag"))
+	s.Equal(attrs.Updated.UTC().Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+
+	resp, body = do(http.MethodHead, nil, nil)
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Equal("", body)
+	s.Equal("10", resp.Header.Get("Content-Length"))
+
+	resp, body = do(http.MethodGet, nil, map[string]string{"Range": "bytes=2-4"})
+	s.Equal(http.StatusPartialContent, resp.StatusCode)
+	s.Equal("234", body)
+	s.Equal("bytes 2-4/10", resp.Header.Get("Content-Range"))
+
+	resp, _ = do(http.MethodGet, nil, map[string]string{"If-None-Match": etag})
+	s.Equal(http.StatusNotModified, resp.StatusCode)
+
+	resp, _ = do(http.MethodGet, nil, map[string]string{"If-Match": `"0"`})
+	s.Equal(http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp, _ = do(http.MethodPost, nil, nil)
+	s.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+	s.Equal("GET, HEAD, PUT, DELETE", resp.Header.Get("Allow"))
+
+	resp, _ = do(http.MethodDelete, nil, nil)
+	s.Equal(http.StatusNoContent, resp.StatusCode)
+	resp, _ = do(http.MethodDelete, nil, nil)
+	s.Equal(http.StatusNotFound, resp.StatusCode)
+}
+
+func (s *Suite) TestContent() {
+	ctx := context.Background()
+	data := make([]byte, 2*chunkSize+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	s.Require().NoError(s.storage.UploadByChunks(ctx, bytes.NewReader(data), "big"))
+	attrs, err := s.storage.Stat(ctx, "big")
+	s.Require().NoError(err)
+	s.Equal(int64(len(data)), attrs.Size)
+	sum := md5.Sum(data)
+	s.Equal(sum[:], attrs.MD5)
+
+	unit, err := s.storage.load("big")
+	s.Require().NoError(err)
+	s.Len(unit.content.segments, 3)
+	s.Equal(10, cap(unit.content.segments[2]))
+
+	r := unit.content.reader()
+	_, err = r.Seek(chunkSize-2, io.SeekStart)
+	s.Require().NoError(err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(r, got)
+	s.Require().NoError(err)
+	s.Equal(data[chunkSize-2:chunkSize+2], got)
+
+	got, err = s.storage.DownloadBytes(ctx, "big")
+	s.Require().NoError(err)
+	s.Equal(data, got)
+	got[0]++
+	again, err := s.storage.DownloadBytes(ctx, "big")
+	s.Require().NoError(err)
+	s.Equal(data, again)
+
+	uploaded := []byte("abc")
+	s.Require().NoError(s.storage.UploadBytes(ctx, uploaded, "small"))
+	uploaded[0] = 'x'
+	got, err = s.storage.DownloadBytes(ctx, "small")
+	s.Require().NoError(err)
+	s.Equal("abc", string(got))
+}
+
+func (s *Suite) TestDownloadByChunksAfterReturn() {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4<<20/16)
+	s.Require().NoError(s.storage.UploadBytes(ctx, data, "big"))
+
+	rc, err := s.storage.DownloadByChunks(ctx, "big")
+	s.Require().NoError(err)
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("replaced"), "big"))
+	s.Require().NoError(s.storage.Delete(ctx, "big"))
+
+	var got []byte
+	buf := make([]byte, 64<<10)
+	for {
+		n, err := rc.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		s.Require().NoError(err)
+		time.Sleep(100 * time.Microsecond)
+	}
+	s.Equal(data, got)
+	s.NoError(rc.Close())
+	_, err = rc.Read(buf)
+	s.ErrorIs(err, bucket.ErrStreamClosed{})
+}
+
+func (s *Suite) TestQuota() {
+	ctx := context.Background()
+	s.storage.st.limit = 10
+	other := newMemoryStorage(s.storage.st, "other")
+
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abcd"), "a"))
+	s.Require().NoError(other.UploadBytes(ctx, []byte("abcd"), "a"))
+	s.Equal(int64(8), s.storage.st.used)
+
+	err := s.storage.UploadBytes(ctx, []byte("abc"), "b")
+	s.ErrorAs(err, &ErrQuotaExceeded{})
+	_, err = s.storage.Stat(ctx, "b")
+	s.ErrorIs(err, ErrNoSuchObject{})
+
+	// the replaced version is kept in the history and still counts
+	err = s.storage.UploadBytes(ctx, []byte("ab"), "a")
+	s.Require().NoError(err)
+	s.ErrorAs(s.storage.UploadBytes(ctx, []byte("a"), "a"), &ErrQuotaExceeded{})
+	s.Equal(int64(10), s.storage.st.used)
+
+	s.Require().NoError(other.Delete(ctx, "a"))
+	s.NoError(s.storage.UploadBytes(ctx, []byte("abc"), "b"))
+	s.Require().NoError(s.storage.SetTags(ctx, "b", map[string]string{"k": "v"}))
+	s.Equal(int64(9), s.storage.st.used, "the usage is tracked by the writes")
+
+	srv := httptest.NewServer(s.storage)
+	defer srv.Close()
+	req, err := http.NewRequest(http.MethodPut, srv.URL+"?"+urlValue+"=c", strings.NewReader("abcd"))
+	s.Require().NoError(err)
+	resp, err := http.DefaultClient.Do(req)
+	s.Require().NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusInsufficientStorage, resp.StatusCode)
+
+	s.storage.Reset()
+	s.Equal(int64(0), s.storage.st.used)
+}
+
+func (s *Suite) TestServer() {
+	ctx := context.Background()
+	first, err := NewServer(ServerOptions{})
+	s.Require().NoError(err)
+	defer first.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	second, err := NewServer(ServerOptions{Listener: l})
+	s.Require().NoError(err)
+	s.Equal("http://"+l.Addr().String(), second.URL())
+
+	a, err := first.OpenBucket(ctx, s.bucket)
+	s.Require().NoError(err)
+	b, err := second.OpenBucket(ctx, s.bucket)
+	s.Require().NoError(err)
+	s.Require().NoError(a.UploadBytes(ctx, []byte("first"), "key"))
+	_, err = b.Stat(ctx, "key")
+	s.Equal(ErrNoSuchObject{}, err)
+	s.Require().NoError(b.UploadBytes(ctx, []byte("second"), "key"))
+
+	for b, want := range map[*memoryStorage]string{a: "first", b: "second"} {
+		link, err := b.GenerateGetObjectSignedURL(ctx, "key", time.Time{})
+		s.Require().NoError(err)
+		resp, err := http.Get(link)
+		s.Require().NoError(err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		s.NoError(err)
+		s.Equal(want, string(body))
+	}
+
+	s.NoError(second.Shutdown(ctx))
+	_, err = http.Get(second.URL() + pattern + "/" + s.bucket + "?filename=key")
+	s.Error(err)
+	s.NoError(second.Close())
+}
@@ -0,0 +1,244 @@
+package mem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+)
+
+// ServerOptions configures a Server, the zero value serves on an ephemeral port of the loopback interface.
+type ServerOptions struct {
+	// Listener accepts the connections of the server, it is closed with the server.
+	Listener net.Listener
+	// BaseURL is the address of the server in signed URLs, the address of the listener by default.
+	BaseURL string
+	// Clock is the time source of the buckets, the system clock by default.
+	Clock Clock
+	// SnapshotFile enables persistence: the buckets are restored from the file and saved to it every
+	// SnapshotInterval, a minute by default, and when the server is closed.
+	SnapshotFile     string
+	SnapshotInterval time.Duration
+	// MemoryLimit is the number of bytes the objects may take with their versions, uploads over it fail
+	// with ErrQuotaExceeded. Zero is no limit.
+	MemoryLimit int64
+}
+
+// Server is an independent memory storage serving signed URLs of its buckets over HTTP.
+type Server struct {
+	st   *store
+	opts ServerOptions
+	srv  *http.Server
+	done chan struct{}
+	// err is the error Serve returned with, it is set before done is closed.
+	err error
+}
+
+var _ bucket.Admin = (*Server)(nil)
+
+func NewServer(opts ServerOptions) (*Server, error) {
+	if opts.Clock == nil {
+		opts.Clock = realClock{}
+	}
+	if opts.SnapshotInterval == 0 {
+		opts.SnapshotInterval = defaultSnapshotInterval
+	}
+	if opts.Listener == nil {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("net.Listen: %w", err)
+		}
+		opts.Listener = l
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = "http://" + opts.Listener.Addr().String()
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+
+	s := &Server{st: &store{limit: opts.MemoryLimit}, opts: opts, done: make(chan struct{})}
+
+	if opts.SnapshotFile != "" {
+		if err := s.st.startPersistence(opts.SnapshotFile, opts.SnapshotInterval, opts.Clock); err != nil {
+			opts.Listener.Close()
+			return nil, err
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(pattern+"/", http.StripPrefix(pattern+"/", serveBuckets(s.st, opts.Clock)))
+	s.srv = &http.Server{Handler: mux}
+
+	go func() {
+		defer close(s.done)
+		if err := s.srv.Serve(opts.Listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Println("mem server err", err)
+			s.err = err
+		}
+	}()
+
+	return s, nil
+}
+
+// URL returns the base URL of the signed URLs of the server.
+func (s *Server) URL() string {
+	return s.opts.BaseURL
+}
+
+// OpenBucket returns the bucket with the name, creating it when it does not exist.
+func (s *Server) OpenBucket(_ context.Context, bucketName string) (*memoryStorage, error) {
+	m := newMemoryStorage(s.st, bucketName)
+	m.clock = s.opts.Clock
+	m.baseURL = s.opts.BaseURL
+
+	return m, nil
+}
+
+// BucketExists reports whether the bucket was created or opened.
+func (s *Server) BucketExists(_ context.Context, bucketName string) (bool, error) {
+	_, ok := s.st.lookup(bucketName)
+	return ok, nil
+}
+
+// CreateBucket creates an empty bucket, the options have no effect on memory buckets.
+func (s *Server) CreateBucket(_ context.Context, bucketName string, _ ...bucket.CreateOption) error {
+	if err := s.st.create(bucketName); err != nil {
+		return bucket.ErrBucketExists{Bucket: bucketName}
+	}
+	return nil
+}
+
+// DeleteBucket deletes an empty bucket. Buckets opened before keep working on their detached objects.
+func (s *Server) DeleteBucket(_ context.Context, bucketName string) error {
+	err := s.st.remove(bucketName)
+	if errors.As(err, &ErrNoSuchBucket{}) {
+		return bucket.ErrBucketNotFound{Bucket: bucketName}
+	}
+	return err
+}
+
+// ListBuckets returns the sorted names of the buckets.
+func (s *Server) ListBuckets(_ context.Context) ([]string, error) {
+	return s.st.names(), nil
+}
+
+// Shutdown stops the server once the requests in flight are done or ctx is done, see http.Server.Shutdown.
+// It then stops the goroutines of the buckets and saves the last snapshot. The error of a failed Serve
+// is returned when there is no other.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.stop(s.srv.Shutdown(ctx))
+}
+
+// Close is Shutdown that closes the connections at once.
+func (s *Server) Close() error {
+	return s.stop(s.srv.Close())
+}
+
+func (s *Server) stop(err error) error {
+	<-s.done
+	s.st.stop()
+
+	if perr := s.st.stopPersistence(); err == nil {
+		err = perr
+	}
+	if err == nil {
+		err = s.err
+	}
+
+	return err
+}
+
+// serveBuckets serves the objects of every bucket of st under the bucket name.
+func serveBuckets(st *store, clock Clock) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ns, ok := st.lookup(strings.Trim(r.URL.Path, "/"))
+		if !ok {
+			writeResponse(w, http.StatusNotFound, ErrNoSuchBucket{}.Error())
+			return
+		}
+		m := &memoryStorage{namespace: ns, st: st, clock: clock}
+		m.ServeHTTP(w, r)
+	})
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultServer *Server
+)
+
+// DefaultServer returns the server of OpenBucket and the package level bucket functions, it is started on
+// first use.
+func DefaultServer() (*Server, error) {
+	return startDefaultServer()
+}
+
+// startDefaultServer returns the server of OpenBucket, starting it on PORT_MEM_SRV unless it runs.
+func startDefaultServer() (*Server, error) {
+	if len(os.Getenv(HostName)) == 0 || len(os.Getenv(Port)) == 0 {
+		return nil, ErrNoSetEnvVars{}
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultServer != nil {
+		return defaultServer, nil
+	}
+
+	opts := ServerOptions{
+		BaseURL:      fmt.Sprintf("http://%v:%v", os.Getenv(HostName), os.Getenv(Port)),
+		SnapshotFile: os.Getenv(SnapshotFile),
+	}
+	if v := os.Getenv(SnapshotInterval); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", SnapshotInterval, err)
+		}
+		opts.SnapshotInterval = d
+	}
+	if v := os.Getenv(MemoryLimit); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", MemoryLimit, err)
+		}
+		opts.MemoryLimit = limit
+	}
+
+	l, err := net.Listen("tcp", ":"+os.Getenv(Port))
+	if err != nil {
+		return nil, fmt.Errorf("net.Listen: %w", err)
+	}
+	opts.Listener = l
+
+	s, err := NewServer(opts)
+	if err != nil {
+		return nil, err
+	}
+	defaultServer = s
+
+	return s, nil
+}
+
+// Shutdown shuts the default server down, the next OpenBucket starts a new one with no buckets
+// unless they are restored from SNAPSHOT_FILE_MEM_SRV.
+func Shutdown(ctx context.Context) error {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultServer == nil {
+		return nil
+	}
+
+	err := defaultServer.Shutdown(ctx)
+	defaultServer = nil
+
+	return err
+}
@@ -0,0 +1,337 @@
+package mem
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const SnapshotFile = "SNAPSHOT_FILE_MEM_SRV"
+const SnapshotInterval = "SNAPSHOT_INTERVAL_MEM_SRV"
+
+// defaultSnapshotInterval is used when SnapshotInterval is not set.
+const defaultSnapshotInterval = time.Minute
+
+// snapshotFormat is the version of the snapshot encoding, Restore rejects other versions.
+const snapshotFormat = 2
+
+type ErrSnapshotFormat struct {
+	Format int
+}
+
+func (e ErrSnapshotFormat) Error() string {
+	return fmt.Sprintf("unsupported snapshot format %d", e.Format)
+}
+
+type snapshot struct {
+	Format  int
+	Buckets []snapshotBucket
+}
+
+type snapshotBucket struct {
+	Name    string
+	Objects []snapshotObject
+}
+
+type snapshotObject struct {
+	Name string
+	Unit snapshotUnit
+}
+
+// snapshotUnit is the encoded dataUnit, History is newest first.
+type snapshotUnit struct {
+	Bytes        []byte
+	Generation   int64
+	Updated      time.Time
+	Tags         map[string]string
+	ContentType  string
+	StorageClass string
+	Expires      time.Time
+	Encryption   string
+	KMSKeyID     string
+	KeySHA256    [sha256.Size]byte
+	History      []snapshotUnit
+}
+
+func newSnapshotUnit(d dataUnit) snapshotUnit {
+	u := snapshotUnit{
+		Bytes:        d.content.bytes(),
+		Generation:   d.generation,
+		Updated:      d.updated,
+		Tags:         d.tags,
+		ContentType:  d.contentType,
+		StorageClass: string(d.storageClass),
+		Expires:      d.expires,
+		Encryption:   string(d.encryption.Type),
+		KMSKeyID:     d.encryption.KMSKeyID,
+		KeySHA256:    d.keySHA256,
+	}
+	for _, h := range d.history {
+		u.History = append(u.History, newSnapshotUnit(h))
+	}
+	return u
+}
+
+func (u snapshotUnit) dataUnit() dataUnit {
+	d := dataUnit{
+		content:      newContent(u.Bytes),
+		generation:   u.Generation,
+		updated:      u.Updated,
+		tags:         u.Tags,
+		contentType:  u.ContentType,
+		storageClass: bucket.StorageClass(u.StorageClass),
+		expires:      u.Expires,
+		encryption:   bucket.Encryption{Type: bucket.EncryptionType(u.Encryption), KMSKeyID: u.KMSKeyID},
+		keySHA256:    u.KeySHA256,
+	}
+	for _, h := range u.History {
+		d.history = append(d.history, h.dataUnit())
+	}
+	return d
+}
+
+// objects returns the encoded objects of the namespace sorted by name.
+func (ns *namespace) objects() ([]snapshotObject, error) {
+	var objects []snapshotObject
+	var err error
+
+	ns.data.Range(func(key, value interface{}) bool {
+		name, ok := key.(string)
+		if !ok {
+			return true
+		}
+		unit, ok := value.(dataUnit)
+		if !ok {
+			err = ErrTypeAssertion{}
+			return false
+		}
+		objects = append(objects, snapshotObject{Name: name, Unit: newSnapshotUnit(unit)})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+
+	return objects, nil
+}
+
+// restore replaces the objects of the namespace, the caller holds writeMu.
+func (ns *namespace) restore(objects []snapshotObject) {
+	ns.data.Range(func(key, _ interface{}) bool {
+		ns.remove(key.(string))
+		return true
+	})
+	ns.tags.reset()
+
+	for _, o := range objects {
+		unit := o.Unit.dataUnit()
+		ns.put(o.Name, unit)
+		ns.tags.add(o.Name, unit.tags)
+		for _, v := range unit.versions() {
+			advanceGeneration(v.generation)
+		}
+	}
+}
+
+// objects returns the objects of the only bucket of the snapshot or of the bucket with the name.
+func (s snapshot) objects(name string) ([]snapshotObject, error) {
+	if len(s.Buckets) == 1 {
+		return s.Buckets[0].Objects, nil
+	}
+	for _, b := range s.Buckets {
+		if b.Name == name {
+			return b.Objects, nil
+		}
+	}
+	return nil, ErrNoSuchBucket{}
+}
+
+func encodeSnapshot(w io.Writer, s snapshot) error {
+	s.Format = snapshotFormat
+	if err := gob.NewEncoder(w).Encode(s); err != nil {
+		return fmt.Errorf("encoding snapshot: %w", err)
+	}
+	return nil
+}
+
+func decodeSnapshot(r io.Reader) (snapshot, error) {
+	var s snapshot
+	if err := gob.NewDecoder(r).Decode(&s); err != nil {
+		return snapshot{}, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	if s.Format != snapshotFormat {
+		return snapshot{}, ErrSnapshotFormat{Format: s.Format}
+	}
+	return s, nil
+}
+
+// Snapshot writes the objects of the bucket with their versions, tags and expiry times to w. Objects written
+// concurrently may or may not be included.
+func (m *memoryStorage) Snapshot(w io.Writer) error {
+	objects, err := m.objects()
+	if err != nil {
+		return err
+	}
+
+	return encodeSnapshot(w, snapshot{Buckets: []snapshotBucket{{Name: m.name, Objects: objects}}})
+}
+
+// Restore replaces the objects of the bucket with the ones of a snapshot. A snapshot of a single bucket
+// is restored whatever its name, a snapshot of several buckets has to contain the bucket. Nothing is changed
+// when the snapshot can not be read.
+func (m *memoryStorage) Restore(r io.Reader) error {
+	s, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	objects, err := s.objects(m.name)
+	if err != nil {
+		return err
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	m.restore(objects)
+
+	return nil
+}
+
+// snapshot writes every bucket of the store.
+func (s *store) snapshot(w io.Writer) error {
+	var snap snapshot
+	for _, name := range s.names() {
+		ns, ok := s.lookup(name)
+		if !ok {
+			continue
+		}
+		objects, err := ns.objects()
+		if err != nil {
+			return err
+		}
+		snap.Buckets = append(snap.Buckets, snapshotBucket{Name: name, Objects: objects})
+	}
+
+	return encodeSnapshot(w, snap)
+}
+
+// restore creates the buckets of the snapshot and replaces their objects, the other buckets are kept.
+func (s *store) restore(r io.Reader) error {
+	snap, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	writeMu.Lock()
+	defer writeMu.Unlock()
+
+	for _, b := range snap.Buckets {
+		s.open(b.Name).restore(b.Objects)
+	}
+
+	return nil
+}
+
+// advanceGeneration makes sure the generations stored after a restore are above the restored ones.
+func advanceGeneration(restored int64) {
+	for {
+		current := atomic.LoadInt64(&generation)
+		if current >= restored || atomic.CompareAndSwapInt64(&generation, current, restored) {
+			return
+		}
+	}
+}
+
+// persistence saves snapshots of the whole store to a file periodically and when the server is closed.
+type persistence struct {
+	mu   sync.Mutex
+	path string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startPersistence restores the snapshot file if it exists and starts saving it every interval.
+func (s *store) startPersistence(path string, interval time.Duration, clock Clock) error {
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		defer f.Close()
+		if err := s.restore(f); err != nil {
+			return fmt.Errorf("restoring %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	p := &s.persistence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.path = path
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	go s.persist(path, interval, clock, p.stop, p.done)
+
+	return nil
+}
+
+func (s *store) persist(path string, interval time.Duration, clock Clock, stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-clock.After(interval):
+			if err := s.saveSnapshot(path); err != nil {
+				log.Println("mem snapshot err", err)
+			}
+		}
+	}
+}
+
+// stopPersistence stops the periodic snapshots and saves a last one.
+func (s *store) stopPersistence() error {
+	p := &s.persistence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stop == nil {
+		return nil
+	}
+	close(p.stop)
+	<-p.done
+	p.stop, p.done = nil, nil
+
+	return s.saveSnapshot(p.path)
+}
+
+// saveSnapshot writes the snapshot next to the file and renames it over, a crash leaves the previous one intact.
+func (s *store) saveSnapshot(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := s.snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,80 @@
+package mem
+
+import (
+	"sort"
+	"sync"
+)
+
+type tag struct {
+	key, value string
+}
+
+// tagIndex maps every tag to the names of the objects it was set on. Entries are not removed when an object
+// is replaced or deleted, lookups verify the candidates and drop the stale entries.
+type tagIndex struct {
+	mu    sync.RWMutex
+	names map[tag]map[string]struct{}
+}
+
+func (t *tagIndex) add(name string, tags map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.names == nil {
+		t.names = make(map[tag]map[string]struct{})
+	}
+	for k, v := range tags {
+		names, ok := t.names[tag{k, v}]
+		if !ok {
+			names = make(map[string]struct{})
+			t.names[tag{k, v}] = names
+		}
+		names[name] = struct{}{}
+	}
+}
+
+func (t *tagIndex) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.names = nil
+}
+
+// remove drops the name from the entries of the tags.
+func (t *tagIndex) remove(name string, tags map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k, v := range tags {
+		names := t.names[tag{k, v}]
+		delete(names, name)
+		if len(names) == 0 {
+			delete(t.names, tag{k, v})
+		}
+	}
+}
+
+// candidates returns the sorted names indexed under every tag of filter, filter must not be empty.
+func (t *tagIndex) candidates(filter map[string]string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var smallest map[string]struct{}
+	for k, v := range filter {
+		names := t.names[tag{k, v}]
+		if smallest == nil || len(names) < len(smallest) {
+			smallest = names
+		}
+	}
+	var candidates []string
+	for name := range smallest {
+		matched := true
+		for k, v := range filter {
+			if _, ok := t.names[tag{k, v}][name]; !ok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			candidates = append(candidates, name)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates
+}
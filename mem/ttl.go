@@ -0,0 +1,78 @@
+package mem
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is the period of the sweeper that deletes the expired objects.
+const sweepInterval = time.Minute
+
+// sweeper deletes the objects whose TTL has passed, reads skip them before. It is started by the first
+// upload with a TTL and runs until Close.
+type sweeper struct {
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (m *memoryStorage) startSweeper() {
+	s := &m.sweeper
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	go m.sweep(s.stop, s.done)
+}
+
+// stopSweeper stops the sweeper and waits for it to return. The sweeper may wait for writeMu, held by uploads
+// that start a sweeper, so mu is released before the wait.
+func (s *sweeper) stopSweeper() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (m *memoryStorage) sweep(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-m.timeSource().After(sweepInterval):
+			m.removeExpired()
+		}
+	}
+}
+
+func (m *memoryStorage) removeExpired() {
+	now := m.timeSource().Now()
+	m.data.Range(func(key, value interface{}) bool {
+		name, ok := key.(string)
+		if !ok {
+			return true
+		}
+		if unit, ok := value.(dataUnit); ok && unit.expired(now) {
+			m.expire(name, unit.generation)
+		}
+		return true
+	})
+}
+
+// Close stops the TTL sweeper and the lifecycle janitor of the bucket and waits for them to return. The objects
+// and the rules are kept, the next upload with a TTL or SetLifecycle starts them again.
+func (m *memoryStorage) Close() error {
+	m.stop()
+
+	return nil
+}
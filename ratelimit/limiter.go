@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket refilled at rate tokens per second up to burst tokens.
+// It is safe for concurrent use, sharing one Limiter between several buckets makes them share its budget.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewLimiter returns a Limiter that starts full, a rate that is not positive makes it unlimited.
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// Burst is the largest amount that can be taken without waiting for a refill.
+func (l *Limiter) Burst() int {
+	return int(l.burst)
+}
+
+// WaitN takes n tokens, blocking until they are available or ctx is done.
+// n may exceed the burst, the call then waits for the missing tokens to be refilled.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	wait := l.reserve(n)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.release(n)
+		return ctx.Err()
+	}
+}
+
+// reserve takes n tokens, possibly going into debt, and returns how long the caller has to wait
+// for the debt to be paid off. Later callers queue behind the debt.
+func (l *Limiter) reserve(n int) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// release returns tokens of a cancelled wait.
+func (l *Limiter) release(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens += float64(n)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
@@ -0,0 +1,339 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"io"
+	"time"
+)
+
+// Limits configures Wrap. Nil limiters do not limit anything.
+type Limits struct {
+	// UploadBytes limits the bytes per second read from upload bodies and written to writers.
+	UploadBytes *Limiter
+	// DownloadBytes limits the bytes per second read from downloaded objects.
+	DownloadBytes *Limiter
+	// AllRequests limits the calls per second of every method.
+	AllRequests *Limiter
+	// Requests limits the calls per second of single methods, keyed by method name, e.g. "Delete".
+	Requests map[string]*Limiter
+}
+
+type limitedBucket struct {
+	b      bucket.Bucket
+	limits Limits
+}
+
+var _ bucket.Bucket = (*limitedBucket)(nil)
+
+// Wrap returns b with its traffic limited. A limiter may be shared by several wrapped buckets
+// to keep a whole process within one budget. The result implements bucket.Lifecycler and
+// bucket.StorageClassSetter when b does, their calls are limited as requests.
+func Wrap(b bucket.Bucket, limits Limits) bucket.Bucket {
+	l := &limitedBucket{b: b, limits: limits}
+	lc, isLifecycler := b.(bucket.Lifecycler)
+	sc, isClassSetter := b.(bucket.StorageClassSetter)
+	switch {
+	case isLifecycler && isClassSetter:
+		return &struct {
+			*limitedBucket
+			limitedLifecycler
+			limitedClassSetter
+		}{l, limitedLifecycler{l, lc}, limitedClassSetter{l, sc}}
+	case isLifecycler:
+		return &struct {
+			*limitedBucket
+			limitedLifecycler
+		}{l, limitedLifecycler{l, lc}}
+	case isClassSetter:
+		return &struct {
+			*limitedBucket
+			limitedClassSetter
+		}{l, limitedClassSetter{l, sc}}
+	}
+	return l
+}
+
+// Unwrap returns the wrapped bucket.
+func (l *limitedBucket) Unwrap() bucket.Bucket {
+	return l.b
+}
+
+// request waits for the request limiters of method.
+func (l *limitedBucket) request(ctx context.Context, method string) error {
+	if l.limits.AllRequests != nil {
+		if err := l.limits.AllRequests.WaitN(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if lim := l.limits.Requests[method]; lim != nil {
+		if err := lim.WaitN(ctx, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *limitedBucket) Delete(ctx context.Context, objName string) error {
+	if err := l.request(ctx, "Delete"); err != nil {
+		return err
+	}
+	return l.b.Delete(ctx, objName)
+}
+
+func (l *limitedBucket) DeleteMany(ctx context.Context, objNames []string) error {
+	if err := l.request(ctx, "DeleteMany"); err != nil {
+		return err
+	}
+	return l.b.DeleteMany(ctx, objNames)
+}
+
+func (l *limitedBucket) DeletePrefix(ctx context.Context, prefix string) error {
+	if err := l.request(ctx, "DeletePrefix"); err != nil {
+		return err
+	}
+	return l.b.DeletePrefix(ctx, prefix)
+}
+
+// UploadBytes uploads through the limited reader of UploadByChunks, waiting for the whole object up front
+// would send it at full speed.
+func (l *limitedBucket) UploadBytes(ctx context.Context, fileAsBytes []byte, objName string, opts ...bucket.Option) error {
+	if err := l.request(ctx, "UploadBytes"); err != nil {
+		return err
+	}
+	if l.limits.UploadBytes == nil {
+		return l.b.UploadBytes(ctx, fileAsBytes, objName, opts...)
+	}
+	return l.b.UploadByChunks(ctx, newReader(ctx, bytes.NewReader(fileAsBytes), l.limits.UploadBytes), objName, opts...)
+}
+
+func (l *limitedBucket) UploadByChunks(ctx context.Context, fileAsRead io.Reader, objName string, opts ...bucket.Option) error {
+	if err := l.request(ctx, "UploadByChunks"); err != nil {
+		return err
+	}
+	return l.b.UploadByChunks(ctx, newReader(ctx, fileAsRead, l.limits.UploadBytes), objName, opts...)
+}
+
+func (l *limitedBucket) NewWriter(ctx context.Context, objName string, opts ...bucket.Option) bucket.Writer {
+	if err := l.request(ctx, "NewWriter"); err != nil {
+		return bucket.FailedWriter(err)
+	}
+	w := l.b.NewWriter(ctx, objName, opts...)
+	if l.limits.UploadBytes == nil {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, lim: l.limits.UploadBytes}
+}
+
+// DownloadBytes reads the stream of DownloadByChunks through the limited reader.
+func (l *limitedBucket) DownloadBytes(ctx context.Context, objName string, opts ...bucket.Option) ([]byte, error) {
+	if err := l.request(ctx, "DownloadBytes"); err != nil {
+		return nil, err
+	}
+	if l.limits.DownloadBytes == nil {
+		return l.b.DownloadBytes(ctx, objName, opts...)
+	}
+	rc, err := l.b.DownloadByChunks(ctx, objName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(newReader(ctx, rc, l.limits.DownloadBytes))
+}
+
+func (l *limitedBucket) DownloadByChunks(ctx context.Context, objName string, opts ...bucket.Option) (io.ReadCloser, error) {
+	if err := l.request(ctx, "DownloadByChunks"); err != nil {
+		return nil, err
+	}
+	rc, err := l.b.DownloadByChunks(ctx, objName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if l.limits.DownloadBytes == nil {
+		return rc, nil
+	}
+	return &readCloser{Reader: newReader(ctx, rc, l.limits.DownloadBytes), Closer: rc}, nil
+}
+
+func (l *limitedBucket) GenerateGetObjectSignedURL(ctx context.Context, objName string, ttl time.Time) (string, error) {
+	if err := l.request(ctx, "GenerateGetObjectSignedURL"); err != nil {
+		return "", err
+	}
+	return l.b.GenerateGetObjectSignedURL(ctx, objName, ttl)
+}
+
+func (l *limitedBucket) List(ctx context.Context, prefix string) ([]bucket.ObjectAttrs, error) {
+	if err := l.request(ctx, "List"); err != nil {
+		return nil, err
+	}
+	return l.b.List(ctx, prefix)
+}
+
+func (l *limitedBucket) Stat(ctx context.Context, objName string, opts ...bucket.Option) (bucket.ObjectAttrs, error) {
+	if err := l.request(ctx, "Stat"); err != nil {
+		return bucket.ObjectAttrs{}, err
+	}
+	return l.b.Stat(ctx, objName, opts...)
+}
+
+func (l *limitedBucket) ListVersions(ctx context.Context, objName string) ([]bucket.ObjectVersion, error) {
+	if err := l.request(ctx, "ListVersions"); err != nil {
+		return nil, err
+	}
+	return l.b.ListVersions(ctx, objName)
+}
+
+func (l *limitedBucket) DownloadVersion(ctx context.Context, objName, versionID string, opts ...bucket.Option) (io.ReadCloser, error) {
+	if err := l.request(ctx, "DownloadVersion"); err != nil {
+		return nil, err
+	}
+	rc, err := l.b.DownloadVersion(ctx, objName, versionID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if l.limits.DownloadBytes == nil {
+		return rc, nil
+	}
+	return &readCloser{Reader: newReader(ctx, rc, l.limits.DownloadBytes), Closer: rc}, nil
+}
+
+func (l *limitedBucket) DeleteVersion(ctx context.Context, objName, versionID string) error {
+	if err := l.request(ctx, "DeleteVersion"); err != nil {
+		return err
+	}
+	return l.b.DeleteVersion(ctx, objName, versionID)
+}
+
+func (l *limitedBucket) GetTags(ctx context.Context, objName string) (map[string]string, error) {
+	if err := l.request(ctx, "GetTags"); err != nil {
+		return nil, err
+	}
+	return l.b.GetTags(ctx, objName)
+}
+
+func (l *limitedBucket) SetTags(ctx context.Context, objName string, tags map[string]string) error {
+	if err := l.request(ctx, "SetTags"); err != nil {
+		return err
+	}
+	return l.b.SetTags(ctx, objName, tags)
+}
+
+func (l *limitedBucket) FindByTags(ctx context.Context, filter map[string]string) ([]string, error) {
+	if err := l.request(ctx, "FindByTags"); err != nil {
+		return nil, err
+	}
+	return l.b.FindByTags(ctx, filter)
+}
+
+type limitedLifecycler struct {
+	l  *limitedBucket
+	lc bucket.Lifecycler
+}
+
+func (l limitedLifecycler) GetLifecycle(ctx context.Context) ([]bucket.LifecycleRule, error) {
+	if err := l.l.request(ctx, "GetLifecycle"); err != nil {
+		return nil, err
+	}
+	return l.lc.GetLifecycle(ctx)
+}
+
+func (l limitedLifecycler) SetLifecycle(ctx context.Context, rules []bucket.LifecycleRule) error {
+	if err := l.l.request(ctx, "SetLifecycle"); err != nil {
+		return err
+	}
+	return l.lc.SetLifecycle(ctx, rules)
+}
+
+type limitedClassSetter struct {
+	l  *limitedBucket
+	sc bucket.StorageClassSetter
+}
+
+func (l limitedClassSetter) SetStorageClass(ctx context.Context, objName string, class bucket.StorageClass) error {
+	if err := l.l.request(ctx, "SetStorageClass"); err != nil {
+		return err
+	}
+	return l.sc.SetStorageClass(ctx, objName, class)
+}
+
+// reader takes a token per byte after every read. Reads are capped at the limiter burst,
+// so a single read never has to wait for more than one burst to be refilled.
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	lim *Limiter
+}
+
+// newReader keeps io.Seeker of r, SDKs that hash the body before sending rewind it and then
+// pay for the bytes twice.
+func newReader(ctx context.Context, r io.Reader, lim *Limiter) io.Reader {
+	if lim == nil {
+		return r
+	}
+	lr := &reader{ctx: ctx, r: r, lim: lim}
+	if s, ok := r.(io.Seeker); ok {
+		return &readSeeker{reader: lr, Seeker: s}
+	}
+	return lr
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if burst := r.lim.Burst(); burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.lim.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+type readSeeker struct {
+	*reader
+	io.Seeker
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// writer takes the tokens of the bytes before they are written, in pieces of at most the limiter burst.
+// A failed wait aborts the upload, the partly written object must not be committed by Close.
+type writer struct {
+	ctx context.Context
+	w   bucket.Writer
+	lim *Limiter
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if burst := w.lim.Burst(); burst > 0 && len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+		if err := w.lim.WaitN(w.ctx, len(chunk)); err != nil {
+			w.w.CloseWithError(err)
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *writer) Close() error {
+	return w.w.Close()
+}
+
+func (w *writer) CloseWithError(err error) error {
+	return w.w.CloseWithError(err)
+}
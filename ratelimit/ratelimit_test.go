@@ -0,0 +1,244 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/bucket"
+	"git.epam.com/epm-gdsp/cloud-uploader-lab/local"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestService(t *testing.T) {
+	suite.Run(t, new(Suite))
+}
+
+type Suite struct {
+	suite.Suite
+	storage bucket.Bucket
+	now     time.Time
+}
+
+func (s *Suite) SetupTest() {
+	b, err := local.OpenBucket(context.Background(), s.T().TempDir())
+	s.Require().NoError(err)
+	s.storage = b
+	s.now = time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// fakeLimiter returns a limiter driven by s.now.
+func (s *Suite) fakeLimiter(rate float64, burst int) *Limiter {
+	l := NewLimiter(rate, burst)
+	l.now = func() time.Time { return s.now }
+	l.last = s.now
+	return l
+}
+
+// optionalBucket implements the optional interfaces of the bucket package on top of a local bucket.
+type optionalBucket struct {
+	bucket.Bucket
+	rules   []bucket.LifecycleRule
+	classes map[string]bucket.StorageClass
+}
+
+func (b *optionalBucket) GetLifecycle(_ context.Context) ([]bucket.LifecycleRule, error) {
+	return b.rules, nil
+}
+
+func (b *optionalBucket) SetLifecycle(_ context.Context, rules []bucket.LifecycleRule) error {
+	b.rules = rules
+	return nil
+}
+
+func (b *optionalBucket) SetStorageClass(_ context.Context, objName string, class bucket.StorageClass) error {
+	b.classes[objName] = class
+	return nil
+}
+
+func (s *Suite) TestWrapOptionalInterfaces() {
+	_, ok := Wrap(s.storage, Limits{}).(bucket.Lifecycler)
+	s.False(ok)
+	_, ok = Wrap(s.storage, Limits{}).(bucket.StorageClassSetter)
+	s.False(ok)
+
+	inner := &optionalBucket{Bucket: s.storage, classes: map[string]bucket.StorageClass{}}
+	b := Wrap(inner, Limits{Requests: map[string]*Limiter{
+		"SetLifecycle":    s.fakeLimiter(1, 1),
+		"SetStorageClass": s.fakeLimiter(1, 1),
+	}})
+	lc, ok := b.(bucket.Lifecycler)
+	s.Require().True(ok)
+	sc, ok := b.(bucket.StorageClassSetter)
+	s.Require().True(ok)
+
+	ctx := context.Background()
+	rules := []bucket.LifecycleRule{{ID: "expire", ExpireAfterDays: 1}}
+	s.Require().NoError(lc.SetLifecycle(ctx, rules))
+	got, err := lc.GetLifecycle(ctx)
+	s.Require().NoError(err)
+	s.Equal(rules, got)
+	s.Require().NoError(sc.SetStorageClass(ctx, "fileName", bucket.StorageClassArchive))
+	s.Equal(bucket.StorageClassArchive, inner.classes["fileName"])
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	s.ErrorIs(lc.SetLifecycle(cancelled, nil), context.Canceled)
+	s.ErrorIs(sc.SetStorageClass(cancelled, "fileName", bucket.StorageClassCold), context.Canceled)
+	s.Equal(rules, inner.rules, "the calls are limited as requests")
+	s.Equal(bucket.StorageClassArchive, inner.classes["fileName"])
+}
+
+func (s *Suite) TestReserveUnlimited() {
+	for _, rate := range []float64{0, -1} {
+		l := s.fakeLimiter(rate, 5)
+		s.Equal(time.Duration(0), l.reserve(5))
+		s.Equal(time.Duration(0), l.reserve(100), "rate %v", rate)
+	}
+}
+
+func (s *Suite) TestReserve() {
+	l := s.fakeLimiter(10, 5)
+
+	s.Equal(time.Duration(0), l.reserve(5))
+	s.Equal(100*time.Millisecond, l.reserve(1))
+	s.Equal(300*time.Millisecond, l.reserve(2))
+
+	s.now = s.now.Add(time.Second)
+	s.Equal(time.Duration(0), l.reserve(5))
+
+	s.now = s.now.Add(time.Hour)
+	s.Equal(time.Duration(0), l.reserve(5))
+	s.Equal(100*time.Millisecond, l.reserve(1), "tokens are capped at burst")
+}
+
+func (s *Suite) TestWaitNCancelled() {
+	l := s.fakeLimiter(1, 1)
+	s.Require().Equal(time.Duration(0), l.reserve(1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.ErrorIs(l.WaitN(ctx, 10), context.Canceled)
+	s.Equal(time.Duration(0), l.reserve(0), "cancelled tokens are returned")
+}
+
+func (s *Suite) TestRequests() {
+	ctx := context.Background()
+	deletes := s.fakeLimiter(1, 1)
+	b := Wrap(s.storage, Limits{Requests: map[string]*Limiter{"Delete": deletes}})
+	s.Require().NoError(b.UploadBytes(ctx, []byte("abc"), "fileName"))
+
+	s.NoError(b.Delete(ctx, "fileName"))
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	s.ErrorIs(b.Delete(cancelled, "fileName"), context.Canceled)
+	_, err := b.List(cancelled, "")
+	s.NoError(err, "methods without a limiter are not limited")
+}
+
+func (s *Suite) TestSharedLimiter() {
+	ctx := context.Background()
+	all := s.fakeLimiter(1, 1)
+	first := Wrap(s.storage, Limits{AllRequests: all})
+	second := Wrap(s.storage, Limits{AllRequests: all})
+
+	_, err := first.List(ctx, "")
+	s.NoError(err)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = second.List(cancelled, "")
+	s.ErrorIs(err, context.Canceled)
+}
+
+func (s *Suite) TestBytes() {
+	ctx := context.Background()
+	limits := Limits{
+		UploadBytes:   NewLimiter(1<<20, 4),
+		DownloadBytes: NewLimiter(1<<20, 4),
+	}
+	b := Wrap(s.storage, limits)
+
+	err := b.UploadByChunks(ctx, strings.NewReader("abcdefghij"), "fileName")
+	s.NoError(err)
+
+	rc, err := b.DownloadByChunks(ctx, "fileName")
+	s.Require().NoError(err)
+	defer rc.Close()
+	buf := make([]byte, 10)
+	n, err := rc.Read(buf)
+	s.NoError(err)
+	s.Equal(4, n, "reads are capped at the burst")
+	rest, err := io.ReadAll(rc)
+	s.NoError(err)
+	s.Equal("abcdefghij", string(buf[:n])+string(rest))
+}
+
+// TestBytesPaced checks that UploadBytes and DownloadBytes move the data as the tokens come in, not in one
+// burst before or after waiting for all of them.
+func (s *Suite) TestBytesPaced() {
+	ctx := context.Background()
+	s.Require().NoError(s.storage.UploadBytes(ctx, []byte("abcdefghij"), "fileName"))
+	b := Wrap(s.storage, Limits{UploadBytes: s.fakeLimiter(1, 4), DownloadBytes: s.fakeLimiter(1, 4)})
+
+	var uploaded, downloaded bucket.Progress
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err := b.UploadBytes(timeout, []byte("0123456789"), "other", bucket.WithProgress(func(p bucket.Progress) { uploaded = p }))
+	s.ErrorIs(err, context.DeadlineExceeded)
+	s.Positive(uploaded.Transferred, "the first burst is sent before waiting for the rest")
+	s.Less(uploaded.Transferred, int64(10))
+	_, err = s.storage.Stat(ctx, "other")
+	s.Error(err)
+
+	timeout, cancel = context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = b.DownloadBytes(timeout, "fileName", bucket.WithProgress(func(p bucket.Progress) { downloaded = p }))
+	s.ErrorIs(err, context.DeadlineExceeded)
+	s.Positive(downloaded.Transferred)
+	s.Less(downloaded.Transferred, int64(10), "the object is read no faster than the tokens come in")
+}
+
+func (s *Suite) TestReaderKeepsSeeker() {
+	r := newReader(context.Background(), bytes.NewReader([]byte("abc")), NewLimiter(100, 10))
+	_, ok := r.(io.Seeker)
+	s.True(ok)
+
+	r = newReader(context.Background(), io.LimitReader(strings.NewReader("abc"), 3), NewLimiter(100, 10))
+	_, ok = r.(io.Seeker)
+	s.False(ok)
+}
+
+func (s *Suite) TestWriter() {
+	ctx := context.Background()
+	b := Wrap(s.storage, Limits{UploadBytes: NewLimiter(1<<20, 4)})
+
+	w := b.NewWriter(ctx, "fileName")
+	n, err := io.WriteString(w, "abcdefghij")
+	s.NoError(err)
+	s.Equal(10, n, "writes are split at the burst")
+	s.NoError(w.Close())
+	data, err := s.storage.DownloadBytes(ctx, "fileName")
+	s.NoError(err)
+	s.Equal("abcdefghij", string(data))
+
+	// a cancelled wait aborts the upload instead of leaving it to Close
+	cancelled, cancel := context.WithCancel(ctx)
+	b = Wrap(s.storage, Limits{UploadBytes: s.fakeLimiter(1, 4)})
+	w = b.NewWriter(cancelled, "other")
+	cancel()
+	_, err = io.WriteString(w, "abcdefghij")
+	s.ErrorIs(err, context.Canceled)
+	s.ErrorIs(w.Close(), context.Canceled)
+	_, err = s.storage.Stat(ctx, "other")
+	s.Error(err)
+
+	b = Wrap(s.storage, Limits{Requests: map[string]*Limiter{"NewWriter": s.fakeLimiter(1, 1)}})
+	s.NoError(b.NewWriter(ctx, "fileName").CloseWithError(nil))
+	s.ErrorIs(b.NewWriter(cancelled, "fileName").Close(), context.Canceled)
+}